@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dashboardPage is the web dashboard served at the admin API's root. It
+// holds no data itself and asks for the token to call the API with
+//
+//go:embed web/index.html
+var dashboardPage []byte
+
+// adminJob is a job as listed by the admin API
+type adminJob struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Paused   bool       `json:"paused"`
+	Running  bool       `json:"running"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+	LastRun  *adminRun  `json:"last_run,omitempty"`
+}
+
+// adminRun is a finished run as reported by the admin API
+type adminRun struct {
+	BackupID        string    `json:"backup_id"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Succeeded       bool      `json:"succeeded"`
+	Error           string    `json:"error,omitempty"`
+	Size            int64     `json:"size"`
+	Objects         []string  `json:"objects"`
+	Warnings        []string  `json:"warnings,omitempty"`
+}
+
+func newAdminRun(report RunReport) *adminRun {
+	return &adminRun{
+		BackupID:        report.BackupID,
+		Started:         report.Started,
+		DurationSeconds: report.Duration.Seconds(),
+		Succeeded:       report.Succeeded,
+		Error:           report.Error,
+		Size:            report.Size,
+		Objects:         report.Objects,
+		Warnings:        report.Warnings,
+	}
+}
+
+// errCancelledByAPI fails the runs cancelled through the admin API
+var errCancelledByAPI = errors.New("cancelled through the admin API")
+
+// adminHandler serves the admin API, which lists jobs and their recent
+// runs, triggers, cancels, pauses and resumes jobs and reloads the configuration.
+// Every request needs the bearer token
+func adminHandler(token string, tasks *taskScheduler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		jobs := []adminJob{}
+		for _, snapshot := range control.snapshot() {
+			job := adminJob{Name: snapshot.Name, Schedule: snapshot.Schedule, Paused: snapshot.Paused, Running: snapshot.Running}
+			if !snapshot.NextRun.IsZero() {
+				job.NextRun = &snapshot.NextRun
+			}
+			if len(snapshot.History) > 0 {
+				job.LastRun = newAdminRun(snapshot.History[len(snapshot.History)-1])
+			}
+			jobs = append(jobs, job)
+		}
+		writeJSON(w, http.StatusOK, jobs)
+	})
+	mux.HandleFunc("GET /api/jobs/{name}/runs", func(w http.ResponseWriter, r *http.Request) {
+		for _, snapshot := range control.snapshot() {
+			if snapshot.Name != r.PathValue("name") {
+				continue
+			}
+			// newest first
+			runs := []*adminRun{}
+			for i := len(snapshot.History) - 1; i >= 0; i-- {
+				runs = append(runs, newAdminRun(snapshot.History[i]))
+			}
+			writeJSON(w, http.StatusOK, runs)
+			return
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such job"})
+	})
+	mux.HandleFunc("POST /api/jobs/{name}/run", func(w http.ResponseWriter, r *http.Request) {
+		if err := control.trigger(r.PathValue("name")); err != nil {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		slog.Info("Backup task triggered through the admin API", slog.String("backup_task", r.PathValue("name")))
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("POST /api/jobs/{name}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		if err := control.cancel(r.PathValue("name"), errCancelledByAPI); err != nil {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		slog.Info("Backup task cancelled through the admin API", slog.String("backup_task", r.PathValue("name")))
+		w.WriteHeader(http.StatusAccepted)
+	})
+	for action, paused := range map[string]bool{"pause": true, "resume": false} {
+		mux.HandleFunc("POST /api/jobs/{name}/"+action, func(w http.ResponseWriter, r *http.Request) {
+			if err := control.setPaused(r.PathValue("name"), paused); err != nil {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+				return
+			}
+			slog.Info("Backup task "+action+"d through the admin API", slog.String("backup_task", r.PathValue("name")))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	for action, paused := range map[string]bool{"pause": true, "resume": false} {
+		mux.HandleFunc("POST /api/"+action, func(w http.ResponseWriter, r *http.Request) {
+			running := control.setPausedAll(paused)
+			writeJSON(w, http.StatusOK, map[string]any{"paused": paused, "running": running})
+		})
+	}
+	mux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		running := 0
+		for _, snapshot := range control.snapshot() {
+			if snapshot.Running {
+				running++
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"paused": control.isPausedAll(), "running": running})
+	})
+	mux.HandleFunc("POST /api/reload", func(w http.ResponseWriter, r *http.Request) {
+		delta, err := tasks.reloadLogged("admin API")
+		if err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, delta)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" && r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(dashboardPage)
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+// serveAdmin serves the admin API on the address in the background
+func serveAdmin(address, token string, tasks *taskScheduler) {
+	go func() {
+		slog.Info("Serving the admin API", slog.String("address", address))
+		if err := http.ListenAndServe(address, adminHandler(token, tasks)); err != nil {
+			slog.Error("Admin API listener stopped", slog.String("error", err.Error()))
+		}
+	}()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// tarDirectory archives the contents of dir into a tar file inside
+// outputDir and returns its path. Entries are stored relative to dir,
+// symlinks are kept as links, special files and excluded paths are skipped
+func tarDirectory(ctx context.Context, dir, outputDir string, exclude *excludeMatcher) (string, error) {
+	output := filepath.Join(outputDir, filepath.Base(filepath.Clean(dir))+".tar")
+	file, err := os.Create(output)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	writer := tar.NewWriter(file)
+	err = filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		if exclude.excluded(filepath.ToSlash(rel), entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return addToTar(writer, p, filepath.ToSlash(rel), entry)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to archive %s: %s", dir, err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return output, file.Close()
+}
+
+func addToTar(writer *tar.Writer, filePath, name string, entry fs.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() && !info.IsDir() && info.Mode()&fs.ModeSymlink == 0 {
+		return nil
+	}
+
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(filePath); err != nil {
+			return err
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(writer, file)
+	return err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// stringList accepts either a single string or a list of strings in the
+// configuration file
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = stringList{value.Value}
+		return nil
+	}
+	var values []string
+	if err := value.Decode(&values); err != nil {
+		return err
+	}
+	*l = values
+	return nil
+}
+
+// expandTargets resolves the task's upload targets to the paths that exist
+// after its script ran. Glob patterns must match at least one path; their
+// matches are filtered through the exclude patterns
+func expandTargets(patterns []string, exclude *excludeMatcher) ([]string, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("filepath_to_upload is not set")
+	}
+
+	var targets []string
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+		if len(matches) == 0 {
+			if _, err := validateFile(pattern); err != nil {
+				return nil, err
+			}
+			matches = []string{pattern}
+		}
+		base := globBase(pattern)
+		for _, match := range matches {
+			if match != pattern && excludedMatch(exclude, base, match) {
+				continue
+			}
+			if !seen[match] {
+				seen[match] = true
+				targets = append(targets, match)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("every file matching filepath_to_upload is excluded")
+	}
+	return targets, nil
+}
+
+// globBase is the part of a glob pattern before its first wildcard, which
+// the matches' exclude rules are relative to
+func globBase(pattern string) string {
+	base := pattern
+	for hasGlobMeta(base) {
+		base = filepath.Dir(base)
+	}
+	return base
+}
+
+func hasGlobMeta(pattern string) bool {
+	magic := `*?[`
+	if runtime.GOOS != "windows" {
+		magic = `*?[\`
+	}
+	return strings.ContainsAny(pattern, magic)
+}
+
+// excludedMatch checks a glob match against the exclude rules by its path
+// below the glob's base directory, the way an archived directory's files
+// are checked by their path below it
+func excludedMatch(exclude *excludeMatcher, base, match string) bool {
+	name, err := filepath.Rel(base, match)
+	if err != nil {
+		return false
+	}
+	info, err := os.Lstat(match)
+	return exclude.excluded(filepath.ToSlash(name), err == nil && info.IsDir())
+}
+
+// prepareArtifact turns an upload target into the file that is actually
+// uploaded: directories are archived, then the result is compressed and
+// encrypted as configured. It returns the file and the extension its
+// object name gets
+func (task BackupTask) prepareArtifact(ctx context.Context, target, workDir string, exclude *excludeMatcher) (string, string, error) {
+	info, err := validateFile(target)
+	if err != nil {
+		return "", "", err
+	}
+
+	artifactPath := target
+	extension := filepath.Ext(target)
+	if info.IsDir() {
+		if artifactPath, err = tarDirectory(ctx, target, workDir, exclude); err != nil {
+			return "", "", fmt.Errorf("failed to archive the backup directory: %s", err)
+		}
+		extension = ".tar"
+	}
+	// compress first, encrypted data doesn't compress
+	if task.Compression != nil {
+		if artifactPath, err = task.Compression.compress(ctx, artifactPath, workDir); err != nil {
+			return "", "", fmt.Errorf("failed to compress the backup file: %s", err)
+		}
+		extension += task.Compression.extension()
+	}
+	if task.Encryption != nil && task.Encryption.enabled() {
+		if artifactPath, err = task.Encryption.encrypt(ctx, artifactPath, workDir); err != nil {
+			return "", "", fmt.Errorf("failed to encrypt the backup file: %s", err)
+		}
+		extension += task.Encryption.extension()
+	}
+	return artifactPath, extension, nil
+}
+
+// artifactExtension names an artifact of a run with several targets after
+// its source, e.g. ".users.sql.gz", so they can share the run's backup ID
+func artifactExtension(target, extension string) string {
+	base := filepath.Base(filepath.Clean(target))
+	return "." + base[:len(base)-len(filepath.Ext(base))] + extension
+}
+
+// targetWorkDir gives each target of a run its own scratch directory, so
+// targets with the same base name don't overwrite each other's artifacts
+func targetWorkDir(tempDir string, index, count int) (string, error) {
+	if count == 1 {
+		return tempDir, nil
+	}
+	dir := filepath.Join(tempDir, fmt.Sprintf("artifact-%d", index))
+	return dir, os.MkdirAll(dir, 0o750)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExpandTargetsWithExcludes(t *testing.T) {
+	// the backups live below a directory named like an exclude rule, which
+	// mustn't matter as it's above the glob
+	root := filepath.Join(t.TempDir(), "var", "cache")
+	for _, name := range []string{"dumps/a.sql", "dumps/b.log", "other/c.log"} {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(file), 0o700)
+		os.WriteFile(file, []byte("x"), 0o600)
+	}
+	os.MkdirAll(filepath.Join(root, "dumps", "tmp"), 0o700)
+
+	tests := []struct {
+		name     string
+		pattern  string
+		exclude  []string
+		expected []string
+	}{
+		{"parent directory is not matched", "dumps/*", []string{"cache/"}, []string{"dumps/a.sql", "dumps/b.log", "dumps/tmp"}},
+		{"anchored", "*/*", []string{"dumps/*.log"}, []string{"dumps/a.sql", "dumps/tmp", "other/c.log"}},
+		{"any depth", "*/*", []string{"*.log"}, []string{"dumps/a.sql", "dumps/tmp"}},
+		{"directory only", "dumps/*", []string{"tmp/", "a.sql/"}, []string{"dumps/a.sql", "dumps/b.log"}},
+		{"re-included", "*/*", []string{"*.log", "!c.log"}, []string{"dumps/a.sql", "dumps/tmp", "other/c.log"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exclude, err := newExcludeMatcher(test.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			targets, err := expandTargets([]string{filepath.Join(root, test.pattern)}, exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, target := range targets {
+				name, _ := filepath.Rel(root, target)
+				got = append(got, filepath.ToSlash(name))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, test.expected) {
+				t.Errorf("got %v, want %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestGlobBase(t *testing.T) {
+	tests := []struct {
+		pattern string
+		base    string
+	}{
+		{"/var/backups/*.sql", "/var/backups"},
+		{"/var/*/dumps/*.sql", "/var"},
+		{"dumps/day-?/*", "dumps"},
+		{"*.sql", "."},
+		{"/var/backups/app.sql", "/var/backups/app.sql"},
+	}
+	for _, test := range tests {
+		if base := globBase(filepath.FromSlash(test.pattern)); base != filepath.FromSlash(test.base) {
+			t.Errorf("globBase(%q) = %q, want %q", test.pattern, base, test.base)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// BlackoutWindow is a recurring period, such as a weekly maintenance
+// window, in which runs are deferred until it closes. Windows whose To is
+// not after From run past midnight
+type BlackoutWindow struct {
+	// Days are the weekdays the window starts on, every day when empty
+	Days []string `yaml:"days"`
+	From string   `yaml:"from"`
+	To   string   `yaml:"to"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(name)
+	if len(name) < 3 {
+		return 0, false
+	}
+	day, ok := weekdays[name[:3]]
+	return day, ok
+}
+
+func (window BlackoutWindow) validate() error {
+	for _, day := range window.Days {
+		if _, ok := parseWeekday(day); !ok {
+			return fmt.Errorf("unknown blackout day %q", day)
+		}
+	}
+	if _, err := time.Parse("15:04", window.From); err != nil {
+		return fmt.Errorf("blackout from must be a time like 01:00")
+	}
+	if _, err := time.Parse("15:04", window.To); err != nil {
+		return fmt.Errorf("blackout to must be a time like 03:00")
+	}
+	return nil
+}
+
+func (window BlackoutWindow) startsOn(day time.Weekday) bool {
+	if len(window.Days) == 0 {
+		return true
+	}
+	for _, name := range window.Days {
+		if weekday, _ := parseWeekday(name); weekday == day {
+			return true
+		}
+	}
+	return false
+}
+
+// end returns when the window that t falls in closes, if it does
+func (window BlackoutWindow) end(t time.Time) (time.Time, bool) {
+	from, _ := time.Parse("15:04", window.From)
+	to, _ := time.Parse("15:04", window.To)
+	length := to.Sub(from)
+	if length <= 0 {
+		length += 24 * time.Hour
+	}
+	// the window may have started the day before and still be open
+	for _, offset := range []int{0, -1} {
+		day := t.AddDate(0, 0, offset)
+		start := time.Date(day.Year(), day.Month(), day.Day(), from.Hour(), from.Minute(), 0, 0, t.Location())
+		if window.startsOn(start.Weekday()) && !t.Before(start) && t.Before(start.Add(length)) {
+			return start.Add(length), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// waitForBlackout defers the run until none of the task's blackout windows
+// is open, in the task's timezone
+func (task BackupTask) waitForBlackout(logger *slog.Logger) {
+	location := time.Local
+	if task.Timezone != "" {
+		if loaded, err := time.LoadLocation(task.Timezone); err == nil {
+			location = loaded
+		}
+	}
+	for {
+		now := time.Now().In(location)
+		var until time.Time
+		for _, window := range task.Blackout {
+			if end, ok := window.end(now); ok && end.After(until) {
+				until = end
+			}
+		}
+		if until.IsZero() {
+			return
+		}
+		logger.Info("Deferring backup task until the blackout window closes", slog.Time("until", until))
+		time.Sleep(until.Sub(now))
+	}
+}
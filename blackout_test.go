@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlackoutWindowEnd(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	nightly := BlackoutWindow{From: "01:00", To: "03:00"}
+	weekend := BlackoutWindow{Days: []string{"Friday"}, From: "23:00", To: "02:00"}
+	mondays := BlackoutWindow{Days: []string{"mon"}, From: "01:00", To: "03:00"}
+
+	// 2024-03-04 is a Monday
+	tests := []struct {
+		name   string
+		window BlackoutWindow
+		t      string
+		end    string
+	}{
+		{"before", nightly, "2024-03-04 00:59", ""},
+		{"at the start", nightly, "2024-03-04 01:00", "2024-03-04 03:00"},
+		{"inside", nightly, "2024-03-04 02:30", "2024-03-04 03:00"},
+		{"at the end", nightly, "2024-03-04 03:00", ""},
+		{"past midnight, before it", weekend, "2024-03-08 23:30", "2024-03-09 02:00"},
+		{"past midnight, after it", weekend, "2024-03-09 01:00", "2024-03-09 02:00"},
+		{"past midnight, closed", weekend, "2024-03-09 02:00", ""},
+		{"past midnight, other day", weekend, "2024-03-10 01:00", ""},
+		{"on its day", mondays, "2024-03-04 02:00", "2024-03-04 03:00"},
+		{"on another day", mondays, "2024-03-05 02:00", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			end, ok := test.window.end(at(test.t))
+			if test.end == "" {
+				if ok {
+					t.Errorf("open until %s, want closed", end)
+				}
+				return
+			}
+			if !ok || !end.Equal(at(test.end)) {
+				t.Errorf("got %s, %v, want open until %s", end, ok, test.end)
+			}
+		})
+	}
+}
+
+func TestBlackoutWindowValidate(t *testing.T) {
+	tests := []struct {
+		window BlackoutWindow
+		valid  bool
+	}{
+		{BlackoutWindow{From: "01:00", To: "03:00"}, true},
+		{BlackoutWindow{Days: []string{"Sat", "sunday"}, From: "22:00", To: "06:00"}, true},
+		{BlackoutWindow{Days: []string{"someday"}, From: "01:00", To: "03:00"}, false},
+		{BlackoutWindow{Days: []string{"mo"}, From: "01:00", To: "03:00"}, false},
+		{BlackoutWindow{From: "1am", To: "03:00"}, false},
+		{BlackoutWindow{From: "01:00"}, false},
+	}
+	for _, test := range tests {
+		if err := test.window.validate(); (err == nil) != test.valid {
+			t.Errorf("validate(%+v) = %v, want valid %v", test.window, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ByteSize is a number of bytes that can be written in the configuration
+// file with a decimal ("500MB") or binary ("5GiB") unit
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9}, {"tb", 1e12},
+	{"b", 1},
+}
+
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := parseByteSize(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %s", value.Line, err)
+	}
+	*b = ByteSize(parsed)
+	return nil
+}
+
+// Decode reads the size from an environment variable
+func (b *ByteSize) Decode(value string) error {
+	parsed, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*b = ByteSize(parsed)
+	return nil
+}
+
+func (b ByteSize) String() string {
+	for i := 3; i >= 0; i-- {
+		if unit := byteSizeUnits[i]; int64(b) >= unit.size {
+			return fmt.Sprintf("%.1f%ciB", float64(b)/float64(unit.size), strings.ToUpper(unit.suffix)[0])
+		}
+	}
+	return strconv.FormatInt(int64(b), 10) + "B"
+}
+
+func parseByteSize(value string) (int64, error) {
+	number := strings.ToLower(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range byteSizeUnits {
+		if rest, ok := strings.CutSuffix(number, unit.suffix); ok {
+			number, multiplier = strings.TrimSpace(rest), unit.size
+			break
+		}
+	}
+	parsed, err := strconv.ParseFloat(number, 64)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(parsed * float64(multiplier)), nil
+}
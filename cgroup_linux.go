@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+var (
+	cgroupSetup    sync.Once
+	cgroupSetupErr error
+	// cgroupParentPath is the directory run cgroups are created in, found
+	// once, before the scheduler possibly moves into a child cgroup
+	cgroupParentPath string
+)
+
+// runCgroup is the cgroup the scripts of one run are started in
+type runCgroup struct {
+	dir string
+	fd  *os.File
+}
+
+func checkCgroupSupport() error {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("resource limits need cgroup v2 mounted at %s", cgroupRoot)
+	}
+	return nil
+}
+
+// cgroupParentDir returns CGROUP_PARENT, or the cgroup the scheduler runs in
+func cgroupParentDir() (string, error) {
+	if cgroupParent != "" {
+		return filepath.Join(cgroupRoot, strings.TrimPrefix(cgroupParent, cgroupRoot)), nil
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(cgroupRoot, path), nil
+		}
+	}
+	return "", fmt.Errorf("the scheduler doesn't run in a cgroup v2")
+}
+
+// enableControllers lets the parent's child cgroups limit CPU, memory and
+// IO. A cgroup with processes can't do that, so when the parent is the
+// scheduler's own, the scheduler first moves into a child of it
+func enableControllers(parent string) error {
+	control := filepath.Join(parent, "cgroup.subtree_control")
+	err := os.WriteFile(control, []byte("+cpu +memory +io"), 0)
+	if !errors.Is(err, syscall.EBUSY) {
+		return err
+	}
+	leaf := filepath.Join(parent, "scheduler")
+	if err := os.Mkdir(leaf, 0o755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(fmt.Sprint(os.Getpid())), 0); err != nil {
+		return err
+	}
+	return os.WriteFile(control, []byte("+cpu +memory +io"), 0)
+}
+
+// newRunCgroup creates a cgroup with the limits for one run
+func newRunCgroup(name string, limits *ResourceLimits) (*runCgroup, error) {
+	cgroupSetup.Do(func() {
+		if cgroupParentPath, cgroupSetupErr = cgroupParentDir(); cgroupSetupErr != nil {
+			return
+		}
+		if err := enableControllers(cgroupParentPath); err != nil {
+			cgroupSetupErr = fmt.Errorf("failed to enable cgroup controllers in %s: %s", cgroupParentPath, err)
+		}
+	})
+	if cgroupSetupErr != nil {
+		return nil, cgroupSetupErr
+	}
+
+	dir := filepath.Join(cgroupParentPath, name)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, err
+	}
+	cgroup := &runCgroup{dir: dir}
+	settings := map[string]string{}
+	if limits.CPU > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d 100000", int64(limits.CPU*100000))
+	}
+	if limits.Memory > 0 {
+		settings["memory.max"] = fmt.Sprint(int64(limits.Memory))
+	}
+	if limits.IOWeight > 0 {
+		settings["io.weight"] = fmt.Sprintf("default %d", limits.IOWeight)
+	}
+	for file, value := range settings {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0); err != nil {
+			cgroup.remove()
+			return nil, fmt.Errorf("failed to set %s: %s", file, err)
+		}
+	}
+	var err error
+	if cgroup.fd, err = os.Open(dir); err != nil {
+		cgroup.remove()
+		return nil, err
+	}
+	return cgroup, nil
+}
+
+// apply starts the command right in the cgroup, so nothing it forks
+// escapes the limits
+func (c *runCgroup) apply(attr *syscall.SysProcAttr) {
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(c.fd.Fd())
+}
+
+// remove deletes the cgroup, which only works once its processes are gone
+func (c *runCgroup) remove() error {
+	if c.fd != nil {
+		c.fd.Close()
+	}
+	return os.Remove(c.dir)
+}
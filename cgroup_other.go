@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// runCgroup is only available on Linux
+type runCgroup struct{}
+
+func checkCgroupSupport() error {
+	return fmt.Errorf("cpu, memory and io_weight limits need Linux cgroup v2")
+}
+
+func newRunCgroup(name string, limits *ResourceLimits) (*runCgroup, error) {
+	return nil, checkCgroupSupport()
+}
+
+func (c *runCgroup) apply(attr *syscall.SysProcAttr) {}
+
+func (c *runCgroup) remove() error { return nil }
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// runCommand dispatches a CLI subcommand and returns the process exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "prune":
+		return pruneCommand(args[1:])
+	case "verify":
+		return verifyCommand(args[1:])
+	case "restore":
+		return restoreCommand(args[1:])
+	case "backups":
+		return backupsCommand(args[1:])
+	case "list":
+		return listCommand(args[1:])
+	case "validate":
+		return validateCommand(args[1:])
+	case "tui":
+		return tuiCommand(args[1:])
+	case "run":
+		return runJobCommand(args[1:])
+	case "--once", "-once":
+		return onceCommand(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		return 2
+	}
+}
+
+// pruneCommand applies the configured retention policies right away,
+// without waiting for the jobs' next uploads
+func pruneCommand(args []string) int {
+	flags := flag.NewFlagSet("prune", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only log which backups would be deleted")
+	jobName := flags.String("job", "", "only prune the backups of this job")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	_, storage, backupPlans, err := loadEnvironment()
+	if err != nil {
+		slog.Error("Failed to initialize", slog.String("error", err.Error()))
+		return 1
+	}
+
+	found, failed := false, false
+	for _, task := range backupPlans.Tasks {
+		if *jobName != "" && task.Name != *jobName {
+			continue
+		}
+		found = true
+		logger := slog.With(slog.String("backup_task", task.Name))
+		if task.Retention == nil {
+			logger.Info("No retention policy configured, skipping")
+			continue
+		}
+
+		destinations, err := task.resolveDestinations(storage)
+		if err != nil {
+			logger.Error("Failed to initialize task storage", slog.String("error", err.Error()))
+			failed = true
+			continue
+		}
+		for _, destination := range destinations {
+			if err := task.applyRetention(context.Background(), destination, logger, *dryRun); err != nil {
+				failed = true
+			}
+		}
+	}
+
+	if *jobName != "" && !found {
+		slog.Error("No such job", slog.String("backup_task", *jobName))
+		return 1
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// onceCommand runs the named jobs, or all of them, right away and waits for
+// them, so an external driver such as a Kubernetes CronJob can schedule
+// them. Jobs run after the selected jobs they come after
+func onceCommand(names []string) int {
+	settings, storage, backupPlans, err := loadEnvironment()
+	if err != nil {
+		slog.Error("Failed to initialize", slog.String("error", err.Error()))
+		return 1
+	}
+	if err := initialize(settings, backupPlans); err != nil {
+		logProblems("Failed to initialize", err)
+		return 1
+	}
+
+	for _, name := range names {
+		if !slices.ContainsFunc(backupPlans.Tasks, func(task BackupTask) bool { return task.Name == name }) {
+			slog.Error("No such job", slog.String("backup_task", name))
+			return 1
+		}
+	}
+	var selected []BackupTask
+	for _, task := range backupPlans.enabledTasks() {
+		if len(names) == 0 || slices.Contains(names, task.Name) {
+			selected = append(selected, task)
+		}
+	}
+
+	// dependencies on jobs that don't run are dropped, and every job is
+	// placed in a stage after the stages of the jobs it comes after
+	stages := map[string]int{}
+	var stageOf func(task BackupTask) int
+	stageOf = func(task BackupTask) int {
+		if stage, ok := stages[task.Name]; ok {
+			return stage
+		}
+		stage := 0
+		for _, other := range selected {
+			if slices.Contains(task.After, other.Name) {
+				stage = max(stage, stageOf(other)+1)
+			}
+		}
+		stages[task.Name] = stage
+		return stage
+	}
+
+	runs := map[int][]func(){}
+	last := 0
+	for _, task := range selected {
+		if err := task.validate(); err != nil {
+			slog.Error("Invalid backup task", slog.String("error", err.Error()), slog.String("backup_task", task.Name))
+			return 1
+		}
+		destinations, err := task.resolveDestinations(storage)
+		if err != nil {
+			slog.Error("Failed to initialize task storage", slog.String("error", err.Error()), slog.String("backup_task", task.Name))
+			return 1
+		}
+		task.After = slices.DeleteFunc(slices.Clone(task.After), func(name string) bool {
+			return !slices.ContainsFunc(selected, func(task BackupTask) bool { return task.Name == name })
+		})
+		metrics.register(task.Name)
+		stage := stageOf(task)
+		runs[stage] = append(runs[stage], task.Execute(destinations))
+		last = max(last, stage)
+	}
+
+	for stage := 0; stage <= last; stage++ {
+		var wg sync.WaitGroup
+		for _, run := range runs[stage] {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				run()
+			}()
+		}
+		wg.Wait()
+	}
+
+	// jobs skipped because a job they come after failed count as failed
+	failed := false
+	for _, task := range selected {
+		if !task.isDryRun() && !latestSucceeded[task.Name] {
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// runJobCommand runs one job in the foreground right away, ignoring its
+// jitter, blackout windows, dependencies and enabled flag, to try out a
+// job definition. The script's output is logged as it is written
+func runJobCommand(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: run <job>")
+		return 2
+	}
+	name := flags.Arg(0)
+
+	settings, storage, backupPlans, err := loadEnvironment()
+	if err != nil {
+		slog.Error("Failed to initialize", slog.String("error", err.Error()))
+		return 1
+	}
+	if err := initialize(settings, backupPlans); err != nil {
+		logProblems("Failed to initialize", err)
+		return 1
+	}
+
+	index := slices.IndexFunc(backupPlans.Tasks, func(task BackupTask) bool { return task.Name == name })
+	if index < 0 {
+		slog.Error("No such job", slog.String("backup_task", name))
+		return 1
+	}
+	task := backupPlans.Tasks[index]
+	task.Jitter = 0
+	task.Blackout = nil
+	task.After = nil
+	if err := task.validate(); err != nil {
+		slog.Error("Invalid backup task", slog.String("error", err.Error()), slog.String("backup_task", task.Name))
+		return 1
+	}
+	destinations, err := task.resolveDestinations(storage)
+	if err != nil {
+		slog.Error("Failed to initialize task storage", slog.String("error", err.Error()), slog.String("backup_task", task.Name))
+		return 1
+	}
+
+	metrics.register(task.Name)
+	task.Execute(destinations)()
+	if !task.isDryRun() && !latestSucceeded[task.Name] {
+		return 1
+	}
+	return 0
+}
+
+// validateCommand checks the configuration without scheduling anything: the
+// settings and every job are validated, script files referenced by path
+// must exist and every storage must accept a listing with its credentials
+func validateCommand(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	settings, storage, backupPlans, err := loadEnvironment()
+	if err != nil {
+		slog.Error("Invalid configuration", slog.String("error", err.Error()))
+		return 1
+	}
+
+	valid := true
+	if err := initialize(settings, backupPlans); err != nil {
+		logProblems("Invalid configuration", err)
+		valid = false
+	}
+	if err := checkStorage(storage); err != nil {
+		slog.Error("Storage is not accessible", slog.String("error", err.Error()))
+		valid = false
+	}
+
+	for _, task := range backupPlans.Tasks {
+		logger := slog.With(slog.String("backup_task", task.Name))
+		for _, path := range scriptFiles(task.Commands) {
+			if _, err := os.Stat(path); err != nil {
+				logger.Error("Script file not found", slog.String("path", path))
+				valid = false
+			}
+		}
+
+		// the global storage was checked above
+		if task.Storage == nil && len(task.Destinations) == 0 {
+			continue
+		}
+		destinations, err := task.resolveDestinations(storage)
+		if err != nil {
+			logger.Error("Failed to initialize task storage", slog.String("error", err.Error()))
+			valid = false
+			continue
+		}
+		for _, destination := range destinations {
+			if err := checkStorage(destination.Storage); err != nil {
+				logger.Error("Storage is not accessible", slog.String("destination", destination.Name), slog.String("error", err.Error()))
+				valid = false
+			}
+		}
+	}
+
+	if !valid {
+		return 1
+	}
+	slog.Info("Configuration is valid", slog.Int("jobs", len(backupPlans.Tasks)))
+	return 0
+}
+
+// checkStorage exercises the storage's credentials with a listing that
+// matches no objects
+func checkStorage(storage Storage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := storage.List(ctx, ".poc-gocron-validate")
+	return err
+}
+
+// scriptFiles returns the files the script lines run by path, such as
+// ./dump.sh or /usr/local/bin/backup
+func scriptFiles(commands []string) []string {
+	var files []string
+	for _, command := range commands {
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "/") || strings.HasPrefix(fields[0], "./") || strings.HasPrefix(fields[0], "../") {
+			files = append(files, filepath.Clean(fields[0]))
+		}
+	}
+	return files
+}
+
+// jobListing describes a job for the list command
+type jobListing struct {
+	Name     string      `json:"name"`
+	Enabled  bool        `json:"enabled"`
+	Schedule []string    `json:"schedule,omitempty"`
+	Every    string      `json:"every,omitempty"`
+	After    []string    `json:"after,omitempty"`
+	Timezone string      `json:"timezone,omitempty"`
+	NextRuns []time.Time `json:"next_runs"`
+
+	description string
+}
+
+// listCommand prints every job with its schedule and next firing times,
+// to sanity-check schedules after editing them. It only reads the
+// configuration file
+func listCommand(args []string) int {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	count := flags.Int("n", 5, "how many upcoming runs to show per job")
+	asJSON := flags.Bool("json", false, "print JSON instead of a table")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var settings Config
+	if err := envconfig.Process("", &settings); err != nil {
+		slog.Error("Failed to load environment variables", slog.String("error", err.Error()))
+		return 1
+	}
+	if err := settings.loadSecretFiles(); err != nil {
+		slog.Error("Failed to load environment variables", slog.String("error", err.Error()))
+		return 1
+	}
+	// for a configuration file in S3
+	defaultStorageDetails = settings.StorageConfig
+	var backupPlans BackupSpecifications
+	if err := loadBackupConfig(settings.PathToConfig, &backupPlans); err != nil {
+		slog.Error("Failed to load backup configuration", slog.String("error", err.Error()))
+		return 1
+	}
+
+	now := time.Now()
+	var listings []jobListing
+	for _, task := range backupPlans.Tasks {
+		listing := jobListing{
+			Name:     task.Name,
+			Enabled:  task.Enabled == nil || *task.Enabled,
+			Schedule: task.Schedule,
+			After:    task.After,
+			Timezone: task.Timezone,
+			NextRuns: []time.Time{},
+
+			description: task.scheduleDescription(),
+		}
+		switch {
+		case task.Every > 0:
+			// intervals are counted from when the scheduler starts
+			listing.Every = time.Duration(task.Every).String()
+			for i := 1; i <= *count; i++ {
+				listing.NextRuns = append(listing.NextRuns, now.Add(time.Duration(i)*time.Duration(task.Every)))
+			}
+		case len(task.Schedule) > 0:
+			schedule, err := task.cronSchedule()
+			if err != nil {
+				slog.Error("Invalid schedule", slog.String("error", err.Error()), slog.String("backup_task", task.Name))
+				return 1
+			}
+			next := now
+			for i := 0; i < *count; i++ {
+				next = schedule.Next(next)
+				if next.IsZero() {
+					break
+				}
+				listing.NextRuns = append(listing.NextRuns, next)
+			}
+		}
+		listings = append(listings, listing)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(listings); err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "JOB\tSCHEDULE\tTIMEZONE\tNEXT RUNS")
+	for _, listing := range listings {
+		schedule := listing.description
+		if !listing.Enabled {
+			schedule += " (disabled)"
+		}
+		timezone := listing.Timezone
+		if timezone == "" {
+			timezone = "Local"
+		}
+		var runs []string
+		for _, run := range listing.NextRuns {
+			runs = append(runs, run.Format("2006-01-02 15:04:05 MST"))
+		}
+		if len(runs) == 0 {
+			runs = []string{"-"}
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", listing.Name, schedule, timezone, runs[0])
+		for _, run := range runs[1:] {
+			fmt.Fprintf(writer, "\t\t\t%s\n", run)
+		}
+	}
+	writer.Flush()
+	return 0
+}
+
+// backupsCommand browses the uploaded artifacts; "backups list" prints them
+// per job and destination, newest first
+func backupsCommand(args []string) int {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintln(os.Stderr, "usage: backups list [-job name] [-since 7d|2006-01-02] [-limit n]")
+		return 2
+	}
+	flags := flag.NewFlagSet("backups list", flag.ContinueOnError)
+	jobName := flags.String("job", "", "only list the backups of this job")
+	since := flags.String("since", "", "only list backups newer than a duration ago, e.g. 7d, or a date")
+	limit := flags.Int("limit", 0, "list at most this many objects per job and destination")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	var after time.Time
+	if *since != "" {
+		var err error
+		after, err = parseSince(*since, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	_, storage, backupPlans, err := loadEnvironment()
+	if err != nil {
+		slog.Error("Failed to initialize", slog.String("error", err.Error()))
+		return 1
+	}
+
+	found, failed := false, false
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "JOB\tDESTINATION\tTIME\tSIZE\tOBJECT")
+	for _, task := range backupPlans.Tasks {
+		if *jobName != "" && task.Name != *jobName {
+			continue
+		}
+		found = true
+		destinations, err := task.resolveDestinations(storage)
+		if err != nil {
+			slog.Error("Failed to initialize task storage", slog.String("error", err.Error()), slog.String("backup_task", task.Name))
+			failed = true
+			continue
+		}
+		for _, destination := range destinations {
+			backups, err := backupsOf(context.Background(), destination.Storage, task.Name)
+			if err != nil {
+				slog.Error("Failed to list backups", slog.String("error", err.Error()), slog.String("backup_task", task.Name), slog.String("destination", destination.Name))
+				failed = true
+				continue
+			}
+			listed := 0
+			for _, backup := range backups {
+				if backup.LastModified.Before(after) {
+					break
+				}
+				if *limit > 0 && listed == *limit {
+					break
+				}
+				fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", task.Name, destination.Name, backup.LastModified.Local().Format("2006-01-02 15:04:05"), ByteSize(backup.Size), backup.Name)
+				listed++
+			}
+		}
+	}
+	writer.Flush()
+
+	if *jobName != "" && !found {
+		slog.Error("No such job", slog.String("backup_task", *jobName))
+		return 1
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// parseSince reads a point in time given as a duration before now, such as
+// 7d or 12h, or as a date or RFC 3339 timestamp
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	ago, err := parseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since %q, expected a duration like 7d or a date like 2006-01-02", value)
+	}
+	return now.Add(-ago), nil
+}
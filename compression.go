@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/yaml.v3"
+)
+
+// CompressionConfig describes how artifacts are compressed before upload.
+// It can be written as just the format ("compress: zstd") or as a mapping
+// with a level
+type CompressionConfig struct {
+	// Format is "gzip", "zstd" or "xz"
+	Format string `yaml:"format"`
+	// Level is the format's own compression level, 0 picks its default
+	Level int `yaml:"level"`
+}
+
+func (cfg *CompressionConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		cfg.Format = value.Value
+		return nil
+	}
+	type plain CompressionConfig
+	return value.Decode((*plain)(cfg))
+}
+
+func (cfg CompressionConfig) validate() error {
+	var minLevel, maxLevel int
+	switch cfg.Format {
+	case "gzip":
+		minLevel, maxLevel = gzip.BestSpeed, gzip.BestCompression
+	case "zstd":
+		minLevel, maxLevel = 1, 22
+	case "xz":
+		minLevel, maxLevel = 0, 9
+	default:
+		return fmt.Errorf("unknown compression format %q, expected gzip, zstd or xz", cfg.Format)
+	}
+	if cfg.Level != 0 && (cfg.Level < minLevel || cfg.Level > maxLevel) {
+		return fmt.Errorf("%s compression level must be between %d and %d", cfg.Format, minLevel, maxLevel)
+	}
+	return nil
+}
+
+// extension is appended to the object name of compressed artifacts
+func (cfg CompressionConfig) extension() string {
+	switch cfg.Format {
+	case "zstd":
+		return ".zst"
+	case "xz":
+		return ".xz"
+	default:
+		return ".gz"
+	}
+}
+
+// compress writes a compressed copy of the file into dir and returns its path
+func (cfg CompressionConfig) compress(ctx context.Context, filePath, dir string) (string, error) {
+	output := filepath.Join(dir, filepath.Base(filePath)+cfg.extension())
+	if cfg.Format == "xz" {
+		return output, cfg.compressXZ(ctx, filePath, output)
+	}
+
+	src, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(output)
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	writer, err := cfg.newWriter(dst)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(writer, readerWithContext(ctx, src)); err != nil {
+		writer.Close()
+		return "", fmt.Errorf("%s compression failed: %s", cfg.Format, err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("%s compression failed: %s", cfg.Format, err)
+	}
+	return output, dst.Close()
+}
+
+// newWriter returns a writer compressing into w; xz is only available for
+// files
+func (cfg CompressionConfig) newWriter(w io.Writer) (io.WriteCloser, error) {
+	var writer io.WriteCloser
+	var err error
+	switch cfg.Format {
+	case "gzip":
+		level := cfg.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		writer, err = gzip.NewWriterLevel(w, level)
+	case "zstd":
+		var opts []zstd.EOption
+		if cfg.Level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(cfg.Level)))
+		}
+		writer, err = zstd.NewWriter(w, opts...)
+	default:
+		return nil, fmt.Errorf("%s compression can't be streamed", cfg.Format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s writer: %s", cfg.Format, err)
+	}
+	return writer, nil
+}
+
+// compressXZ shells out to xz, which is faster and better tested than any
+// pure Go implementation
+func (cfg CompressionConfig) compressXZ(ctx context.Context, filePath, output string) error {
+	dst, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	args := []string{"--compress", "--stdout", "--threads=0"}
+	if cfg.Level != 0 {
+		args = append(args, "-"+strconv.Itoa(cfg.Level))
+	}
+	args = append(args, filePath)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "xz", args...)
+	cmd.Stdout = dst
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("xz compression failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return dst.Close()
+}
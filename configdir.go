@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+)
+
+// configFiles returns the configuration file itself, or the *.yaml, *.yml,
+// *.json and *.toml files of a configuration directory in lexical order
+func configFiles(path string) ([]string, error) {
+	if isRemoteConfig(path) {
+		return []string{path}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml", "*.json", "*.toml"} {
+		matches, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	slices.Sort(files)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no configuration files in %s", path)
+	}
+	return files, nil
+}
+
+// readConfigDirectory merges the files of a configuration directory, so
+// every team can own the file with its jobs. Jobs add up; a top-level
+// setting, such as timezone or notifications, may only be set by one file
+func readConfigDirectory(files []string, specs *BackupSpecifications) error {
+	setBy := map[string]string{}
+	merged := reflect.ValueOf(specs).Elem()
+	for _, file := range files {
+		var fileSpecs BackupSpecifications
+		if err := readConfigFile(file, &fileSpecs); err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		specs.Tasks = append(specs.Tasks, fileSpecs.Tasks...)
+
+		values := reflect.ValueOf(fileSpecs)
+		for i := 0; i < values.NumField(); i++ {
+			field := values.Type().Field(i)
+			if field.Name == "Tasks" || values.Field(i).IsZero() {
+				continue
+			}
+			if other, ok := setBy[field.Name]; ok {
+				return fmt.Errorf("%s: %s is already set in %s", file, field.Tag.Get("yaml"), other)
+			}
+			setBy[field.Name] = file
+			merged.Field(i).Set(values.Field(i))
+		}
+	}
+	return nil
+}
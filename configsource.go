@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultRemoteConfigInterval is how often a remote configuration is
+// fetched again when CONFIG_WATCH_INTERVAL isn't set
+const defaultRemoteConfigInterval = 5 * time.Minute
+
+// isRemoteConfig tells whether CONFIG_PATH is a URL rather than a file
+func isRemoteConfig(path string) bool {
+	for _, scheme := range []string{"https://", "http://", "s3://"} {
+		if strings.HasPrefix(path, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// configFormat returns the format of a configuration file from its
+// extension: "json", "toml" or, for anything else, "yaml"
+func configFormat(path string) string {
+	if location, err := url.Parse(path); err == nil && isRemoteConfig(path) {
+		path = location.Path
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json"
+	case ".toml":
+		return "toml"
+	default:
+		return "yaml"
+	}
+}
+
+// readConfigSource returns the content of a configuration file, or of the
+// object at an https:// or s3:// URL. S3 objects are read with the global
+// storage's endpoint and credentials
+func readConfigSource(path string) ([]byte, error) {
+	if !isRemoteConfig(path) {
+		return os.ReadFile(path)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	location, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	var body io.ReadCloser
+	if location.Scheme == "s3" {
+		details := defaultStorageDetails
+		details.Container = location.Host
+		details.CreateIfMissing = false
+		details.SSE = ""
+		storage, err := newS3Storage(ctx, details)
+		if err != nil {
+			return nil, err
+		}
+		if body, err = storage.Get(ctx, strings.TrimPrefix(location.Path, "/")); err != nil {
+			return nil, err
+		}
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("fetching %s returned %s", location.Redacted(), resp.Status)
+		}
+		body = resp.Body
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+)
+
+// historySize is how many recent runs are kept per task
+const historySize = 20
+
+// controlledTask is a scheduled task that operators can trigger and pause
+type controlledTask struct {
+	task BackupTask
+	run  func()
+	jobs []gocron.Job
+
+	paused  bool
+	running int
+	// cancels stop the runs in progress by backup ID
+	cancels map[string]context.CancelCauseFunc
+	// history holds the latest runs, oldest first
+	history []*RunReport
+}
+
+// taskControl keeps the scheduled tasks with their state and recent runs,
+// for the dashboards and APIs that drive the scheduler
+type taskControl struct {
+	mu    sync.Mutex
+	order []string
+	tasks map[string]*controlledTask
+	// pausedAll keeps every task from starting new runs, e.g. during
+	// maintenance, while runs in progress finish
+	pausedAll bool
+}
+
+var control = &taskControl{tasks: map[string]*controlledTask{}}
+
+// taskSnapshot is a copy of a task's state for display
+type taskSnapshot struct {
+	Name     string
+	Schedule string
+	Paused   bool
+	Running  bool
+	NextRun  time.Time
+	History  []RunReport
+}
+
+// add starts controlling the task. A task that is already known, as its
+// configuration changed, keeps its state and runs in progress
+func (c *taskControl) add(task BackupTask, run func(), jobs []gocron.Job) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if controlled, ok := c.tasks[task.Name]; ok {
+		controlled.task, controlled.run, controlled.jobs = task, run, jobs
+		return
+	}
+	c.order = append(c.order, task.Name)
+	c.tasks[task.Name] = &controlledTask{task: task, run: run, jobs: jobs, cancels: map[string]context.CancelCauseFunc{}}
+}
+
+// remove forgets the task
+func (c *taskControl) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tasks, name)
+	c.order = slices.DeleteFunc(c.order, func(other string) bool { return other == name })
+}
+
+// jobsOf returns the task's scheduler jobs
+func (c *taskControl) jobsOf(name string) []gocron.Job {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if controlled, ok := c.tasks[name]; ok {
+		return controlled.jobs
+	}
+	return nil
+}
+
+// definitions returns the configuration of every task by name
+func (c *taskControl) definitions() map[string]BackupTask {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	definitions := map[string]BackupTask{}
+	for name, controlled := range c.tasks {
+		definitions[name] = controlled.task
+	}
+	return definitions
+}
+
+// dependents returns the tasks that only run after others
+func (c *taskControl) dependents() []dependent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []dependent
+	for _, name := range c.order {
+		if controlled := c.tasks[name]; controlled.jobs == nil {
+			result = append(result, dependent{task: controlled.task, run: controlled.run})
+		}
+	}
+	return result
+}
+
+func (c *taskControl) get(name string) (*controlledTask, error) {
+	controlled, ok := c.tasks[name]
+	if !ok {
+		return nil, fmt.Errorf("no such job %s", name)
+	}
+	return controlled, nil
+}
+
+// trigger starts a run of the task right away, outside its schedule
+func (c *taskControl) trigger(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	controlled, err := c.get(name)
+	if err != nil {
+		return err
+	}
+	if controlled.paused {
+		return fmt.Errorf("job %s is paused", name)
+	}
+	if c.pausedAll {
+		return fmt.Errorf("all jobs are paused")
+	}
+	go controlled.run()
+	return nil
+}
+
+// setPaused stops or resumes starting the task's runs; runs in progress
+// carry on
+func (c *taskControl) setPaused(name string, paused bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	controlled, err := c.get(name)
+	if err != nil {
+		return err
+	}
+	controlled.paused = paused
+	return nil
+}
+
+// isPaused tells whether the task may not start runs, because it or every
+// task is paused
+func (c *taskControl) isPaused(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	controlled, ok := c.tasks[name]
+	return c.pausedAll || ok && controlled.paused
+}
+
+// setPausedAll stops or resumes starting runs of every task and returns
+// how many runs are still in progress
+func (c *taskControl) setPausedAll(paused bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pausedAll = paused
+	running := 0
+	for _, controlled := range c.tasks {
+		running += controlled.running
+	}
+	if paused {
+		slog.Info("Paused all backup tasks, runs in progress finish", slog.Int("running", running))
+	} else {
+		slog.Info("Resumed all backup tasks")
+	}
+	return running
+}
+
+func (c *taskControl) isPausedAll() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pausedAll
+}
+
+// togglePauseOnSignal pauses or resumes every task on each SIGUSR1
+func (c *taskControl) togglePauseOnSignal() {
+	if pauseSignal == nil {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, pauseSignal)
+	go func() {
+		for range signals {
+			c.setPausedAll(!c.isPausedAll())
+		}
+	}()
+}
+
+// started records a run in progress, which cancel stops with cancelRun.
+// It returns the task the run is recorded with, to pass to finished, as
+// a reload may replace or remove the task while it runs
+func (c *taskControl) started(report *RunReport, cancelRun context.CancelCauseFunc) *controlledTask {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	controlled, ok := c.tasks[report.Task]
+	if !ok {
+		return nil
+	}
+	controlled.running++
+	controlled.cancels[report.BackupID] = cancelRun
+	return controlled
+}
+
+// cancel stops the task's runs in progress, killing their scripts and
+// aborting their uploads
+func (c *taskControl) cancel(name string, cause error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	controlled, err := c.get(name)
+	if err != nil {
+		return err
+	}
+	if len(controlled.cancels) == 0 {
+		return fmt.Errorf("job %s is not running", name)
+	}
+	for _, cancelRun := range controlled.cancels {
+		cancelRun(cause)
+	}
+	return nil
+}
+
+func (c *taskControl) finished(controlled *controlledTask, report *RunReport) {
+	if controlled == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	controlled.running--
+	delete(controlled.cancels, report.BackupID)
+	controlled.history = append(controlled.history, report)
+	if len(controlled.history) > historySize {
+		controlled.history = controlled.history[len(controlled.history)-historySize:]
+	}
+}
+
+// snapshot returns the state of every task in configuration order
+func (c *taskControl) snapshot() []taskSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshots := make([]taskSnapshot, 0, len(c.order))
+	for _, name := range c.order {
+		controlled := c.tasks[name]
+		snapshot := taskSnapshot{
+			Name:     name,
+			Schedule: controlled.task.scheduleDescription(),
+			Paused:   controlled.paused,
+			Running:  controlled.running > 0,
+		}
+		snapshot.NextRun, _ = nextRun(controlled.jobs)
+		for _, report := range controlled.history {
+			snapshot.History = append(snapshot.History, *report)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
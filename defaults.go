@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyDefaults fills the jobs of a parsed configuration file with the
+// settings of its defaults block, which is then removed. A job's own
+// setting wins; mappings such as compress or retention are merged key by
+// key, so a job can override a single one of their settings
+func applyDefaults(document *yaml.Node) error {
+	root := document
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	defaults := mappingValue(root, "defaults")
+	if defaults == nil {
+		return nil
+	}
+	i := mappingIndex(root, "defaults")
+	root.Content = slices.Delete(root.Content, i, i+2)
+	if defaults.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: defaults must be a mapping", defaults.Line)
+	}
+	if name := mappingValue(defaults, "name"); name != nil {
+		return fmt.Errorf("line %d: defaults can't set a name", name.Line)
+	}
+	if err := checkKnownFields(defaults, reflect.TypeOf(BackupTask{})); err != nil {
+		return err
+	}
+
+	jobs := mappingValue(root, "jobs")
+	if jobs == nil || jobs.Kind != yaml.SequenceNode {
+		return nil
+	}
+	// a job with a trigger of its own doesn't inherit another kind of it,
+	// e.g. a default schedule next to its every
+	triggers := []string{"schedule", "every", "after"}
+	untriggered := *defaults
+	untriggered.Content = nil
+	for i := 0; i+1 < len(defaults.Content); i += 2 {
+		if !slices.Contains(triggers, defaults.Content[i].Value) {
+			untriggered.Content = append(untriggered.Content, defaults.Content[i], defaults.Content[i+1])
+		}
+	}
+	for _, job := range jobs.Content {
+		if job.Kind != yaml.MappingNode {
+			continue
+		}
+		if slices.ContainsFunc(triggers, func(key string) bool { return mappingIndex(job, key) >= 0 }) {
+			mergeMapping(job, &untriggered)
+		} else {
+			mergeMapping(job, defaults)
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value of key in a mapping node, or nil
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if i := mappingIndex(mapping, key); i >= 0 {
+		return mapping.Content[i+1]
+	}
+	return nil
+}
+
+// mappingIndex returns the position of key in a mapping node's content,
+// or -1
+func mappingIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeMapping adds the keys of defaults that target lacks, and merges
+// the mappings both have
+func mergeMapping(target, defaults *yaml.Node) {
+	for i := 0; i+1 < len(defaults.Content); i += 2 {
+		key, value := defaults.Content[i], defaults.Content[i+1]
+		j := mappingIndex(target, key.Value)
+		switch {
+		case j < 0:
+			target.Content = append(target.Content, key, value)
+		case target.Content[j+1].Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			// merged into a copy, the job's mapping may be an anchor other
+			// jobs share
+			merged := *target.Content[j+1]
+			merged.Content = slices.Clone(merged.Content)
+			mergeMapping(&merged, value)
+			target.Content[j+1] = &merged
+		}
+	}
+}
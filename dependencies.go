@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// dependent is a task that runs after others succeeded
+type dependent struct {
+	task BackupTask
+	run  func()
+}
+
+var (
+	outcomesMu sync.Mutex
+	// latestSucceeded tells whether each task's latest run succeeded
+	latestSucceeded = map[string]bool{}
+	// dependents maps a task to the tasks without a schedule of their own
+	// that are triggered by its successful runs
+	dependents = map[string][]dependent{}
+)
+
+// addDependent triggers the task's runs by the success of the tasks it
+// comes after
+func addDependent(task BackupTask, run func()) {
+	outcomesMu.Lock()
+	defer outcomesMu.Unlock()
+	for _, name := range task.After {
+		dependents[name] = append(dependents[name], dependent{task: task, run: run})
+	}
+}
+
+// resetDependents forgets every dependent, before they are added again
+func resetDependents() {
+	outcomesMu.Lock()
+	defer outcomesMu.Unlock()
+	dependents = map[string][]dependent{}
+}
+
+// dependencyFailed returns the first task the given task comes after whose
+// latest run did not succeed, or hasn't run yet
+func (task BackupTask) dependencyFailed() (string, bool) {
+	outcomesMu.Lock()
+	defer outcomesMu.Unlock()
+	for _, name := range task.After {
+		if !latestSucceeded[name] {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// recordOutcome remembers the run's outcome and, when it succeeded, starts
+// the dependents whose other dependencies succeeded as well
+func recordOutcome(report *RunReport, logger *slog.Logger) {
+	outcomesMu.Lock()
+	latestSucceeded[report.Task] = report.Succeeded
+	next := dependents[report.Task]
+	outcomesMu.Unlock()
+
+	if !report.Succeeded {
+		return
+	}
+	for _, dependent := range next {
+		if name, failed := dependent.task.dependencyFailed(); failed {
+			logger.Info("Not triggering dependent backup task yet", slog.String("dependent", dependent.task.Name), slog.String("waiting_for", name))
+			continue
+		}
+		logger.Info("Triggering dependent backup task", slog.String("dependent", dependent.task.Name))
+		go dependent.run()
+	}
+}
+
+// validateDependencies checks that every task in after exists and that
+// tasks don't depend on themselves, directly or through others
+func validateDependencies(tasks []BackupTask) error {
+	after := map[string][]string{}
+	for _, task := range tasks {
+		after[task.Name] = task.After
+	}
+	for _, task := range tasks {
+		for _, name := range task.After {
+			if _, ok := after[name]; !ok {
+				return fmt.Errorf("backup task %s comes after unknown task %s", task.Name, name)
+			}
+		}
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	marks := map[string]int{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch marks[name] {
+		case visiting:
+			return fmt.Errorf("backup task %s depends on itself", name)
+		case done:
+			return nil
+		}
+		marks[name] = visiting
+		for _, next := range after[name] {
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		marks[name] = done
+		return nil
+	}
+	for _, task := range tasks {
+		if err := visit(task.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
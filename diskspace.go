@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// freeSpaceRuns is how many previous runs the auto estimate looks at
+	freeSpaceRuns = 5
+	// freeSpaceHeadroom multiplies the largest previous run for the auto
+	// estimate, as a dump and the artifact made from it both take room
+	freeSpaceHeadroom = 2
+)
+
+// FreeSpace is the room a job needs on the volume of its temporary
+// directory before it starts: a size such as "20GiB", or "auto" to
+// estimate it from the sizes of its previous runs
+type FreeSpace struct {
+	Size ByteSize
+	Auto bool
+}
+
+func (f *FreeSpace) UnmarshalYAML(value *yaml.Node) error {
+	if value.Value == "auto" {
+		f.Auto = true
+		return nil
+	}
+	return f.Size.UnmarshalYAML(value)
+}
+
+// checkFreeSpace fails the run up front when the volume of its temporary
+// directory has less free space than the task needs, rather than letting
+// the script die halfway with ENOSPC. Problems measuring it are only
+// logged
+func (task BackupTask) checkFreeSpace(ctx context.Context, dir string, report *RunReport, destinations []Destination, logger *slog.Logger) error {
+	if task.MinFreeSpace == nil {
+		return nil
+	}
+	needed := int64(task.MinFreeSpace.Size)
+	if task.MinFreeSpace.Auto {
+		if len(destinations) == 0 {
+			return nil
+		}
+		sizes, err := previousRunSizes(ctx, destinations[0].Storage, task.Name, report.BackupID, freeSpaceRuns)
+		if err != nil {
+			logger.Warn("Failed to list previous backups to estimate the space needed", slog.String("error", err.Error()))
+			return nil
+		}
+		if len(sizes) == 0 {
+			return nil
+		}
+		needed = slices.Max(sizes) * freeSpaceHeadroom
+	}
+
+	free, err := freeSpace(dir)
+	if err != nil {
+		logger.Warn("Failed to check the free disk space", slog.String("error", err.Error()))
+		return nil
+	}
+	if free < needed {
+		return fmt.Errorf("not enough free disk space in %s: %s free, %s needed", filepath.Dir(dir), ByteSize(free), ByteSize(needed))
+	}
+	return nil
+}
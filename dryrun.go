@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+)
+
+// dryRun is set by the --dry-run flag and applies to every task
+var dryRun bool
+
+func (task BackupTask) isDryRun() bool {
+	return dryRun || task.DryRun
+}
+
+// encodingExtension is appended to artifact names by compression and
+// encryption
+func (task BackupTask) encodingExtension() string {
+	var extension string
+	if task.Compression != nil {
+		extension += task.Compression.extension()
+	}
+	if task.Encryption != nil && task.Encryption.enabled() {
+		extension += task.Encryption.extension()
+	}
+	return extension
+}
+
+// simulate logs what a run would do instead of doing it: the script lines
+// it would run, the objects it would upload where, and the backups
+// retention would delete
+func (task BackupTask) simulate(destinations []Destination, backupID string, logger *slog.Logger) {
+	logger = logger.With(slog.Bool("dry_run", true))
+	logger.Info("Dry run, nothing is executed or uploaded")
+
+	var names []string
+	for _, destination := range destinations {
+		names = append(names, destination.Name)
+	}
+	for _, command := range task.Pre {
+		logger.Info("Would run pre script", slog.String("command", command))
+	}
+	if len(task.Command) > 0 {
+		logger.Info("Would run command", slog.Any("command", task.Command))
+	}
+	if task.ScriptFile != "" {
+		logger.Info("Would run script file", slog.String("file", task.ScriptFile))
+	}
+	for _, command := range task.Commands {
+		logger.Info("Would run script", slog.String("command", command))
+	}
+	for _, command := range task.Post {
+		logger.Info("Would run post script", slog.String("command", command))
+	}
+
+	if task.Stream != nil {
+		objectName := generateFileName(task.Name, backupID, task.Stream.Extension+task.encodingExtension())
+		logger.Info("Would stream the output of the last script line", slog.String("object", objectName), slog.String("destinations", strings.Join(names, ", ")))
+	} else {
+		// targets created by the script don't exist yet, in which case the
+		// configured paths are listed as they are
+		targets := []string(task.TargetFilePaths)
+		if exclude, err := newExcludeMatcher(task.Exclude); err == nil {
+			if expanded, err := expandTargets(task.TargetFilePaths, exclude); err == nil {
+				targets = expanded
+			}
+		}
+		for _, target := range targets {
+			extension := filepath.Ext(target)
+			if info, err := validateFile(target); err == nil && info.IsDir() {
+				extension = ".tar"
+			}
+			extension += task.encodingExtension()
+			if len(targets) > 1 {
+				extension = artifactExtension(target, extension)
+			}
+			logger.Info("Would upload", slog.String("target", target), slog.String("object", generateFileName(task.Name, backupID, extension)), slog.String("destinations", strings.Join(names, ", ")))
+		}
+	}
+
+	for _, destination := range destinations {
+		task.applyRetention(context.Background(), destination, logger, true)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// EncryptionConfig describes how artifacts are encrypted before they leave
+// the host, so they stay unreadable even if the bucket is exposed
+type EncryptionConfig struct {
+	// Type is "age", "gpg", or "none" to opt a job out of a global setting
+	Type string `yaml:"type"`
+
+	// age
+	Recipients     []string `yaml:"recipients"`
+	RecipientsFile string   `yaml:"recipients_file"`
+
+	// gpg, the public key ring is read from a file or an environment
+	// variable, either armored or binary
+	KeyFile string `yaml:"key_file"`
+	KeyEnv  string `yaml:"key_env"`
+}
+
+func (cfg EncryptionConfig) enabled() bool {
+	return cfg.Type != "none"
+}
+
+func (cfg EncryptionConfig) validate() error {
+	switch cfg.Type {
+	case "none":
+		return nil
+	case "age":
+		if len(cfg.Recipients) == 0 && cfg.RecipientsFile == "" {
+			return fmt.Errorf("age encryption needs recipients or a recipients_file")
+		}
+		return nil
+	case "gpg":
+		if cfg.KeyFile == "" && cfg.KeyEnv == "" {
+			return fmt.Errorf("gpg encryption needs a key_file or key_env")
+		}
+		_, err := cfg.gpgKey()
+		return err
+	default:
+		return fmt.Errorf("unknown encryption type %q", cfg.Type)
+	}
+}
+
+// extension is appended to the object name of encrypted artifacts
+func (cfg EncryptionConfig) extension() string {
+	if cfg.Type == "gpg" {
+		return ".gpg"
+	}
+	return ".age"
+}
+
+// encrypt writes an encrypted copy of the file into dir and returns its path
+func (cfg EncryptionConfig) encrypt(ctx context.Context, filePath, dir string) (string, error) {
+	output := filepath.Join(dir, filepath.Base(filePath)+cfg.extension())
+	if cfg.Type == "gpg" {
+		return output, cfg.encryptGPG(ctx, filePath, output)
+	}
+
+	args := []string{"--encrypt", "--output", output}
+	for _, recipient := range cfg.Recipients {
+		args = append(args, "--recipient", recipient)
+	}
+	if cfg.RecipientsFile != "" {
+		args = append(args, "--recipients-file", cfg.RecipientsFile)
+	}
+	args = append(args, filePath)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "age", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("age encryption failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// gpgKey reads the public key ring from the file or the environment
+// variable
+func (cfg EncryptionConfig) gpgKey() ([]byte, error) {
+	if cfg.KeyEnv != "" {
+		data := []byte(os.Getenv(cfg.KeyEnv))
+		if len(data) == 0 {
+			return nil, fmt.Errorf("environment variable %s holding the gpg key is empty", cfg.KeyEnv)
+		}
+		return data, nil
+	}
+	data, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gpg key: %s", err)
+	}
+	return data, nil
+}
+
+// gpgHome imports the keys into a throwaway gpg home directory, so the
+// user's own key ring is neither used nor changed, and returns it with
+// the fingerprints of the imported keys
+func gpgHome(ctx context.Context, keys []byte) (string, []string, error) {
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		return "", nil, err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "gpg", "--homedir", home, "--batch", "--import")
+	cmd.Stdin = bytes.NewReader(keys)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		removeGPGHome(home)
+		return "", nil, fmt.Errorf("failed to import gpg key: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	listing, err := exec.CommandContext(ctx, "gpg", "--homedir", home, "--batch", "--with-colons", "--list-keys").Output()
+	if err != nil {
+		removeGPGHome(home)
+		return "", nil, fmt.Errorf("failed to list gpg keys: %s", err)
+	}
+
+	// each primary key's record is followed by its fingerprint's
+	var fingerprints []string
+	primary := false
+	for _, line := range strings.Split(string(listing), "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "pub":
+			primary = true
+		case fields[0] == "fpr" && primary && len(fields) > 9:
+			fingerprints = append(fingerprints, fields[9])
+			primary = false
+		}
+	}
+	if len(fingerprints) == 0 {
+		removeGPGHome(home)
+		return "", nil, fmt.Errorf("gpg key ring is empty")
+	}
+	return home, fingerprints, nil
+}
+
+// removeGPGHome stops the agent gpg may have started for the home
+// directory and removes it
+func removeGPGHome(home string) {
+	exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+	os.RemoveAll(home)
+}
+
+// gpgEncryptCommand encrypts to every key in the home directory, trusting
+// them as they were configured explicitly
+func gpgEncryptCommand(ctx context.Context, home string, fingerprints []string, fileName string, args ...string) *exec.Cmd {
+	gpgArgs := []string{"--homedir", home, "--batch", "--yes", "--trust-model", "always", "--set-filename", fileName}
+	for _, fingerprint := range fingerprints {
+		gpgArgs = append(gpgArgs, "--recipient", fingerprint)
+	}
+	gpgArgs = append(append(gpgArgs, "--encrypt"), args...)
+	return exec.CommandContext(ctx, "gpg", gpgArgs...)
+}
+
+// newWriter returns a writer encrypting into w; age is only available for
+// files
+func (cfg EncryptionConfig) newWriter(ctx context.Context, w io.Writer, fileName string) (io.WriteCloser, error) {
+	if cfg.Type != "gpg" {
+		return nil, fmt.Errorf("%s encryption can't be streamed", cfg.Type)
+	}
+	keys, err := cfg.gpgKey()
+	if err != nil {
+		return nil, err
+	}
+	home, fingerprints, err := gpgHome(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &gpgWriter{home: home, cmd: gpgEncryptCommand(ctx, home, fingerprints, fileName)}
+	writer.cmd.Stdout = w
+	writer.cmd.Stderr = &writer.stderr
+	if writer.stdin, err = writer.cmd.StdinPipe(); err == nil {
+		err = writer.cmd.Start()
+	}
+	if err != nil {
+		removeGPGHome(home)
+		return nil, fmt.Errorf("gpg encryption failed: %s", err)
+	}
+	return writer, nil
+}
+
+// gpgWriter feeds what is written to it to a gpg process, which encrypts
+// it into the process's output
+type gpgWriter struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+	home   string
+}
+
+func (w *gpgWriter) Write(p []byte) (int, error) {
+	return w.stdin.Write(p)
+}
+
+func (w *gpgWriter) Close() error {
+	w.stdin.Close()
+	err := w.cmd.Wait()
+	removeGPGHome(w.home)
+	if err != nil {
+		return fmt.Errorf("gpg encryption failed: %s: %s", err, strings.TrimSpace(w.stderr.String()))
+	}
+	return nil
+}
+
+// encryptGPG encrypts the file to every key in the key ring
+func (cfg EncryptionConfig) encryptGPG(ctx context.Context, filePath, output string) error {
+	keys, err := cfg.gpgKey()
+	if err != nil {
+		return err
+	}
+	home, fingerprints, err := gpgHome(ctx, keys)
+	if err != nil {
+		return err
+	}
+	defer removeGPGHome(home)
+
+	var stderr bytes.Buffer
+	cmd := gpgEncryptCommand(ctx, home, fingerprints, filepath.Base(filePath), "--output", output, filePath)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("gpg encryption failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
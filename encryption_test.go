@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testGPGKeys generates a key pair, its secret key protected by the
+// passphrase if given, and returns the files holding the exported keys
+func testGPGKeys(t *testing.T, passphrase string) (public, secret string) {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("needs gpg")
+	}
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { removeGPGHome(home) })
+
+	gpg := func(stdin string, args ...string) []byte {
+		cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--pinentry-mode", "loopback", "--passphrase-fd", "0"}, args...)...)
+		cmd.Stdin = strings.NewReader(passphrase)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("gpg %s: %s: %s", strings.Join(args, " "), err, stderr.String())
+		}
+		return output
+	}
+	gpg(passphrase, "--quick-generate-key", "Backups <backups@example.com>", "future-default", "default", "never")
+
+	dir := t.TempDir()
+	public, secret = filepath.Join(dir, "public.asc"), filepath.Join(dir, "secret.asc")
+	os.WriteFile(public, gpg(passphrase, "--armor", "--export"), 0o600)
+	os.WriteFile(secret, gpg(passphrase, "--export-secret-keys"), 0o600)
+	return public, secret
+}
+
+func TestGPGRoundTrip(t *testing.T) {
+	public, secret := testGPGKeys(t, "s3cret ' pass")
+	t.Setenv("BACKUP_GPG_PASSPHRASE", "s3cret ' pass")
+	ctx := context.Background()
+	content := []byte("-- dump\nCREATE TABLE users ();\n")
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.sql")
+	os.WriteFile(file, content, 0o600)
+
+	cfg := EncryptionConfig{Type: "gpg", KeyFile: public}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := cfg.encrypt(ctx, file, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Ext(encrypted) != ".gpg" {
+		t.Errorf("encrypted to %s", encrypted)
+	}
+
+	var streamed bytes.Buffer
+	writer, err := cfg.newWriter(ctx, &streamed, "app.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(content)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	streamedFile := filepath.Join(dir, "streamed.sql.gpg")
+	os.WriteFile(streamedFile, streamed.Bytes(), 0o600)
+
+	for _, source := range []string{encrypted, streamedFile} {
+		if bytes.Contains(mustRead(t, source), content) {
+			t.Errorf("%s holds the plain text", source)
+		}
+		output := filepath.Join(dir, "decrypted.sql")
+		if err := decryptGPG(ctx, source, output, secret, "BACKUP_GPG_PASSPHRASE"); err != nil {
+			t.Fatal(err)
+		}
+		if decrypted := mustRead(t, output); !bytes.Equal(decrypted, content) {
+			t.Errorf("decrypted %s to %q", source, decrypted)
+		}
+	}
+
+	t.Setenv("BACKUP_GPG_PASSPHRASE", "wrong")
+	if err := decryptGPG(ctx, encrypted, filepath.Join(dir, "wrong.sql"), secret, "BACKUP_GPG_PASSPHRASE"); err == nil {
+		t.Error("decrypted with the wrong passphrase")
+	}
+}
+
+func TestGPGKeyErrors(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("needs gpg")
+	}
+	notAKey := filepath.Join(t.TempDir(), "key.asc")
+	os.WriteFile(notAKey, []byte("not a key"), 0o600)
+	t.Setenv("BACKUP_GPG_EMPTY", "")
+
+	tests := []struct {
+		name string
+		cfg  EncryptionConfig
+		err  string
+	}{
+		{"no key", EncryptionConfig{Type: "gpg"}, "needs a key_file or key_env"},
+		{"empty variable", EncryptionConfig{Type: "gpg", KeyEnv: "BACKUP_GPG_EMPTY"}, "BACKUP_GPG_EMPTY holding the gpg key is empty"},
+		{"missing file", EncryptionConfig{Type: "gpg", KeyFile: filepath.Join(t.TempDir(), "missing.asc")}, "failed to read gpg key"},
+		{"not a key", EncryptionConfig{Type: "gpg", KeyFile: notAKey}, "failed to import gpg key"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.cfg.validate()
+			if err == nil {
+				_, err = test.cfg.encrypt(context.Background(), notAKey, t.TempDir())
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got %v, want an error about %q", err, test.err)
+			}
+		})
+	}
+}
+
+func mustRead(t *testing.T, file string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
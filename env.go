@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// scriptEnvironment returns the environment the task's scripts run with,
+// or nil to inherit the process' own as is. It is made of the process'
+// variables, only the ones of inherit_env and PATH when that is set, then
+// the task's env and its vault_secrets
+func (task BackupTask) scriptEnvironment(ctx context.Context) ([]string, error) {
+	if len(task.Env) == 0 && task.InheritEnv == nil && len(task.VaultSecrets) == 0 {
+		return nil, nil
+	}
+
+	env := os.Environ()
+	if task.InheritEnv != nil {
+		env = nil
+		for _, name := range append([]string{"PATH"}, task.InheritEnv...) {
+			if value, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+value)
+			}
+		}
+	}
+	names := make([]string, 0, len(task.Env))
+	for name := range task.Env {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		env = append(env, name+"="+task.Env[name])
+	}
+
+	if len(task.VaultSecrets) > 0 {
+		if vault == nil {
+			return nil, fmt.Errorf("vault_secrets need VAULT_ADDR to be set")
+		}
+		secrets, err := vault.environment(ctx, task.VaultSecrets)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, secrets...)
+	}
+	return env, nil
+}
+
+// validateEnv checks the names of the task's env and inherit_env
+func (task BackupTask) validateEnv() error {
+	for name := range task.Env {
+		if name == "" || strings.ContainsAny(name, "= \t\n") {
+			return fmt.Errorf("env: invalid variable name %q", name)
+		}
+	}
+	for _, name := range task.InheritEnv {
+		if name == "" || strings.ContainsAny(name, "= \t\n") {
+			return fmt.Errorf("inherit_env: invalid variable name %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package main
+
+// escalates tells whether a channel reports the failure of the event. It
+// only does from the after-th failure in a row on and, with
+// suppress_repeats, only for that one until the task recovers
+func (filter eventFilter) escalates(event Event) bool {
+	after := max(filter.After, 1)
+	streak := event.Report.FailureStreak
+	if streak < after {
+		return false
+	}
+	return !filter.SuppressRepeats || streak == after
+}
+
+// recovered tells whether the event is the success ending a streak of
+// failures the channel reported, which suppress_repeats channels announce
+func (filter eventFilter) recovered(event Event) bool {
+	return filter.SuppressRepeats && event.Type == EventSucceeded && event.Report.FailureStreak >= max(filter.After, 1)
+}
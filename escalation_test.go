@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestFailureStreakDrivesEscalation(t *testing.T) {
+	defer func(saved map[string]*incidentState) { incidents = saved }(incidents)
+	incidents = map[string]*incidentState{}
+
+	filter := eventFilter{After: 2, SuppressRepeats: true}
+	var triggered, resolved int
+	trigger := func() error { triggered++; return nil }
+	resolve := func() error { resolved++; return nil }
+
+	tests := []struct {
+		succeeded bool
+		streak    int
+		escalates bool
+		recovered bool
+		triggered int
+		resolved  int
+	}{
+		{false, 1, false, false, 0, 0},
+		{false, 2, true, false, 1, 0},
+		{false, 3, false, false, 1, 0},
+		{true, 3, false, true, 1, 1},
+		{false, 1, false, false, 1, 1},
+	}
+	for i, test := range tests {
+		report := &RunReport{Task: "db", Succeeded: test.succeeded}
+		recordFailureStreak(report)
+		if report.FailureStreak != test.streak {
+			t.Errorf("run %d: got streak %d, want %d", i, report.FailureStreak, test.streak)
+		}
+		eventType := EventFailed
+		if test.succeeded {
+			eventType = EventSucceeded
+		}
+		event := Event{Type: eventType, Report: report}
+		if !test.succeeded && filter.escalates(event) != test.escalates {
+			t.Errorf("run %d: escalates = %v, want %v", i, !test.escalates, test.escalates)
+		}
+		if filter.recovered(event) != test.recovered {
+			t.Errorf("run %d: recovered = %v, want %v", i, !test.recovered, test.recovered)
+		}
+		if err := escalate("pagerduty", 2, report, trigger, resolve); err != nil {
+			t.Fatal(err)
+		}
+		if triggered != test.triggered || resolved != test.resolved {
+			t.Errorf("run %d: triggered %d and resolved %d incidents, want %d and %d", i, triggered, resolved, test.triggered, test.resolved)
+		}
+	}
+}
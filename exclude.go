@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// excludeRule is a single gitignore-style pattern
+type excludeRule struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+	// anchored rules contain a slash and match from the root, the others
+	// match a name at any depth
+	anchored bool
+}
+
+// excludeMatcher decides which paths are left out of directory archives
+// and glob expansions. It follows gitignore rules: "!" re-includes, a
+// trailing "/" only matches directories, "**" matches any number of
+// directories and the last matching pattern wins
+type excludeMatcher struct {
+	rules []excludeRule
+}
+
+func newExcludeMatcher(patterns []string) (*excludeMatcher, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	matcher := &excludeMatcher{}
+	for _, pattern := range patterns {
+		rule := excludeRule{}
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(pattern, "!"); ok {
+			rule.negate, pattern = true, rest
+		}
+		if rest, ok := strings.CutSuffix(pattern, "/"); ok {
+			rule.dirOnly, pattern = true, rest
+		}
+		rule.anchored = strings.Contains(pattern, "/")
+		rule.segments = strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+		for _, segment := range rule.segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("invalid exclude pattern %q: %s", pattern, err)
+			}
+		}
+		matcher.rules = append(matcher.rules, rule)
+	}
+	return matcher, nil
+}
+
+// excluded reports whether the slash separated relative path is excluded,
+// either itself or through one of its parent directories
+func (m *excludeMatcher) excluded(name string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	segments := strings.Split(strings.Trim(name, "/"), "/")
+	for i := 1; i < len(segments); i++ {
+		if m.match(segments[:i], true) {
+			return true
+		}
+	}
+	return m.match(segments, isDir)
+}
+
+func (m *excludeMatcher) match(segments []string, isDir bool) bool {
+	excluded := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		var matched bool
+		if rule.anchored {
+			matched = matchSegments(rule.segments, segments)
+		} else {
+			matched, _ = path.Match(rule.segments[0], segments[len(segments)-1])
+		}
+		if matched {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
+
+// matchSegments matches path segments against pattern segments, where a
+// "**" segment matches zero or more path segments
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
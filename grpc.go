@@ -0,0 +1,411 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	// grpcService is the path prefix of the methods of the
+	// SchedulerService in proto/scheduler/v1/scheduler.proto
+	grpcService = "/scheduler.v1.SchedulerService/"
+	// grpcMaxMessage caps the size of a request message
+	grpcMaxMessage = 4 << 20
+)
+
+// gRPC status codes
+const (
+	grpcOK                 = 0
+	grpcUnknown            = 2
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcUnauthenticated    = 16
+)
+
+// grpcError fails a call with a gRPC status
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+// grpcHandler serves the SchedulerService of proto/scheduler/v1, with the
+// same calls as the admin API and a stream of finished runs. It speaks
+// gRPC over HTTP/2 without TLS, as clients dialing with insecure
+// credentials do, and every call needs the admin token as
+// "authorization: Bearer <token>" metadata
+func grpcHandler(token string, tasks *taskScheduler) http.Handler {
+	unary := map[string]func(job string) (protoMessage, error){
+		"ListJobs": func(string) (protoMessage, error) {
+			var response protoMessage
+			for _, snapshot := range control.snapshot() {
+				response.message(1, protoJob(snapshot))
+			}
+			return response, nil
+		},
+		"ListRuns": func(job string) (protoMessage, error) {
+			for _, snapshot := range control.snapshot() {
+				if snapshot.Name != job {
+					continue
+				}
+				// newest first
+				var response protoMessage
+				for i := len(snapshot.History) - 1; i >= 0; i-- {
+					response.message(1, protoRun(snapshot.History[i]))
+				}
+				return response, nil
+			}
+			return nil, &grpcError{grpcNotFound, "no such job"}
+		},
+		"RunJob": func(job string) (protoMessage, error) {
+			if err := control.trigger(job); err != nil {
+				return nil, &grpcError{grpcFailedPrecondition, err.Error()}
+			}
+			slog.Info("Backup task triggered through the gRPC API", slog.String("backup_task", job))
+			return protoMessage{}, nil
+		},
+		"PauseJob": func(job string) (protoMessage, error) {
+			if err := control.setPaused(job, true); err != nil {
+				return nil, &grpcError{grpcNotFound, err.Error()}
+			}
+			slog.Info("Backup task paused through the gRPC API", slog.String("backup_task", job))
+			return protoMessage{}, nil
+		},
+		"ResumeJob": func(job string) (protoMessage, error) {
+			if err := control.setPaused(job, false); err != nil {
+				return nil, &grpcError{grpcNotFound, err.Error()}
+			}
+			slog.Info("Backup task resumed through the gRPC API", slog.String("backup_task", job))
+			return protoMessage{}, nil
+		},
+		"Reload": func(string) (protoMessage, error) {
+			delta, err := tasks.reloadLogged("gRPC API")
+			if err != nil {
+				return nil, &grpcError{grpcFailedPrecondition, err.Error()}
+			}
+			var response protoMessage
+			for field, names := range [][]string{delta.Added, delta.Removed, delta.Updated} {
+				for _, name := range names {
+					response.string(field+1, name)
+				}
+			}
+			return response, nil
+		},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			finishGRPC(w, &grpcError{grpcUnauthenticated, "unauthorized"})
+			return
+		}
+		method, _ := strings.CutPrefix(r.URL.Path, grpcService)
+		request, err := readGRPCMessage(r.Body)
+		if err != nil {
+			finishGRPC(w, err)
+			return
+		}
+		job, err := protoString(request, 1)
+		if err != nil {
+			finishGRPC(w, &grpcError{grpcInvalidArgument, err.Error()})
+			return
+		}
+
+		if method == "WatchRuns" {
+			finishGRPC(w, watchRuns(w, r, job))
+			return
+		}
+		call, ok := unary[method]
+		if !ok {
+			finishGRPC(w, &grpcError{grpcUnimplemented, "unknown method " + r.URL.Path})
+			return
+		}
+		response, err := call(job)
+		if err == nil {
+			err = writeGRPCMessage(w, response)
+		}
+		finishGRPC(w, err)
+	})
+	return h2c.NewHandler(handler, &http2.Server{})
+}
+
+// watchRuns streams the runs of the job, or of every job, as they finish
+// until the client goes away
+func watchRuns(w http.ResponseWriter, r *http.Request, job string) error {
+	reports, unsubscribe := runFeed.subscribe()
+	defer unsubscribe()
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case report := <-reports:
+			if job != "" && report.Task != job {
+				continue
+			}
+			if err := writeGRPCMessage(w, protoRun(report)); err != nil {
+				return err
+			}
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// finishGRPC ends a call with its status in the trailers
+func finishGRPC(w http.ResponseWriter, err error) {
+	code, message := grpcOK, ""
+	if err != nil {
+		code, message = grpcUnknown, err.Error()
+		var failure *grpcError
+		if errors.As(err, &failure) {
+			code = failure.code
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", grpcEscape(message))
+	}
+}
+
+// grpcEscape percent-encodes a status message as gRPC requires, which
+// leaves printable ASCII other than % alone
+func grpcEscape(message string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		} else {
+			escaped.WriteByte(c)
+		}
+	}
+	return escaped.String()
+}
+
+// readGRPCMessage reads the single, length-prefixed message of a request
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "failed to read the request: " + err.Error()}
+	}
+	if prefix[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessage {
+		return nil, &grpcError{grpcInvalidArgument, "request is too large"}
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "failed to read the request: " + err.Error()}
+	}
+	return message, nil
+}
+
+func writeGRPCMessage(w io.Writer, message protoMessage) error {
+	prefix := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+	_, err := w.Write(append(prefix, message...))
+	return err
+}
+
+// protoJob encodes a scheduler.v1.Job
+func protoJob(snapshot taskSnapshot) protoMessage {
+	var job protoMessage
+	job.string(1, snapshot.Name)
+	job.string(2, snapshot.Schedule)
+	job.bool(3, snapshot.Paused)
+	job.bool(4, snapshot.Running)
+	job.message(5, protoTimestamp(snapshot.NextRun))
+	if len(snapshot.History) > 0 {
+		job.message(6, protoRun(snapshot.History[len(snapshot.History)-1]))
+	}
+	return job
+}
+
+// protoRun encodes a scheduler.v1.Run
+func protoRun(report RunReport) protoMessage {
+	var run protoMessage
+	run.string(1, report.Task)
+	run.string(2, report.BackupID)
+	run.message(3, protoTimestamp(report.Started))
+	run.message(4, protoDuration(report.Duration))
+	run.bool(5, report.Succeeded)
+	run.string(6, report.Error)
+	run.int(7, report.Size)
+	for _, object := range report.Objects {
+		run.string(8, object)
+	}
+	for _, warning := range report.Warnings {
+		run.string(9, warning)
+	}
+	return run
+}
+
+// protoTimestamp encodes a google.protobuf.Timestamp, nil for the zero time
+func protoTimestamp(t time.Time) protoMessage {
+	if t.IsZero() {
+		return nil
+	}
+	timestamp := protoMessage{}
+	timestamp.int(1, t.Unix())
+	timestamp.int(2, int64(t.Nanosecond()))
+	return timestamp
+}
+
+// protoDuration encodes a google.protobuf.Duration
+func protoDuration(d time.Duration) protoMessage {
+	duration := protoMessage{}
+	duration.int(1, int64(d/time.Second))
+	duration.int(2, int64(d%time.Second))
+	return duration
+}
+
+// protoMessage is an encoded protobuf message, built field by field. As in
+// proto3, fields with their default value are left out
+type protoMessage []byte
+
+func (m *protoMessage) tag(field, wireType int) {
+	*m = binary.AppendUvarint(*m, uint64(field<<3|wireType))
+}
+
+func (m *protoMessage) int(field int, value int64) {
+	if value == 0 {
+		return
+	}
+	m.tag(field, 0)
+	*m = binary.AppendUvarint(*m, uint64(value))
+}
+
+func (m *protoMessage) bool(field int, value bool) {
+	if value {
+		m.int(field, 1)
+	}
+}
+
+func (m *protoMessage) string(field int, value string) {
+	if value == "" {
+		return
+	}
+	m.tag(field, 2)
+	*m = binary.AppendUvarint(*m, uint64(len(value)))
+	*m = append(*m, value...)
+}
+
+// message embeds another message, unless it is nil
+func (m *protoMessage) message(field int, value protoMessage) {
+	if value == nil {
+		return
+	}
+	m.tag(field, 2)
+	*m = binary.AppendUvarint(*m, uint64(len(value)))
+	*m = append(*m, value...)
+}
+
+// protoString returns a string field of an encoded message, empty when it
+// is missing, and skips every other field
+func protoString(data []byte, field int) (string, error) {
+	var value string
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return "", fmt.Errorf("malformed message")
+		}
+		data = data[n:]
+		switch key & 7 {
+		case 0:
+			if _, n = binary.Uvarint(data); n <= 0 {
+				return "", fmt.Errorf("malformed message")
+			}
+			data = data[n:]
+		case 1, 5:
+			size := 8
+			if key&7 == 5 {
+				size = 4
+			}
+			if len(data) < size {
+				return "", fmt.Errorf("malformed message")
+			}
+			data = data[size:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return "", fmt.Errorf("malformed message")
+			}
+			if int(key>>3) == field {
+				value = string(data[n : n+int(length)])
+			}
+			data = data[n+int(length):]
+		default:
+			return "", fmt.Errorf("malformed message")
+		}
+	}
+	return value, nil
+}
+
+// runFeed passes finished runs to the WatchRuns streams
+var runFeed = &runSubscribers{subscribers: map[chan RunReport]bool{}}
+
+// runSubscribers fans finished runs out to their subscribers. A subscriber
+// that falls behind misses runs rather than holding up the scheduler
+type runSubscribers struct {
+	mu          sync.Mutex
+	subscribers map[chan RunReport]bool
+}
+
+func (f *runSubscribers) subscribe() (chan RunReport, func()) {
+	reports := make(chan RunReport, 16)
+	f.mu.Lock()
+	f.subscribers[reports] = true
+	f.mu.Unlock()
+	return reports, func() {
+		f.mu.Lock()
+		delete(f.subscribers, reports)
+		f.mu.Unlock()
+	}
+}
+
+func (f *runSubscribers) publish(report RunReport) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for reports := range f.subscribers {
+		select {
+		case reports <- report:
+		default:
+		}
+	}
+}
+
+// serveGRPC serves the gRPC API on the address in the background
+func serveGRPC(address, token string, tasks *taskScheduler) {
+	go func() {
+		slog.Info("Serving the gRPC API", slog.String("address", address))
+		if err := http.ListenAndServe(address, grpcHandler(token, tasks)); err != nil {
+			slog.Error("gRPC API listener stopped", slog.String("error", err.Error()))
+		}
+	}()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestProtoMessageEncoding(t *testing.T) {
+	var m protoMessage
+	m.string(1, "db")
+	m.bool(3, true)
+	m.bool(4, false)
+	m.int(7, 300)
+	m.message(5, protoMessage{})
+	m.message(6, nil)
+	want := []byte{0x0a, 2, 'd', 'b', 0x18, 1, 0x38, 0xac, 0x02, 0x2a, 0}
+	if !bytes.Equal(m, want) {
+		t.Fatalf("got % x, want % x", []byte(m), want)
+	}
+}
+
+func TestProtoString(t *testing.T) {
+	var m protoMessage
+	m.int(2, 42)
+	m.string(3, "other")
+	m.string(1, "db")
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{"empty", nil, "", false},
+		{"skips other fields", m, "db", false},
+		{"fixed width fields", []byte{0x11, 1, 2, 3, 4, 5, 6, 7, 8, 0x15, 1, 2, 3, 4, 0x0a, 1, 'x'}, "x", false},
+		{"truncated length", []byte{0x0a, 5, 'd'}, "", true},
+		{"truncated varint", []byte{0x10, 0x80}, "", true},
+		{"unknown wire type", []byte{0x0b}, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := protoString(test.data, 1)
+			if (err != nil) != test.wantErr || got != test.want {
+				t.Fatalf("got %q, %v, want %q, error %t", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestGRPCEscape(t *testing.T) {
+	if got := grpcEscape("job ä is 100% paused\n"); got != "job %C3%A4 is 100%25 paused%0A" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+// plaintextHTTP2 returns a client that speaks HTTP/2 without TLS, like a
+// gRPC client with insecure credentials
+func plaintextHTTP2() *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+// callGRPC makes a unary call and returns the response message and status
+func callGRPC(t *testing.T, url, method, token string, request protoMessage) ([]byte, string) {
+	t.Helper()
+	var body bytes.Buffer
+	writeGRPCMessage(&body, request)
+	req, _ := http.NewRequest(http.MethodPost, url+grpcService+method, &body)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := plaintextHTTP2().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var message []byte
+	if resp.ContentLength != 0 {
+		message, _ = readGRPCMessage(resp.Body)
+	}
+	// trailers are only complete once the body is read
+	for {
+		if _, err := resp.Body.Read(make([]byte, 1)); err != nil {
+			break
+		}
+	}
+	return message, resp.Trailer.Get("Grpc-Status")
+}
+
+func TestGRPCHandler(t *testing.T) {
+	previous := control
+	control = &taskControl{tasks: map[string]*controlledTask{}}
+	defer func() { control = previous }()
+	control.add(BackupTask{Name: "db", Schedule: stringList{"0 3 * * *"}}, func() {}, nil)
+
+	server := httptest.NewServer(grpcHandler("secret", nil))
+	defer server.Close()
+
+	if _, status := callGRPC(t, server.URL, "ListJobs", "wrong", nil); status != "16" {
+		t.Errorf("wrong token: got status %s, want 16", status)
+	}
+	response, status := callGRPC(t, server.URL, "ListJobs", "secret", nil)
+	if status != "0" {
+		t.Fatalf("ListJobs: got status %s", status)
+	}
+	job, err := protoString(response, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := protoString([]byte(job), 1); name != "db" {
+		t.Errorf("ListJobs: got job %q, want db", name)
+	}
+
+	var request protoMessage
+	request.string(1, "missing")
+	if _, status := callGRPC(t, server.URL, "ListRuns", "secret", request); status != "5" {
+		t.Errorf("ListRuns of a missing job: got status %s, want 5", status)
+	}
+	request = nil
+	request.string(1, "db")
+	if _, status := callGRPC(t, server.URL, "PauseJob", "secret", request); status != "0" || !control.isPaused("db") {
+		t.Errorf("PauseJob: got status %s, paused %t", status, control.isPaused("db"))
+	}
+	if _, status := callGRPC(t, server.URL, "DeleteJob", "secret", nil); status != "12" {
+		t.Errorf("unknown method: got status %s, want 12", status)
+	}
+}
+
+func TestGRPCWatchRuns(t *testing.T) {
+	server := httptest.NewServer(grpcHandler("secret", nil))
+	defer server.Close()
+
+	done := make(chan []byte)
+	go func() {
+		var request protoMessage
+		request.string(1, "db")
+		var body bytes.Buffer
+		writeGRPCMessage(&body, request)
+		req, _ := http.NewRequest(http.MethodPost, server.URL+grpcService+"WatchRuns", &body)
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := plaintextHTTP2().Do(req)
+		if err != nil {
+			done <- nil
+			return
+		}
+		defer resp.Body.Close()
+		message, _ := readGRPCMessage(resp.Body)
+		done <- message
+	}()
+
+	// runs of other jobs are left out
+	deadline := time.After(5 * time.Second)
+	for {
+		runFeed.publish(RunReport{Task: "other", BackupID: "skipped"})
+		runFeed.publish(RunReport{Task: "db", BackupID: "abc123", Succeeded: true})
+		select {
+		case message := <-done:
+			if id, _ := protoString(message, 2); id != "abc123" {
+				t.Fatalf("got backup ID %q, want abc123", id)
+			}
+			return
+		case <-deadline:
+			t.Fatal("no run was streamed")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestGRPCRejectsOtherRequests(t *testing.T) {
+	server := httptest.NewServer(grpcHandler("secret", nil))
+	defer server.Close()
+	resp, err := http.Post(server.URL+grpcService+"ListJobs", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("got %s", resp.Status)
+	}
+}
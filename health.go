@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+)
+
+// jobHealth is what the health checks know about one job
+type jobHealth struct {
+	task      BackupTask
+	jobs      []gocron.Job
+	lastError string
+	// deadline is when the job should have succeeded again: its next
+	// scheduled run after the last success, plus the grace period
+	deadline time.Time
+	// alerted is set once a missed event was sent for the deadline
+	alerted bool
+}
+
+// healthTracker backs /healthz and /readyz. The process is ready once the
+// configuration was loaded and every job scheduled, and healthy while no
+// job's last run failed and none is overdue
+type healthTracker struct {
+	mu    sync.Mutex
+	ready bool
+	grace time.Duration
+	jobs  map[string]*jobHealth
+}
+
+var health = &healthTracker{grace: time.Hour, jobs: map[string]*jobHealth{}}
+
+func (h *healthTracker) setReady() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = true
+}
+
+func (h *healthTracker) isReady() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ready
+}
+
+// track adds a scheduled task, one job per schedule, expected to succeed
+// by its first run
+func (h *healthTracker) track(task BackupTask, jobs []gocron.Job) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state := &jobHealth{task: task, jobs: jobs}
+	state.deadline = h.nextDeadline(jobs)
+	h.jobs[task.Name] = state
+}
+
+// nextDeadline is the jobs' next run plus the grace period, or zero when
+// the scheduler can't tell; the caller holds mu
+func (h *healthTracker) nextDeadline(jobs []gocron.Job) time.Time {
+	next, ok := nextRun(jobs)
+	if !ok {
+		return time.Time{}
+	}
+	return next.Add(h.grace)
+}
+
+func (h *healthTracker) untrack(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.jobs, name)
+}
+
+func (h *healthTracker) record(report *RunReport) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state, ok := h.jobs[report.Task]
+	if !ok {
+		return
+	}
+	state.lastError = report.Error
+	if report.Succeeded {
+		state.deadline = h.nextDeadline(state.jobs)
+		state.alerted = false
+	}
+}
+
+// checkMissed sends a missed event to the notifiers of every job that
+// passed its deadline without succeeding, once per deadline. It catches
+// schedules that silently stopped firing, which no run can report
+func (h *healthTracker) checkMissed() {
+	h.mu.Lock()
+	now := time.Now()
+	var missed []*jobHealth
+	for _, state := range h.jobs {
+		if !state.deadline.IsZero() && now.After(state.deadline) && !state.alerted {
+			state.alerted = true
+			missed = append(missed, state)
+		}
+	}
+	grace := h.grace
+	h.mu.Unlock()
+
+	for _, state := range missed {
+		logger := slog.With(slog.String("backup_task", state.task.Name))
+		expected := state.deadline.Add(-grace)
+		logger.Warn("Backup task missed its schedule", slog.Time("expected", expected))
+		report := &RunReport{
+			Task:    state.task.Name,
+			Started: expected,
+			Error:   fmt.Sprintf("no successful run since the one scheduled for %s", expected.Format(time.RFC3339)),
+		}
+		recordFailureStreak(report)
+		state.task.emit(Event{Type: EventMissed, Report: report}, logger)
+	}
+}
+
+// problems lists why the process is degraded, sorted by job
+func (h *healthTracker) problems() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	problems := []string{}
+	for name, state := range h.jobs {
+		if state.lastError != "" {
+			problems = append(problems, fmt.Sprintf("%s: last run failed: %s", name, state.lastError))
+		}
+		if !state.deadline.IsZero() && now.After(state.deadline) {
+			problems = append(problems, fmt.Sprintf("%s: no successful run since %s", name, state.deadline.Add(-h.grace).Format(time.RFC3339)))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// runPre runs the task's pre scripts, e.g. to quiesce the application or
+// create a snapshot, before its main script
+func (task BackupTask) runPre(ctx context.Context, scripts []string, process scriptProcess, report *RunReport, logger *slog.Logger) error {
+	span := report.trace.start("pre")
+	err := executeBackup(ctx, scripts, process, logger.With(slog.String("hook", "pre")))
+	span.finish(err)
+	if err != nil {
+		return fmt.Errorf("failed during pre script: %s", err)
+	}
+	return nil
+}
+
+// runPost runs the task's post scripts, e.g. to release a snapshot, after
+// the upload whatever the run's outcome, and returns the run's error. They
+// still run when the run was cancelled or timed out, with a timeout of
+// their own. A failing post script fails an otherwise successful run
+func (task BackupTask) runPost(ctx context.Context, scripts []string, process scriptProcess, runErr error, report *RunReport, logger *slog.Logger) error {
+	ctx = context.WithoutCancel(ctx)
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(task.Timeout))
+		defer cancel()
+	}
+	span := report.trace.start("post")
+	err := executeBackup(ctx, scripts, process, logger.With(slog.String("hook", "post")))
+	span.finish(err)
+	switch {
+	case err == nil:
+		return runErr
+	case runErr == nil:
+		return fmt.Errorf("failed during post script: %s", err)
+	default:
+		logger.Warn("Post script failed", slog.String("error", err.Error()))
+		return runErr
+	}
+}
+
+// runOnFailure runs the task's on_failure scripts after a failed run, e.g.
+// to restart a service or page through a custom CLI. They get the run's
+// BACKUP_TASK, BACKUP_ID and BACKUP_ERROR in their environment
+func (task BackupTask) runOnFailure(report *RunReport, logger *slog.Logger) {
+	if len(task.OnFailure) == 0 {
+		return
+	}
+	logger = logger.With(slog.String("hook", "on_failure"))
+	ctx := context.Background()
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(task.Timeout))
+		defer cancel()
+	}
+
+	// the run may have failed on loading the secrets, which mustn't keep
+	// the hook from running
+	env, err := task.scriptEnvironment(ctx)
+	if err != nil || env == nil {
+		env = os.Environ()
+	}
+	process := scriptProcess{shell: task.Shell, env: append(env,
+		"BACKUP_TASK="+report.Task,
+		"BACKUP_ID="+report.BackupID,
+		"BACKUP_ERROR="+report.Error,
+	)}
+	if task.RunAs != nil {
+		if process.credential, err = task.RunAs.credential(); err != nil {
+			logger.Warn("On-failure script not run", slog.String("error", err.Error()))
+			return
+		}
+	}
+	if err := executeBackup(ctx, task.OnFailure, process, logger); err != nil {
+		logger.Warn("On-failure script failed", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// variablePattern matches ${VAR} and ${VAR:-default}, and $${ which
+// escapes a literal ${
+var variablePattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// scriptPlaceholders are filled in by replaceTemplate for every run, or
+// set for the on_failure scripts, not taken from the environment
+var scriptPlaceholders = map[string]bool{"BACKUP_ID": true, "TEMP_DIR": true, "BACKUP_NAME": true, "BACKUP_TASK": true, "BACKUP_ERROR": true}
+
+// scriptFields are left to the shell: a variable pasted into a script
+// would be parsed by it, break on quotes or spaces in its value, and show
+// up in the task definition and dry runs
+var scriptFields = map[string]bool{"script": true, "pre": true, "post": true, "on_failure": true, "command": true}
+
+// interpolate expands environment variables in the values of the parsed
+// configuration. Expanding the values instead of the file means a value
+// holding YAML syntax, such as a password with a colon, is taken as is
+func interpolate(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if !scriptFields[node.Content[i].Value] {
+				interpolate(node.Content[i+1])
+			}
+		}
+		return
+	}
+	if node.Kind == yaml.ScalarNode {
+		if expanded := expandVariables(node.Value); expanded != node.Value {
+			node.Value = expanded
+			if node.Style == 0 {
+				// resolve the type again, e.g. for a number
+				node.Tag = ""
+			}
+		}
+	}
+	for _, child := range node.Content {
+		interpolate(child)
+	}
+}
+
+// expandVariables replaces ${VAR} by the variable's value, and
+// ${VAR:-default} by the default when the variable is unset or empty. An
+// unset ${VAR} without a default is kept as written
+func expandVariables(value string) string {
+	return variablePattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		groups := variablePattern.FindStringSubmatch(match)
+		name, hasDefault := groups[1], strings.Contains(match, ":-")
+		if scriptPlaceholders[name] {
+			return match
+		}
+		if variable, ok := os.LookupEnv(name); ok && (variable != "" || !hasDefault) {
+			return variable
+		}
+		if hasDefault {
+			return groups[2]
+		}
+		return match
+	})
+}
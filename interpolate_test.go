@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("DB_HOST", "db")
+	t.Setenv("DB_PASSWORD", `p'w; rm -rf / $x`)
+	t.Setenv("EMPTY", "")
+
+	tests := []struct {
+		name     string
+		config   string
+		expected string
+	}{
+		{"set", "host: ${DB_HOST}", "{host: db}"},
+		{"default", "host: ${MISSING:-localhost}", "{host: localhost}"},
+		{"empty takes the default", "host: ${EMPTY:-localhost}", "{host: localhost}"},
+		{"unset is kept", "host: ${MISSING}", "{host: '${MISSING}'}"},
+		{"escaped", "host: $${DB_HOST}", "{host: '${DB_HOST}'}"},
+		{"placeholder", "path: ${TEMP_DIR}/app.sql", "{path: '${TEMP_DIR}/app.sql'}"},
+		{"number", "port: ${PORT:-5432}", "{port: 5432}"},
+		{"quoted stays a string", "port: \"${PORT:-5432}\"", "{port: '5432'}"},
+		{"password with syntax", "password: ${DB_PASSWORD}", `{password: "p'w; rm -rf / $x"}`},
+		{
+			name:     "scripts are left to the shell",
+			config:   "script: psql -h ${DB_HOST}\npre: ['echo ${DB_PASSWORD}']\npost: echo ${DB_HOST}\non_failure: echo ${DB_HOST}\ncommand: [pg_dump, '${DB_HOST}']\nworkdir: ${DB_HOST}",
+			expected: "script: psql -h ${DB_HOST}\npre: ['echo ${DB_PASSWORD}']\npost: echo ${DB_HOST}\non_failure: echo ${DB_HOST}\ncommand: [pg_dump, '${DB_HOST}']\nworkdir: db",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var document yaml.Node
+			if err := yaml.Unmarshal([]byte(test.config), &document); err != nil {
+				t.Fatal(err)
+			}
+			interpolate(&document)
+			var got, want any
+			if err := document.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(test.expected), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %#v, want %#v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// backupJobsPath is where the BackupJob custom resources of a
+	// namespace are served, see deploy/crd.yaml
+	backupJobsPath = "/apis/poc-gocron.io/v1alpha1/namespaces/%s/backupjobs"
+	// serviceAccountDir holds the credentials Kubernetes mounts into pods
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// backupJobsInterval is how often BackupJobs are checked for changes
+	backupJobsInterval = 30 * time.Second
+)
+
+// kube reads jobs from BackupJob resources when KUBERNETES_BACKUP_JOBS is
+// set, alongside the ones of the configuration file
+var kube *kubeClient
+
+// kubeClient talks to the API server of the cluster the scheduler runs in,
+// with the pod's service account
+type kubeClient struct {
+	server    string
+	namespace string
+	client    *http.Client
+
+	mu sync.Mutex
+	// names are the jobs that come from BackupJobs, whose status is
+	// written back after every run
+	names map[string]bool
+}
+
+// backupJob is a BackupJob resource; its spec holds the same settings as a
+// job of the configuration file
+type backupJob struct {
+	Metadata struct {
+		Name       string `json:"name"`
+		Generation int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec json.RawMessage `json:"spec"`
+}
+
+// backupJobStatus is written to a BackupJob after each of its runs
+type backupJobStatus struct {
+	LastRun           time.Time  `json:"lastRun"`
+	LastSucceeded     bool       `json:"lastSucceeded"`
+	LastSuccessfulRun *time.Time `json:"lastSuccessfulRun,omitempty"`
+	LastError         string     `json:"lastError"`
+	LastBackupID      string     `json:"lastBackupID"`
+	LastSize          int64      `json:"lastSize"`
+	LastDuration      string     `json:"lastDuration"`
+}
+
+func newKubeClient(namespace string) (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("KUBERNETES_BACKUP_JOBS only works inside a Kubernetes cluster")
+	}
+	if namespace == "" {
+		data, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the pod's namespace: %s", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the cluster CA: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("failed to parse the cluster CA")
+	}
+	return &kubeClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		names: map[string]bool{},
+	}, nil
+}
+
+// request calls the API server. The service account token is read for
+// every request, as Kubernetes rotates it
+func (k *kubeClient) request(ctx context.Context, method, path, contentType string, body, result any) error {
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return fmt.Errorf("failed to read the service account token: %s", err)
+	}
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, k.server+path, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return fmt.Errorf("kubernetes API returned %s: %s", resp.Status, failure.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (k *kubeClient) list(ctx context.Context) ([]backupJob, error) {
+	var list struct {
+		Items []backupJob `json:"items"`
+	}
+	if err := k.request(ctx, http.MethodGet, fmt.Sprintf(backupJobsPath, k.namespace), "", nil, &list); err != nil {
+		return nil, fmt.Errorf("failed to list BackupJobs: %s", err)
+	}
+	return list.Items, nil
+}
+
+// tasks returns a task for every BackupJob, named after the resource
+func (k *kubeClient) tasks() ([]BackupTask, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	jobs, err := k.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []BackupTask
+	names := map[string]bool{}
+	for _, job := range jobs {
+		var document yaml.Node
+		if err := yaml.Unmarshal(job.Spec, &document); err != nil {
+			return nil, fmt.Errorf("BackupJob %s: %s", job.Metadata.Name, err)
+		}
+		if err := checkKnownFields(&document, reflect.TypeOf(BackupTask{})); err != nil {
+			return nil, fmt.Errorf("BackupJob %s: %s", job.Metadata.Name, err)
+		}
+		var task BackupTask
+		if err := document.Decode(&task); err != nil {
+			return nil, fmt.Errorf("BackupJob %s: %s", job.Metadata.Name, err)
+		}
+		task.Name = job.Metadata.Name
+		tasks = append(tasks, task)
+		names[task.Name] = true
+	}
+
+	k.mu.Lock()
+	k.names = names
+	k.mu.Unlock()
+	return tasks, nil
+}
+
+// watchChanges calls onChange whenever a BackupJob was added, removed or
+// had its spec changed. Status updates don't count, they leave the
+// generation alone
+func (k *kubeClient) watchChanges(onChange func()) {
+	if k == nil {
+		return
+	}
+	fingerprint := func() (string, bool) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		jobs, err := k.list(ctx)
+		if err != nil {
+			slog.Warn("Failed to check BackupJobs for changes", slog.String("error", err.Error()))
+			return "", false
+		}
+		var generations []string
+		for _, job := range jobs {
+			generations = append(generations, fmt.Sprintf("%s:%d", job.Metadata.Name, job.Metadata.Generation))
+		}
+		slices.Sort(generations)
+		return strings.Join(generations, ","), true
+	}
+
+	applied, _ := fingerprint()
+	go func() {
+		for range time.Tick(backupJobsInterval) {
+			if current, ok := fingerprint(); ok && current != applied {
+				applied = current
+				onChange()
+			}
+		}
+	}()
+}
+
+// reportStatus writes the outcome of a run to its BackupJob, if it came
+// from one
+func (k *kubeClient) reportStatus(report *RunReport, logger *slog.Logger) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	owned := k.names[report.Task]
+	k.mu.Unlock()
+	if !owned {
+		return
+	}
+
+	status := backupJobStatus{
+		LastRun:       report.Started,
+		LastSucceeded: report.Succeeded,
+		LastError:     report.Error,
+		LastBackupID:  report.BackupID,
+		LastSize:      report.Size,
+		LastDuration:  report.Duration.Round(time.Second).String(),
+	}
+	if report.Succeeded {
+		status.LastSuccessfulRun = &report.Started
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	path := fmt.Sprintf(backupJobsPath, k.namespace) + "/" + report.Task + "/status"
+	body := map[string]any{"status": status}
+	if err := k.request(ctx, http.MethodPatch, path, "application/merge-patch+json", body, nil); err != nil {
+		logger.Warn("Failed to update the BackupJob status", slog.String("error", err.Error()))
+	}
+}
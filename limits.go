@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cgroupParent is the cgroup v2 directory the cgroups of runs with limits
+// are created in, the scheduler's own cgroup by default
+var cgroupParent string
+
+// ResourceLimits keep a job's scripts, e.g. a heavy compression step, from
+// starving the workload sharing the host. CPU, memory and IO limits apply
+// through a cgroup v2 created for every run; nice and ionice through the
+// nice and ionice commands
+type ResourceLimits struct {
+	// CPU is how many CPUs the scripts may use, e.g. 0.5
+	CPU float64 `yaml:"cpu"`
+	// Memory caps the scripts' memory; they are OOM-killed beyond it
+	Memory ByteSize `yaml:"memory"`
+	// IOWeight is the scripts' share of disk bandwidth relative to other
+	// cgroups, from 1 to 10000 with 100 as the default
+	IOWeight int `yaml:"io_weight"`
+	// Nice is the scripts' scheduling priority, from -20 to 19
+	Nice *int `yaml:"nice"`
+	// IONice is the IO scheduling class, "idle" or "best-effort" with an
+	// optional level from 0 to 7, e.g. "best-effort:7"
+	IONice string `yaml:"ionice"`
+}
+
+func (l *ResourceLimits) validate() error {
+	switch {
+	case l.CPU < 0:
+		return fmt.Errorf("cpu can't be negative")
+	case l.Memory < 0:
+		return fmt.Errorf("memory can't be negative")
+	case l.IOWeight < 0 || l.IOWeight > 10000:
+		return fmt.Errorf("io_weight must be between 1 and 10000")
+	case l.Nice != nil && (*l.Nice < -20 || *l.Nice > 19):
+		return fmt.Errorf("nice must be between -20 and 19")
+	}
+	if l.IONice != "" {
+		if _, err := l.ioniceArgs(); err != nil {
+			return err
+		}
+	}
+	if l.Nice != nil || l.IONice != "" {
+		if err := checkNiceSupport(); err != nil {
+			return err
+		}
+	}
+	if l.needsCgroup() {
+		return checkCgroupSupport()
+	}
+	return nil
+}
+
+// needsCgroup tells whether the limits are enforced through a cgroup
+func (l *ResourceLimits) needsCgroup() bool {
+	return l != nil && (l.CPU > 0 || l.Memory > 0 || l.IOWeight > 0)
+}
+
+func (l *ResourceLimits) ioniceArgs() ([]string, error) {
+	class, level, hasLevel := strings.Cut(l.IONice, ":")
+	switch class {
+	case "idle":
+		if hasLevel {
+			return nil, fmt.Errorf("ionice idle takes no level")
+		}
+		return []string{"ionice", "-c", "3"}, nil
+	case "best-effort":
+		if !hasLevel {
+			return []string{"ionice", "-c", "2"}, nil
+		}
+		if n, err := strconv.Atoi(level); err != nil || n < 0 || n > 7 {
+			return nil, fmt.Errorf("ionice level must be between 0 and 7")
+		}
+		return []string{"ionice", "-c", "2", "-n", level}, nil
+	default:
+		return nil, fmt.Errorf("ionice must be idle or best-effort")
+	}
+}
+
+// wrap prefixes a command with nice and ionice as configured, which then
+// run it with the lowered priority along with everything it starts
+func (l *ResourceLimits) wrap(args []string) []string {
+	if l == nil {
+		return args
+	}
+	if l.IONice != "" {
+		ionice, _ := l.ioniceArgs()
+		args = append(ionice, args...)
+	}
+	if l.Nice != nil {
+		args = append([]string{"nice", "-n", strconv.Itoa(*l.Nice)}, args...)
+	}
+	return args
+}
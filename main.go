@@ -2,21 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"strings"
+	"syscall"
 	"time"
+	// timezone names must resolve in minimal containers without tzdata
+	_ "time/tzdata"
 
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/go-co-op/gocron/v2"
 	"github.com/kelseyhightower/envconfig"
 	nid "github.com/matoous/go-nanoid/v2"
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	"gopkg.in/yaml.v3"
 )
 
@@ -24,169 +31,1074 @@ import (
 type Config struct {
 	StorageConfig StorageDetails `envconfig:"STORAGE"`
 	PathToConfig  string         `envconfig:"CONFIG_PATH" required:"true"`
+	// ConfigWatchInterval is how often the configuration file is checked
+	// for changes, which are reloaded automatically; disabled when zero
+	ConfigWatchInterval time.Duration `envconfig:"CONFIG_WATCH_INTERVAL" default:"0"`
+	// MetricsAddress is where /metrics, /healthz and /readyz are served,
+	// e.g. ":9090"; disabled when empty
+	MetricsAddress string `envconfig:"METRICS_ADDRESS"`
+	// HealthGracePeriod is how long past its scheduled run a job may go
+	// without succeeding before /healthz reports it
+	HealthGracePeriod time.Duration `envconfig:"HEALTH_GRACE_PERIOD" default:"1h"`
+	// ShutdownTimeout is how long runs in progress may take to finish on
+	// SIGTERM before they are cancelled
+	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"5m"`
+	// PushgatewayURL receives each job's metrics after every run
+	PushgatewayURL string `envconfig:"PUSHGATEWAY_URL"`
+	// MetricsTextfileDirectory is a node_exporter textfile collector
+	// directory that metrics are written to after every run
+	MetricsTextfileDirectory string `envconfig:"METRICS_TEXTFILE_DIR"`
+
+	// OpenTelemetry trace export over OTLP/HTTP
+	OTLPEndpoint       string `envconfig:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	OTLPTracesEndpoint string `envconfig:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"`
+	OTLPHeaders        string `envconfig:"OTEL_EXPORTER_OTLP_HEADERS"`
+	ServiceName        string `envconfig:"OTEL_SERVICE_NAME"`
+
+	// StatsD or DogStatsD metrics; STATSD_TAGS only apply with Datadog tags
+	StatsdAddress string `envconfig:"STATSD_ADDRESS"`
+	StatsdPrefix  string `envconfig:"STATSD_PREFIX" default:"backup."`
+	StatsdTags    string `envconfig:"STATSD_TAGS"`
+	StatsdDatadog bool   `envconfig:"STATSD_DATADOG" default:"false"`
+
+	// StatusFile is kept up to date with a JSON description of every job
+	StatusFile string `envconfig:"STATUS_FILE"`
+	// StateFile persists when each job last ran, for catch_up
+	StateFile string `envconfig:"STATE_FILE"`
+	// ScratchDirectory holds the runs' temporary directories, the system's
+	// temporary directory by default
+	ScratchDirectory string `envconfig:"SCRATCH_DIR"`
+	// ScratchQuota caps the total size of the runs' temporary directories
+	// in ScratchDirectory, which it needs; unlimited when zero
+	ScratchQuota ByteSize `envconfig:"SCRATCH_QUOTA" default:"0"`
+
+	// AdminAddress is where the admin API is served, e.g. "127.0.0.1:9091";
+	// every request must carry ADMIN_TOKEN as a bearer token
+	AdminAddress string `envconfig:"ADMIN_ADDRESS"`
+	AdminToken   string `envconfig:"ADMIN_TOKEN"`
+	// GRPCAddress is where the gRPC API is served, e.g. "127.0.0.1:9092",
+	// with ADMIN_TOKEN as well
+	GRPCAddress string `envconfig:"GRPC_ADDRESS"`
+
+	// HashiCorp Vault, for the storage credentials and jobs' vault_secrets
+	VaultAddress   string `envconfig:"VAULT_ADDR"`
+	VaultToken     string `envconfig:"VAULT_TOKEN"`
+	VaultNamespace string `envconfig:"VAULT_NAMESPACE"`
+	// VaultS3Path is a secret with access_key and secret_key fields, such as
+	// a key/value secret or credentials of the AWS secrets engine
+	VaultS3Path string `envconfig:"VAULT_S3_PATH"`
+
+	// KubernetesBackupJobs adds the jobs of the BackupJob resources in
+	// KUBERNETES_NAMESPACE, the pod's own by default
+	KubernetesBackupJobs bool   `envconfig:"KUBERNETES_BACKUP_JOBS" default:"false"`
+	KubernetesNamespace  string `envconfig:"KUBERNETES_NAMESPACE"`
+
+	// CgroupParent is the cgroup v2 the cgroups of jobs with limits are
+	// created in, the scheduler's own by default
+	CgroupParent string `envconfig:"CGROUP_PARENT"`
 }
 
-// StorageDetails encapsulates the details necessary for S3 storage access
+// StorageDetails encapsulates the details necessary for storage access.
+// It is read from the environment and can be overridden per backup task
+// with a storage block in the configuration file
 type StorageDetails struct {
-	ServerURL       string `envconfig:"S3_ENDPOINT" required:"true"`
-	Location        string `envconfig:"S3_REGION" required:"true"`
-	Container       string `envconfig:"S3_BUCKET" required:"true"`
-	PrivateKey      string `envconfig:"S3_SECRET_KEY" required:"true"`
-	PublicKey       string `envconfig:"S3_ACCESS_KEY" required:"true"`
-	CreateIfMissing bool   `envconfig:"S3_AUTO_CREATE_BUCKET" default:"false"`
+	Name string `ignored:"true" yaml:"name"`
+	Kind string `envconfig:"STORAGE_TYPE" default:"s3" yaml:"type"`
+
+	// S3-compatible storage
+	ServerURL       string `envconfig:"S3_ENDPOINT" yaml:"s3_endpoint"`
+	Location        string `envconfig:"S3_REGION" yaml:"s3_region"`
+	Container       string `envconfig:"S3_BUCKET" yaml:"s3_bucket"`
+	PrivateKey      string `envconfig:"S3_SECRET_KEY" yaml:"s3_secret_key"`
+	PublicKey       string `envconfig:"S3_ACCESS_KEY" yaml:"s3_access_key"`
+	CreateIfMissing bool   `envconfig:"S3_AUTO_CREATE_BUCKET" default:"false" yaml:"s3_auto_create_bucket"`
+	ObjectLocking   bool   `envconfig:"S3_OBJECT_LOCKING" default:"false" yaml:"s3_object_locking"`
+	// SSE is "s3", "kms" or "c" to request server-side encryption instead of
+	// relying on the bucket default
+	SSE            string `envconfig:"S3_SSE" yaml:"s3_sse"`
+	SSEKMSKeyID    string `envconfig:"S3_SSE_KMS_KEY_ID" yaml:"s3_sse_kms_key_id"`
+	SSECustomerKey string `envconfig:"S3_SSE_C_KEY" yaml:"s3_sse_c_key"`
+
+	// Google Cloud Storage
+	GCSBucket          string `envconfig:"GCS_BUCKET" yaml:"gcs_bucket"`
+	GCSCredentialsFile string `envconfig:"GCS_CREDENTIALS_FILE" yaml:"gcs_credentials_file"`
+
+	// Azure Blob Storage
+	AzureAccount         string `envconfig:"AZURE_STORAGE_ACCOUNT" yaml:"azure_storage_account"`
+	AzureContainer       string `envconfig:"AZURE_CONTAINER" yaml:"azure_container"`
+	AzureEndpoint        string `envconfig:"AZURE_ENDPOINT" yaml:"azure_endpoint"`
+	AzureSASToken        string `envconfig:"AZURE_SAS_TOKEN" yaml:"azure_sas_token"`
+	AzureManagedIdentity bool   `envconfig:"AZURE_USE_MANAGED_IDENTITY" default:"false" yaml:"azure_use_managed_identity"`
+	AzureClientID        string `envconfig:"AZURE_CLIENT_ID" yaml:"azure_client_id"`
+
+	// SFTP server
+	SFTPHost           string `envconfig:"SFTP_HOST" yaml:"sftp_host"`
+	SFTPUser           string `envconfig:"SFTP_USER" yaml:"sftp_user"`
+	SFTPPrivateKeyFile string `envconfig:"SFTP_PRIVATE_KEY_FILE" yaml:"sftp_private_key_file"`
+	SFTPPassphrase     string `envconfig:"SFTP_PRIVATE_KEY_PASSPHRASE" yaml:"sftp_private_key_passphrase"`
+	SFTPKnownHostsFile string `envconfig:"SFTP_KNOWN_HOSTS_FILE" yaml:"sftp_known_hosts_file"`
+	SFTPDirectory      string `envconfig:"SFTP_DIRECTORY" yaml:"sftp_directory"`
+
+	// Local filesystem
+	LocalDirectory string `envconfig:"LOCAL_DIRECTORY" yaml:"local_directory"`
+
+	// Prefix keeps every object under a key prefix, e.g. "team-a/"
+	Prefix string `envconfig:"STORAGE_PREFIX" yaml:"prefix"`
+
+	// External storage plugin
+	PluginCommand string            `envconfig:"PLUGIN_COMMAND" yaml:"plugin_command"`
+	PluginOptions map[string]string `envconfig:"PLUGIN_OPTIONS" yaml:"plugin_options"`
+
+	// rclone remote
+	RcloneRemote string `envconfig:"RCLONE_REMOTE" yaml:"rclone_remote"`
+	RcloneConfig string `envconfig:"RCLONE_CONFIG" yaml:"rclone_config"`
+	RcloneBinary string `envconfig:"RCLONE_BINARY" yaml:"rclone_binary"`
+	RcloneFlags  string `envconfig:"RCLONE_FLAGS" yaml:"rclone_flags"`
 }
 
 // BackupSpecifications defines how backup tasks are structured
 type BackupSpecifications struct {
-	Tasks []BackupTask `yaml:"jobs"`
+	Tasks         []BackupTask        `yaml:"jobs"`
+	Encryption    *EncryptionConfig   `yaml:"encryption"`
+	Notifications *NotificationConfig `yaml:"notifications"`
+	// Timezone is the IANA zone schedules are interpreted in unless a job
+	// sets its own, e.g. "Europe/Berlin"
+	Timezone string `yaml:"timezone"`
+	// Jitter applies to every job that doesn't set its own
+	Jitter Duration `yaml:"jitter"`
+	// MaxConcurrentJobs caps how many jobs run at the same time; further
+	// runs wait for one to finish. Unlimited when zero
+	MaxConcurrentJobs int `yaml:"max_concurrent_jobs"`
+	// Blackout applies to every job that doesn't set its own windows
+	Blackout []BlackoutWindow `yaml:"blackout"`
+	// OnFailure applies to every job that doesn't set its own
+	OnFailure []string `yaml:"on_failure"`
 }
 
 func main() {
-	var settings Config
-	if err := envconfig.Process("", &settings); err != nil {
-		slog.Error("Failed to load environment variables", slog.String("error", err.Error()))
-		return
+	args := os.Args[1:]
+	for len(args) > 0 && (args[0] == "--dry-run" || args[0] == "-dry-run") {
+		dryRun = true
+		args = args[1:]
 	}
+	if len(args) > 0 {
+		os.Exit(runCommand(args))
+	}
+
+	runScheduler(waitForTermination)
+}
 
-	minioClient, err := minio.New(settings.StorageConfig.ServerURL, &minio.Options{
-		Creds:  credentials.NewStaticV4(settings.StorageConfig.PublicKey, settings.StorageConfig.PrivateKey, ""),
-		Secure: true,
-	})
+// runScheduler schedules the configured tasks and runs them until wait
+// returns
+func runScheduler(wait func()) {
+	settings, storage, backupPlans, err := loadEnvironment()
 	if err != nil {
-		slog.Error("Failed to initialize MinIO client", slog.String("error", err.Error()))
+		slog.Error("Failed to initialize", slog.String("error", err.Error()))
 		return
 	}
 
-	bucketExists, err := minioClient.BucketExists(context.Background(), settings.StorageConfig.Container)
+	scheduler, err := gocron.NewScheduler()
 	if err != nil {
-		slog.Error("Failed to check if bucket exists", slog.String("error", err.Error()))
+		fmt.Printf("Failed to create a scheduler: %s\n", err)
 		return
 	}
 
-	if !bucketExists {
-		if settings.StorageConfig.CreateIfMissing {
-			if err := minioClient.MakeBucket(context.Background(), settings.StorageConfig.Container, minio.MakeBucketOptions{Region: settings.StorageConfig.Location}); err != nil {
-				slog.Error("Failed to create bucket", slog.String("error", err.Error()))
-				return
-			}
-			slog.Info("Bucket was successfully created", slog.String("bucket", settings.StorageConfig.Container))
-		} else {
-			slog.Error("Bucket does not exist", slog.String("bucket", settings.StorageConfig.Container))
+	scheduler.Start()
+	if settings.MetricsAddress != "" {
+		serveHTTP(settings.MetricsAddress)
+	}
+	if err := initialize(settings, backupPlans); err != nil {
+		logProblems("Failed to initialize", err)
+		return
+	}
+	if settings.AdminAddress != "" && settings.AdminToken == "" {
+		slog.Error("Failed to initialize", slog.String("error", "ADMIN_ADDRESS needs ADMIN_TOKEN to be set"))
+		return
+	}
+	if settings.GRPCAddress != "" && settings.AdminToken == "" {
+		slog.Error("Failed to initialize", slog.String("error", "GRPC_ADDRESS needs ADMIN_TOKEN to be set"))
+		return
+	}
+
+	tasks := &taskScheduler{scheduler: scheduler, storage: storage, configPath: settings.PathToConfig}
+	for _, task := range backupPlans.enabledTasks() {
+		// fail early on secrets that can't be read
+		if _, err := task.scriptEnvironment(context.Background()); err != nil {
+			slog.Error("Failed to load secrets", slog.String("error", err.Error()), slog.String("backup_task", task.Name))
+			return
+		}
+
+		if err := tasks.schedule(task, true); err != nil {
+			slog.Error("Failed to schedule backup task", slog.String("error", err.Error()), slog.String("backup_task", task.Name))
 			return
 		}
 	}
 
-	var backupPlans BackupSpecifications
-	if err := loadBackupConfig(settings.PathToConfig, &backupPlans); err != nil {
-		slog.Error("Failed to load backup configuration", slog.String("error", err.Error()))
-		return
+	control.togglePauseOnSignal()
+	vault.keepRenewed()
+	tasks.reloadOnSignal()
+	kube.watchChanges(func() { tasks.reloadLogged("BackupJob change") })
+	if interval := settings.ConfigWatchInterval; interval > 0 {
+		tasks.watchConfig(interval)
+	} else if isRemoteConfig(settings.PathToConfig) {
+		tasks.watchConfig(defaultRemoteConfigInterval)
+	}
+	if settings.AdminAddress != "" {
+		serveAdmin(settings.AdminAddress, settings.AdminToken, tasks)
+	}
+	if settings.GRPCAddress != "" {
+		serveGRPC(settings.GRPCAddress, settings.AdminToken, tasks)
 	}
 
-	scheduler, err := gocron.NewScheduler()
-	if err != nil {
-		fmt.Printf("Failed to create a scheduler: %s\n", err)
-		return
+	if email, ok := backupPlans.Notifications.channel("email").(*EmailConfig); ok && email.DailySummary != "" {
+		dailySummary = &runHistory{}
+		if _, err := scheduler.NewJob(
+			cronJob(email.summarySchedule(), backupPlans.Timezone, false),
+			gocron.NewTask(dailySummary.sendSummary, *email),
+		); err != nil {
+			slog.Error("Failed to schedule the daily summary", slog.String("error", err.Error()))
+			return
+		}
 	}
 
-	scheduler.Start()
+	if _, err := scheduler.NewJob(
+		gocron.DurationJob(time.Minute),
+		gocron.NewTask(health.checkMissed),
+	); err != nil {
+		slog.Error("Failed to schedule the missed backup check", slog.String("error", err.Error()))
+		return
+	}
 
-	for _, task := range backupPlans.Tasks {
+	// the watchdog keepalive runs as a job, so it stops when the scheduler
+	// hangs and systemd restarts the service
+	if interval := watchdogInterval(); interval > 0 {
 		if _, err := scheduler.NewJob(
-			gocron.CronJob(task.Schedule, false),
-			gocron.NewTask(task.Execute(minioClient, settings.StorageConfig.Container)),
+			gocron.DurationJob(interval),
+			gocron.NewTask(func() { sdNotify("WATCHDOG=1") }),
 		); err != nil {
-			slog.Error("Failed to schedule backup job", slog.String("error", err.Error()), slog.String("backup_task", task.Name))
+			slog.Error("Failed to schedule the systemd watchdog", slog.String("error", err.Error()))
 			return
 		}
 	}
 
+	health.setReady()
+	if err := sdNotify(fmt.Sprintf("READY=1\nSTATUS=Scheduling %d backup jobs", len(backupPlans.Tasks))); err != nil {
+		slog.Warn("Failed to notify systemd", slog.String("error", err.Error()))
+	}
 	slog.Info("Scheduler has started")
-	waitForTermination()
+	wait()
+	sdNotify("STOPPING=1")
 	slog.Info("Scheduler is stopping")
+	inFlight.drain(settings.ShutdownTimeout)
+	if err := scheduler.Shutdown(); err != nil {
+		slog.Warn("Failed to shut down the scheduler", slog.String("error", err.Error()))
+	}
 }
 
+// initialize sets up the optional integrations shared by every run
+func initialize(settings Config, backupPlans BackupSpecifications) error {
+	var err error
+	health.grace = settings.HealthGracePeriod
+	pushgatewayURL = settings.PushgatewayURL
+	textfileDirectory = settings.MetricsTextfileDirectory
+	cgroupParent = settings.CgroupParent
+	scratchDir, scratchQuota = settings.ScratchDirectory, settings.ScratchQuota
+	if scratchQuota > 0 && scratchDir == "" {
+		// the quota evicts directories, which mustn't be other programs'
+		return fmt.Errorf("SCRATCH_QUOTA needs SCRATCH_DIR to be set")
+	}
+	if scratchDir != "" {
+		if err := os.MkdirAll(scratchDir, 0o750); err != nil {
+			return fmt.Errorf("failed to create SCRATCH_DIR: %s", err)
+		}
+	}
+	runTracer = newTracer(settings.OTLPEndpoint, settings.OTLPTracesEndpoint, settings.OTLPHeaders, settings.ServiceName)
+	runStatus = newStatusFile(settings.StatusFile)
+	state, err = loadSchedulerState(settings.StateFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the scheduler state: %s", err)
+	}
+	statsd, err = newStatsdClient(settings.StatsdAddress, settings.StatsdPrefix, settings.StatsdTags, settings.StatsdDatadog)
+	if err != nil {
+		return err
+	}
+
+	if backupPlans.MaxConcurrentJobs > 0 {
+		jobSlots = make(chan struct{}, backupPlans.MaxConcurrentJobs)
+	}
+	return backupPlans.validate()
+}
+
+// loadEnvironment reads the settings from the environment, connects to the
+// global storage and loads the backup configuration
+func loadEnvironment() (Config, Storage, BackupSpecifications, error) {
+	var settings Config
+	var backupPlans BackupSpecifications
+	if err := envconfig.Process("", &settings); err != nil {
+		return settings, nil, backupPlans, fmt.Errorf("failed to load environment variables: %s", err)
+	}
+	if err := settings.loadSecretFiles(); err != nil {
+		return settings, nil, backupPlans, fmt.Errorf("failed to load environment variables: %s", err)
+	}
+	if err := settings.loadVault(); err != nil {
+		return settings, nil, backupPlans, fmt.Errorf("failed to load credentials from vault: %s", err)
+	}
+	if settings.KubernetesBackupJobs {
+		client, err := newKubeClient(settings.KubernetesNamespace)
+		if err != nil {
+			return settings, nil, backupPlans, err
+		}
+		kube = client
+	}
+
+	defaultStorageDetails = settings.StorageConfig
+	storage, err := newStorage(context.Background(), settings.StorageConfig)
+	if err != nil {
+		return settings, nil, backupPlans, fmt.Errorf("failed to initialize storage: %s", err)
+	}
+
+	if err := loadBackupConfig(settings.PathToConfig, &backupPlans); err != nil {
+		return settings, nil, backupPlans, fmt.Errorf("failed to load backup configuration: %s", err)
+	}
+	return settings, storage, backupPlans, nil
+}
+
+// loadBackupConfig reads the configuration file, or every file of a
+// configuration directory, adds the jobs of BackupJob resources and applies
+// the top-level defaults to the tasks
 func loadBackupConfig(path string, specs *BackupSpecifications) error {
-	fileData, err := os.ReadFile(path)
+	files, err := configFiles(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file: %s", err)
+	}
+	if len(files) == 1 && files[0] == path {
+		if err := readConfigFile(path, specs); err != nil {
+			return err
+		}
+	} else if err := readConfigDirectory(files, specs); err != nil {
+		return err
+	}
+	if kube != nil {
+		tasks, err := kube.tasks()
+		if err != nil {
+			return err
+		}
+		specs.Tasks = append(specs.Tasks, tasks...)
+	}
+
+	for i := range specs.Tasks {
+		if specs.Tasks[i].Encryption == nil {
+			specs.Tasks[i].Encryption = specs.Encryption
+		}
+		specs.Tasks[i].Notifications = specs.Notifications.merge(specs.Tasks[i].Notifications)
+		if specs.Tasks[i].Timezone == "" {
+			specs.Tasks[i].Timezone = specs.Timezone
+		}
+		if specs.Tasks[i].Jitter == 0 {
+			specs.Tasks[i].Jitter = specs.Jitter
+		}
+		if specs.Tasks[i].Blackout == nil {
+			specs.Tasks[i].Blackout = specs.Blackout
+		}
+		if specs.Tasks[i].OnFailure == nil {
+			specs.Tasks[i].OnFailure = specs.OnFailure
+		}
+	}
+	return nil
+}
+
+func readConfigFile(path string, specs *BackupSpecifications) error {
+	fileData, err := readConfigSource(path)
 	if err != nil {
 		return fmt.Errorf("failed to read configuration file: %s", err)
 	}
-	if err := yaml.Unmarshal(fileData, specs); err != nil {
+	format := configFormat(path)
+	if format != "toml" && isSOPSEncrypted(fileData) {
+		if fileData, err = decryptSOPS(fileData, format); err != nil {
+			return fmt.Errorf("failed to decrypt configuration file: %s", err)
+		}
+	}
+	// JSON is valid YAML, so only TOML needs a parser of its own
+	var document yaml.Node
+	if format == "toml" {
+		parsed, err := parseTOML(fileData)
+		if err != nil {
+			return fmt.Errorf("failed to parse configuration file: %s", err)
+		}
+		document = *parsed
+	} else if err := yaml.Unmarshal(fileData, &document); err != nil {
+		return fmt.Errorf("failed to parse configuration file: %s", err)
+	}
+	interpolate(&document)
+	if err := resolveFromFile(&document); err != nil {
+		return fmt.Errorf("failed to parse configuration file: %s", err)
+	}
+	if err := applyMatrix(&document); err != nil {
+		return fmt.Errorf("failed to parse configuration file: %s", err)
+	}
+	if err := applyTemplates(&document); err != nil {
 		return fmt.Errorf("failed to parse configuration file: %s", err)
 	}
+	if err := applyDefaults(&document); err != nil {
+		return fmt.Errorf("failed to parse configuration file: %s", err)
+	}
+	if err := checkKnownFields(&document, reflect.TypeOf(*specs)); err != nil {
+		return fmt.Errorf("failed to parse configuration file: %s", err)
+	}
+	if err := document.Decode(specs); err != nil {
+		return fmt.Errorf("failed to parse configuration file: %s", err)
+	}
+	if !isRemoteConfig(path) {
+		for i, task := range specs.Tasks {
+			if task.ScriptFile != "" && !filepath.IsAbs(task.ScriptFile) {
+				specs.Tasks[i].ScriptFile = filepath.Join(filepath.Dir(path), task.ScriptFile)
+			}
+		}
+	}
 	return nil
 }
 
+// enabledTasks returns the tasks that aren't disabled, logging the others
+func (specs BackupSpecifications) enabledTasks() []BackupTask {
+	var tasks []BackupTask
+	for _, task := range specs.Tasks {
+		if task.Enabled != nil && !*task.Enabled {
+			slog.Info("Skipping disabled backup task", slog.String("backup_task", task.Name))
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
 type BackupTask struct {
-	Name           string   `yaml:"name"`
-	Schedule       string   `yaml:"schedule"`
-	Commands       []string `yaml:"script"`
-	TargetFilePath string   `yaml:"filepath_to_upload"`
+	Name string `yaml:"name"`
+	// Schedule is one cron expression or a list of them, all running the
+	// same task
+	Schedule stringList `yaml:"schedule"`
+	Commands []string   `yaml:"script"`
+	// Shell runs the scripts and hooks: sh (the default), bash, pwsh,
+	// powershell or cmd (the default on Windows)
+	Shell string `yaml:"shell"`
+	// Command is run directly instead of a script, as the program and its
+	// arguments, without a shell and its quoting pitfalls
+	Command []string `yaml:"command"`
+	// ScriptFile is a file holding the script, read for every run, so long
+	// scripts can be versioned and linted on their own. A relative path is
+	// resolved from the configuration file's directory
+	ScriptFile string `yaml:"script_file"`
+	// Pre runs before the script, e.g. to quiesce the application or
+	// create a snapshot
+	Pre []string `yaml:"pre"`
+	// Post runs after the upload, e.g. to release the snapshot, even when
+	// the script or upload failed
+	Post []string `yaml:"post"`
+	// OnFailure runs after a failed run, with BACKUP_TASK, BACKUP_ID and
+	// BACKUP_ERROR set, e.g. to restart a service
+	OnFailure []string `yaml:"on_failure"`
+	// Enabled parks the task without removing it from the configuration
+	// when false
+	Enabled *bool `yaml:"enabled"`
+	// Timezone is the IANA zone the schedule is interpreted in, the
+	// top-level timezone or the process' local one by default
+	Timezone string `yaml:"timezone"`
+	// ScheduleWithSeconds reads the schedule with a leading seconds field;
+	// six-field schedules are recognized without it
+	ScheduleWithSeconds bool `yaml:"schedule_with_seconds"`
+	// Every runs the task at a fixed interval instead of a schedule,
+	// counted from when the scheduler started
+	Every Duration `yaml:"every"`
+	// RunOnStart runs the task as soon as the scheduler starts, then on
+	// its schedule
+	RunOnStart bool `yaml:"run_on_start"`
+	// CatchUp runs the task on start when a scheduled run was missed while
+	// the scheduler was down, according to STATE_FILE
+	CatchUp bool `yaml:"catch_up"`
+	// Overlap decides what happens when the task is due while its previous
+	// run is still going: "allow" (the default) runs both, "skip" drops the
+	// new run, "queue" starts it once the previous one finished and
+	// "cancel_previous" stops the previous run
+	Overlap string `yaml:"overlap"`
+	// Group names a set of tasks of which only one runs at a time, e.g.
+	// all dumps of one database server
+	Group string `yaml:"group"`
+	// Blackout windows defer runs that are due while they are open
+	Blackout []BlackoutWindow `yaml:"blackout"`
+	// Jitter delays every run by a random duration up to its value
+	Jitter Duration `yaml:"jitter"`
+	// Timeout fails a run that takes longer, retries included, killing its
+	// script with every process it started
+	Timeout Duration `yaml:"timeout"`
+	// Retries is how many times a failed run is run again before it
+	// counts as failed
+	Retries int `yaml:"retries"`
+	// Backoff is "exponential" (the default), doubling the retry delay up
+	// to max_retry_delay, or "constant"
+	Backoff       string   `yaml:"backoff"`
+	RetryDelay    Duration `yaml:"retry_delay"`
+	MaxRetryDelay Duration `yaml:"max_retry_delay"`
+	// DryRun logs what the task would do instead of running its script and
+	// uploading, like the --dry-run flag does for every task
+	DryRun bool `yaml:"dry_run"`
+	// Env holds environment variables set for the task's scripts, on top
+	// of the ones they inherit
+	Env map[string]string `yaml:"env"`
+	// InheritEnv limits the variables the scripts inherit from the process
+	// to the ones it names, and PATH; all of them are inherited when unset
+	InheritEnv []string `yaml:"inherit_env"`
+	// RunAs is the user and group the scripts run as, the scheduler's own
+	// when unset
+	RunAs *RunAs `yaml:"run_as"`
+	// Limits restrict the CPU, memory and IO the scripts may use
+	Limits *ResourceLimits `yaml:"limits"`
+	// VaultSecrets maps environment variables of the scripts to Vault
+	// secrets, as "path#field"
+	VaultSecrets map[string]string `yaml:"vault_secrets"`
+	// After names tasks whose latest run must have succeeded for this one
+	// to run. Without a schedule, the task runs whenever they all did
+	After []string `yaml:"after"`
+	// TargetFilePaths are the files, directories or glob patterns uploaded
+	// after the script ran; every match shares the run's backup ID
+	TargetFilePaths stringList `yaml:"filepath_to_upload"`
+	// WorkDir is the directory the scripts run in and relative upload
+	// paths are resolved from, the run's temporary directory by default
+	WorkDir string `yaml:"workdir"`
+	// UploadLog stores the output of the run's scripts next to its
+	// artifact, as <object>.log
+	UploadLog bool `yaml:"upload_log"`
+	// KeepFailedTempDir leaves the temporary directory of a failed run in
+	// place for inspection instead of removing it
+	KeepFailedTempDir bool `yaml:"keep_failed_temp_dir"`
+	// MinFreeSpace is the free space a run needs on the volume of its
+	// temporary directory to start
+	MinFreeSpace *FreeSpace `yaml:"min_free_space"`
+	// Exclude holds gitignore-style patterns left out of directory archives
+	// and glob matches
+	Exclude []string `yaml:"exclude"`
+
+	Storage      *StorageDetails    `yaml:"storage"`
+	Destinations []StorageDetails   `yaml:"destinations"`
+	Retention    *RetentionPolicy   `yaml:"retention"`
+	StorageClass string             `yaml:"storage_class"`
+	ObjectLock   *ObjectLock        `yaml:"object_lock"`
+	Tiering      *TieringPolicy     `yaml:"tiering"`
+	Stream       *StreamConfig      `yaml:"stream"`
+	Split        *SplitConfig       `yaml:"split"`
+	Compression  *CompressionConfig `yaml:"compress"`
+	Encryption   *EncryptionConfig  `yaml:"encryption"`
+	// Presign is how long the download links generated for every uploaded
+	// object stay valid; none are generated when it is unset
+	Presign   Duration   `yaml:"presign"`
+	SizeCheck *SizeCheck `yaml:"size_check"`
+
+	Notifications *NotificationConfig `yaml:"notifications"`
+	Ping          *PingConfig         `yaml:"ping"`
 }
 
-func (task BackupTask) Execute(client *minio.Client, bucketName string) func() {
+// validate checks the task's configuration before it is scheduled or run,
+// returning every problem at once
+func (task BackupTask) validate() error {
+	var problems []error
+	check := func(field string, err error) {
+		if err != nil {
+			if field != "" {
+				err = fmt.Errorf("%s: %s", field, err)
+			}
+			problems = append(problems, err)
+		}
+	}
+
+	check("", task.validateSchedule())
+	if task.CatchUp && state == nil {
+		check("catch_up", fmt.Errorf("needs STATE_FILE to be set"))
+	}
+	for _, scripts := range []struct {
+		field    string
+		commands []string
+	}{{"pre", task.Pre}, {"script", task.Commands}, {"post", task.Post}, {"on_failure", task.OnFailure}} {
+		for i, command := range scripts.commands {
+			if strings.TrimSpace(command) == "" {
+				check(fmt.Sprintf("%s[%d]", scripts.field, i), fmt.Errorf("empty command"))
+			}
+		}
+	}
+	if task.ScriptFile != "" {
+		if len(task.Commands) > 0 || len(task.Command) > 0 {
+			check("script_file", fmt.Errorf("can't be used together with script or command"))
+		} else if _, err := os.Stat(task.ScriptFile); err != nil {
+			check("script_file", err)
+		}
+	}
+	if len(task.Command) > 0 && len(task.Commands) > 0 {
+		check("command", fmt.Errorf("can't be used together with script"))
+	} else if len(task.Command) > 0 && strings.TrimSpace(task.Command[0]) == "" {
+		check("command", fmt.Errorf("the program is empty"))
+	}
+	check("shell", validateShell(task.Shell))
+	check("", task.validateEnv())
+	check("", task.validateRetries())
+	if task.Stream == nil && len(task.TargetFilePaths) == 0 {
+		check("filepath_to_upload", fmt.Errorf("required unless stream is set"))
+	}
+	if task.RunAs != nil {
+		check("run_as", task.RunAs.validate())
+	}
+	if task.Limits != nil {
+		check("limits", task.Limits.validate())
+	}
+	if task.ObjectLock != nil {
+		check("object_lock", task.ObjectLock.validate())
+	}
+	if task.Tiering != nil {
+		check("tiering", task.Tiering.validate())
+	}
+	if _, err := newExcludeMatcher(task.Exclude); err != nil {
+		check("exclude", err)
+	}
+	if task.Stream != nil {
+		check("stream", task.validateStream())
+	}
+	if task.Split != nil {
+		check("split", task.Split.validate())
+	}
+	if task.Compression != nil {
+		check("compress", task.Compression.validate())
+	}
+	if task.Encryption != nil {
+		check("encryption", task.Encryption.validate())
+	}
+	if task.SizeCheck != nil {
+		check("size_check", task.SizeCheck.validate())
+	}
+	check("", task.validatePresign())
+	if task.Notifications != nil {
+		check("notifications", task.Notifications.validate())
+	}
+	if task.Ping != nil {
+		check("ping", task.Ping.validate())
+	}
+	return errors.Join(problems...)
+}
+
+func (task BackupTask) Execute(destinations []Destination) func() {
 	slog.Info("Preparing to execute backup task", slog.String("backup_task", task.Name))
+	var slot runSlot
 
 	return func() {
+		leave, ok := slot.enter(task.Overlap, slog.With(slog.String("backup_task", task.Name)))
+		if !ok {
+			return
+		}
+		defer leave()
+		task.delay()
 		backupID, _ := nid.Generate("1234567890abcdefghijklmnopqrstuvwxyz", 8)
 		logger := slog.With(
 			slog.String("id", backupID),
 			slog.String("backup_task", task.Name),
 		)
 
+		if control.isPaused(task.Name) {
+			logger.Info("Skipping backup task, it is paused")
+			return
+		}
+		if task.isDryRun() {
+			task.simulate(destinations, backupID, logger)
+			// with every task simulated, the ones that come after it are
+			// simulated next
+			if dryRun {
+				recordOutcome(&RunReport{Task: task.Name, BackupID: backupID, Succeeded: true}, logger)
+			}
+			return
+		}
+		if name, failed := task.dependencyFailed(); failed {
+			logger.Warn("Skipping backup task, the latest run of a task it comes after did not succeed", slog.String("after", name))
+			return
+		}
+		task.waitForBlackout(logger)
+		ctx, done, ok := inFlight.enter()
+		if !ok {
+			logger.Info("Skipping backup task, the scheduler is shutting down")
+			return
+		}
+		defer done()
+		if task.Overlap == "cancel_previous" {
+			var release func()
+			ctx, release = slot.acquire(ctx, logger)
+			defer release()
+		}
+		defer lockGroup(task.Group, logger)()
+		defer waitForSlot(logger)()
+		if task.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(task.Timeout))
+			defer cancel()
+		}
+		ctx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+
 		logger.Info("Backup task started")
-		defer logger.Info("Backup task completed")
+		report := &RunReport{Task: task.Name, BackupID: backupID, Started: time.Now(), trace: runTracer.newTrace(task.Name, backupID)}
+		runStatus.started(report)
+		controlled := control.started(report, cancel)
+		state.recordRun(task.Name, report.Started)
+		sdNotify("STATUS=Running " + task.Name)
+		task.emit(Event{Type: EventStarted, Report: report}, logger)
+		err := task.runWithRetries(ctx, report, destinations, logger)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s: %s", time.Duration(task.Timeout), err)
+		} else if cause := context.Cause(ctx); err != nil && cause != ctx.Err() {
+			// cancelled on shutdown or through the admin API
+			err = fmt.Errorf("%s: %s", cause, err)
+		}
+		report.finish(err)
+		recordFailureStreak(report)
+		if err := report.trace.export(err); err != nil {
+			logger.Warn("Failed to export the run trace", slog.String("error", err.Error()))
+		}
+		metrics.record(report)
+		runStatus.finished(report)
+		control.finished(controlled, report)
+		runFeed.publish(*report)
+		health.record(report)
+		recordOutcome(report, logger)
+		kube.reportStatus(report, logger)
+		if report.Succeeded {
+			sdNotify(fmt.Sprintf("STATUS=Last run: %s succeeded at %s", task.Name, time.Now().Format(time.TimeOnly)))
+		} else {
+			sdNotify(fmt.Sprintf("STATUS=Last run: %s failed at %s", task.Name, time.Now().Format(time.TimeOnly)))
+		}
+		if err := statsd.record(report); err != nil {
+			logger.Warn("Failed to send StatsD metrics", slog.String("error", err.Error()))
+		}
+		if pushgatewayURL != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := metrics.push(ctx, pushgatewayURL, task.Name); err != nil {
+				logger.Warn("Failed to push metrics to the Pushgateway", slog.String("error", err.Error()))
+			}
+			cancel()
+		}
+		if textfileDirectory != "" {
+			if err := metrics.writeTextfile(textfileDirectory); err != nil {
+				logger.Warn("Failed to write the metrics textfile", slog.String("error", err.Error()))
+			}
+		}
+		if err != nil {
+			logger.Error("Backup task failed", slog.String("error", err.Error()))
+			task.emit(Event{Type: EventFailed, Report: report}, logger)
+			task.runOnFailure(report, logger)
+		} else {
+			logger.Info("Backup task completed", slog.Duration("duration", report.Duration))
+			task.emit(Event{Type: EventSucceeded, Report: report}, logger)
+		}
+	}
+}
+
+// run performs one backup run, recording what it uploaded in the report.
+// Cancelling ctx kills the scripts and aborts artifact preparation and
+// uploads
+func (task BackupTask) run(ctx context.Context, report *RunReport, destinations []Destination, logger *slog.Logger) (err error) {
+	tempDir, err := createTemporaryDirectory(task.Name, report.BackupID)
+	if err != nil {
+		return fmt.Errorf("failed to create a temporary directory: %s", err)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stopWatching := watchScratchQuota(cancel, logger)
+	// deferred first, so the directory outlives everything that uses it
+	defer func() {
+		stopWatching()
+		if err != nil && errors.Is(context.Cause(ctx), errScratchQuota) {
+			err = fmt.Errorf("%s: %s", errScratchQuota, err)
+		}
+		task.removeTemporaryDirectory(tempDir, err, logger)
+	}()
+	if err := task.checkFreeSpace(ctx, tempDir, report, destinations, logger); err != nil {
+		return err
+	}
+
+	putOptions := PutOptions{StorageClass: task.StorageClass}
+	if task.ObjectLock != nil {
+		putOptions.LockMode = task.ObjectLock.Mode
+		putOptions.RetainUntil = time.Now().Add(time.Duration(task.ObjectLock.RetainFor))
+	}
 
-		tempDir, err := createTemporaryDirectory(task.Name, backupID)
+	if task.ScriptFile != "" {
+		script, err := os.ReadFile(task.ScriptFile)
 		if err != nil {
-			logger.Error("Failed to create a temporary directory", slog.String("error", err.Error()))
-			return
+			return fmt.Errorf("failed to read the script_file: %s", err)
 		}
+		task.Commands = []string{string(script)}
+	}
+	task.Commands = processScripts(task.Commands, tempDir, report.BackupID)
+	task.Command = processScripts(task.Command, tempDir, report.BackupID)
+	workDir := task.workingDirectory(tempDir, report.BackupID)
+	process, err := task.scriptProcess(ctx, workDir, report.BackupID)
+	if err != nil {
+		return err
+	}
+	defer process.close(logger)
+	if process.log, err = newRunLog(tempDir); err != nil {
+		return fmt.Errorf("failed to create the run's log file: %s", err)
+	}
+	// deferred before the post scripts, to include their output
+	defer func() {
+		process.log.Close()
+		if task.UploadLog {
+			task.uploadRunLog(process.log, report, destinations, logger)
+		}
+	}()
+	if process.credential != nil {
+		// the scripts write their output to the temporary directory
+		if err := handOver(tempDir, process.credential); err != nil {
+			return fmt.Errorf("failed to hand the temporary directory to run_as: %s", err)
+		}
+	}
+	if len(task.Post) > 0 {
+		post := processScripts(task.Post, tempDir, report.BackupID)
+		defer func() { err = task.runPost(ctx, post, process, err, report, logger) }()
+	}
+	if len(task.Pre) > 0 {
+		if err := task.runPre(ctx, processScripts(task.Pre, tempDir, report.BackupID), process, report, logger); err != nil {
+			return err
+		}
+	}
+	if task.Stream != nil {
+		span := report.trace.start("stream")
+		uploaded, err := task.streamBackup(ctx, report, process, destinations, putOptions, logger)
+		span.finish(err)
+		if err != nil {
+			return err
+		}
+		if err := task.checkSize(ctx, report, uploaded, logger); err != nil {
+			return err
+		}
+		return task.finishRun(ctx, destinations, uploaded, logger)
+	}
+	span := report.trace.start("script")
+	if len(task.Command) > 0 {
+		err = runLogged(processCommand(ctx, task.Command, process), process, logger)
+	} else {
+		err = executeBackup(ctx, task.Commands, process, logger)
+	}
+	span.finish(err)
+	if err != nil {
+		return fmt.Errorf("failed during backup execution: %s", err)
+	}
 
-		processScripts(task.Commands, tempDir, backupID)
-		if err := executeBackup(task.Commands, logger); err != nil {
-			logger.Error("Failed during backup execution", slog.String("error", err.Error()))
-			return
+	exclude, err := newExcludeMatcher(task.Exclude)
+	if err != nil {
+		return err
+	}
+	span = report.trace.start("validate")
+	targets, err := expandTargets(task.uploadPatterns(workDir, tempDir, report.BackupID), exclude)
+	span.finish(err)
+	if err != nil {
+		return fmt.Errorf("failed to validate the backup file: %s", err)
+	}
+
+	received := map[string]int{}
+	for i, target := range targets {
+		workDir, err := targetWorkDir(tempDir, i, len(targets))
+		if err != nil {
+			return fmt.Errorf("failed to create a temporary directory: %s", err)
+		}
+		span := report.trace.start("prepare", "backup.target", target)
+		artifactPath, fileExtension, err := task.prepareArtifact(ctx, target, workDir, exclude)
+		span.finish(err)
+		if err != nil {
+			return fmt.Errorf("failed to prepare %s: %s", target, err)
+		}
+		if len(targets) > 1 {
+			fileExtension = artifactExtension(target, fileExtension)
 		}
 
-		if _, err := validateFile(task.TargetFilePath); err != nil {
-			logger.Error("Failed to validate the backup file", slog.String("error", err.Error()))
-			return
+		span = report.trace.start("detect_mime_type")
+		mimeType, err := detectMimeType(artifactPath)
+		span.finish(err)
+		if err != nil {
+			return fmt.Errorf("failed to detect MIME type of the file: %s", err)
 		}
+		putOptions.ContentType = mimeType
 
-		fileExtension := filepath.Ext(task.TargetFilePath)
-		newFileName := generateFileName(task.Name, backupID, fileExtension)
-		if mimeType, err := detectMimeType(task.TargetFilePath); err != nil {
-			logger.Error("Failed to detect MIME type of the file", slog.String("error", err.Error()))
-			return
+		newFileName := generateFileName(task.Name, report.BackupID, fileExtension)
+		var uploaded []Destination
+		uploadStarted := time.Now()
+		span = report.trace.start("upload", "backup.object", newFileName)
+		if task.Split != nil {
+			uploaded = task.Split.upload(ctx, destinations, newFileName, artifactPath, workDir, putOptions, logger)
 		} else {
-			uploadFile(client, bucketName, newFileName, task.TargetFilePath, mimeType, logger)
+			uploaded = uploadFile(ctx, destinations, newFileName, artifactPath, putOptions, logger)
+			uploadChecksum(ctx, uploaded, newFileName, artifactPath, workDir, putOptions, logger)
+		}
+		span.set("backup.destinations", fmt.Sprintf("%d/%d", len(uploaded), len(destinations)))
+		span.finish(nil)
+		report.UploadDuration += time.Since(uploadStarted)
+		for _, destination := range uploaded {
+			received[destination.Name]++
 		}
+		report.URLs = append(report.URLs, objectLocations(uploaded, newFileName)...)
+		if info, err := os.Stat(artifactPath); err == nil {
+			report.Size += info.Size()
+		}
+		report.Objects = append(report.Objects, newFileName)
+		task.emitUploaded(report, uploaded, newFileName, logger)
+	}
+
+	var complete []Destination
+	for _, destination := range destinations {
+		if received[destination.Name] == len(targets) {
+			complete = append(complete, destination)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to upload the backup: %s", err)
 	}
+	if err := task.checkSize(ctx, report, complete, logger); err != nil {
+		return err
+	}
+	return task.finishRun(ctx, destinations, complete, logger)
 }
 
-func createTemporaryDirectory(name, id string) (string, error) {
-	directoryPath := fmt.Sprintf("%s/backup-%s-%s-", os.TempDir(), name, id)
-	return os.MkdirTemp(directoryPath, "")
+// finishRun applies retention and tiering to the destinations that received
+// the whole run, so a partial run never causes complete older ones to be
+// pruned, and fails the run if any destination is missing it
+func (task BackupTask) finishRun(ctx context.Context, destinations, complete []Destination, logger *slog.Logger) error {
+	for _, destination := range complete {
+		task.applyRetention(ctx, destination, logger, false)
+		task.applyTiering(ctx, destination, logger)
+	}
+	if len(complete) == 0 {
+		return fmt.Errorf("failed to upload the backup to any destination")
+	}
+	if len(complete) < len(destinations) {
+		return fmt.Errorf("backup is missing from %d of %d destinations", len(destinations)-len(complete), len(destinations))
+	}
+	return nil
 }
 
-func processScripts(scripts []string, tempDir, id string) {
+// processScripts returns the scripts with the run's placeholders filled
+// in, leaving the task's own for its next runs
+func processScripts(scripts []string, tempDir, id string) []string {
+	processed := make([]string, len(scripts))
 	for i, script := range scripts {
-		scripts[i] = replaceTemplate(script, id, tempDir)
+		processed[i] = replaceTemplate(script, id, tempDir)
+	}
+	return processed
+}
+
+// workingDirectory returns the directory the task's scripts run in
+func (task BackupTask) workingDirectory(tempDir, id string) string {
+	if task.WorkDir == "" {
+		return tempDir
+	}
+	return replaceTemplate(task.WorkDir, id, tempDir)
+}
+
+// uploadPatterns returns the task's upload targets with the run's
+// placeholders filled in and relative paths resolved from the directory
+// its scripts ran in
+func (task BackupTask) uploadPatterns(workDir, tempDir, id string) []string {
+	patterns := make([]string, len(task.TargetFilePaths))
+	for i, pattern := range task.TargetFilePaths {
+		patterns[i] = replaceTemplate(pattern, id, tempDir)
+		if !filepath.IsAbs(patterns[i]) {
+			patterns[i] = filepath.Join(workDir, patterns[i])
+		}
 	}
+	return patterns
 }
 
-func executeBackup(scripts []string, logger *slog.Logger) error {
-	cmd := exec.Command("sh", "-c", strings.Join(scripts, " \n"))
-	cmd.Stderr = newLogger(logger, true)
-	cmd.Stdout = newLogger(logger, false)
+func executeBackup(ctx context.Context, scripts []string, process scriptProcess, logger *slog.Logger) error {
+	return runLogged(scriptCommand(ctx, joinScript(process.shell, scripts), process), process, logger)
+}
+
+// runLogged runs the command, logging its output
+func runLogged(cmd *exec.Cmd, process scriptProcess, logger *slog.Logger) error {
+	cmd.Stderr = process.output(newLogger(logger, true))
+	cmd.Stdout = process.output(newLogger(logger, false))
 	return cmd.Run()
 }
 
-func validateFile(path string) (bool, error) {
-	if _, err := os.Stat(path); err != nil {
-		return false, err
+// scriptProcess is how a task's scripts are started
+type scriptProcess struct {
+	// shell runs the scripts, the platform's default when empty
+	shell string
+	dir   string
+	// env is nil to inherit the scheduler's environment
+	env []string
+	// credential is nil to run as the scheduler's user
+	credential *processCredential
+	limits     *ResourceLimits
+	// cgroup is the run's cgroup when limits need one
+	cgroup *runCgroup
+	// log receives the scripts' output besides the scheduler's log, if set
+	log *runLog
+}
+
+// scriptProcess returns how the task's scripts are started, running in
+// workDir. It must be closed once they are done
+func (task BackupTask) scriptProcess(ctx context.Context, workDir, id string) (scriptProcess, error) {
+	process := scriptProcess{shell: task.Shell, dir: workDir, limits: task.Limits}
+	var err error
+	if process.env, err = task.scriptEnvironment(ctx); err != nil {
+		return process, fmt.Errorf("failed to load secrets: %s", err)
+	}
+	if task.RunAs != nil {
+		if process.credential, err = task.RunAs.credential(); err != nil {
+			return process, fmt.Errorf("failed to resolve run_as: %s", err)
+		}
+	}
+	if task.Limits.needsCgroup() {
+		if process.cgroup, err = newRunCgroup(task.Name+"-"+id, task.Limits); err != nil {
+			return process, fmt.Errorf("failed to create a cgroup for the limits: %s", err)
+		}
+	}
+	return process, nil
+}
+
+// output adds the run's log file to where the scripts' output goes
+func (process scriptProcess) output(w io.Writer) io.Writer {
+	if process.log == nil {
+		return w
+	}
+	return io.MultiWriter(w, process.log)
+}
+
+// close removes the run's cgroup, if any
+func (process scriptProcess) close(logger *slog.Logger) {
+	if process.cgroup == nil {
+		return
+	}
+	if err := process.cgroup.remove(); err != nil {
+		logger.Warn("Failed to remove the run's cgroup", slog.String("error", err.Error()))
+	}
+}
+
+// scriptCommand runs a script with the process' shell
+func scriptCommand(ctx context.Context, script string, process scriptProcess) *exec.Cmd {
+	cmd := processCommand(ctx, shellArgs(process.shell, script), process)
+	if process.shell == "cmd" || process.shell == "" && defaultShell == "cmd" {
+		setCommandLine(cmd, cmdLine(script))
 	}
-	return true, nil
+	return cmd
+}
+
+// processCommand runs a command in a process group of its own. Cancelling
+// ctx, e.g. on the task's timeout, kills the whole group, see
+// configureProcess
+func processCommand(ctx context.Context, args []string, process scriptProcess) *exec.Cmd {
+	args = process.limits.wrap(args)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = process.dir
+	cmd.Env = process.env
+	configureProcess(cmd, process)
+	// don't wait forever for output pipes a stray process kept open
+	cmd.WaitDelay = 10 * time.Second
+	return cmd
+}
+
+func validateFile(path string) (fs.FileInfo, error) {
+	return os.Stat(path)
 }
 
 func generateFileName(baseName, id, extension string) string {
@@ -194,6 +1106,18 @@ func generateFileName(baseName, id, extension string) string {
 	return fmt.Sprintf("%s-%s-%s%s", timestamp, baseName, id, extension)
 }
 
+var fileNamePattern = regexp.MustCompile(`^\d{4}(?:_\d{2}){6}-(.+)-([0-9a-z]{8})(?:\..*)?$`)
+
+// parseFileName extracts the task name and backup ID from an object name
+// produced by generateFileName
+func parseFileName(objectName string) (baseName, id string, ok bool) {
+	match := fileNamePattern.FindStringSubmatch(path.Base(objectName))
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
+
 func detectMimeType(filePath string) (string, error) {
 	mtype, err := mimetype.DetectFile(filePath)
 	if err != nil {
@@ -202,18 +1126,38 @@ func detectMimeType(filePath string) (string, error) {
 	return mtype.String(), nil
 }
 
-func uploadFile(client *minio.Client, bucket, fileName, filePath, mimeType string, logger *slog.Logger) {
-	if _, err := client.FPutObject(
-		context.Background(),
-		bucket,
-		fileName,
-		filePath,
-		minio.PutObjectOptions{
-			ContentType: mimeType,
-		},
-	); err != nil {
-		logger.Error("Failed to upload the file to object storage", slog.String("error", err.Error()))
+// uploadFile sends the artifact to every destination, logging each outcome
+// separately so a failing endpoint doesn't hide the others' results, and
+// returns the destinations that received it. Cancelling ctx aborts the
+// uploads in progress
+func uploadFile(ctx context.Context, destinations []Destination, fileName, filePath string, opts PutOptions, logger *slog.Logger) []Destination {
+	var uploaded []Destination
+	for _, destination := range destinations {
+		destinationLogger := logger.With(slog.String("destination", destination.Name))
+		if err := destination.Storage.Put(ctx, fileName, filePath, opts); err != nil {
+			destinationLogger.Error("Failed to upload the file to object storage", slog.String("error", err.Error()))
+			continue
+		}
+		destinationLogger.Info("Uploaded the file to object storage", slog.String("object", fileName))
+		uploaded = append(uploaded, destination)
+	}
+	if len(uploaded) > 0 && len(uploaded) < len(destinations) {
+		logger.Warn("Backup is missing from some destinations",
+			slog.Int("failed", len(destinations)-len(uploaded)), slog.Int("destinations", len(destinations)))
+	}
+	return uploaded
+}
+
+// objectLocations returns where the object can be found in each of the
+// destinations that can tell
+func objectLocations(destinations []Destination, objectName string) []string {
+	var locations []string
+	for _, destination := range destinations {
+		if locator, ok := storageAs[Locator](destination.Storage); ok {
+			locations = append(locations, locator.Location(objectName))
+		}
 	}
+	return locations
 }
 
 func replaceTemplate(original, id, tempDir string) string {
@@ -250,6 +1194,6 @@ func (c *CommandLogger) Write(data []byte) (int, error) {
 
 func waitForTermination() {
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, os.Kill)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	<-signals
 }
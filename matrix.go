@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyMatrix expands every job of a parsed configuration file that has a
+// matrix into one job per combination of its values, which fill the job's
+// {{ .variable }} placeholders, e.g. in its script and file name. A job
+// whose name doesn't use the variables gets their values appended to it
+func applyMatrix(document *yaml.Node) error {
+	root := document
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	jobs := mappingValue(root, "jobs")
+	if jobs == nil || jobs.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	var expanded []*yaml.Node
+	for _, job := range jobs.Content {
+		i := -1
+		if job.Kind == yaml.MappingNode {
+			i = mappingIndex(job, "matrix")
+		}
+		if i < 0 {
+			expanded = append(expanded, job)
+			continue
+		}
+		matrix := job.Content[i+1]
+		job.Content = slices.Delete(slices.Clone(job.Content), i, i+2)
+
+		combinations, err := matrixCombinations(matrix)
+		if err != nil {
+			return err
+		}
+		name := mappingValue(job, "name")
+		for _, combination := range combinations {
+			variables := map[string]string{}
+			var values []string
+			for _, variable := range combination {
+				variables[variable[0]] = variable[1]
+				values = append(values, variable[1])
+			}
+			instance, err := instantiate(job, variables)
+			if err != nil {
+				return fmt.Errorf("line %d: matrix: %s", matrix.Line, err)
+			}
+			if name != nil && !strings.Contains(name.Value, "{{") {
+				mappingValue(instance, "name").Value = name.Value + "-" + strings.Join(values, "-")
+			}
+			expanded = append(expanded, instance)
+		}
+	}
+	jobs.Content = expanded
+	return nil
+}
+
+// matrixCombinations returns every combination of a matrix's values, in
+// the order they are listed, as variable and value pairs
+func matrixCombinations(matrix *yaml.Node) ([][][2]string, error) {
+	if matrix.Kind != yaml.MappingNode || len(matrix.Content) == 0 {
+		return nil, fmt.Errorf("line %d: matrix must map variables to lists of values", matrix.Line)
+	}
+	combinations := [][][2]string{nil}
+	for i := 0; i+1 < len(matrix.Content); i += 2 {
+		variable, values := matrix.Content[i], matrix.Content[i+1]
+		if values.Kind != yaml.SequenceNode || len(values.Content) == 0 {
+			return nil, fmt.Errorf("line %d: matrix variable %s must list its values", values.Line, variable.Value)
+		}
+		var next [][][2]string
+		for _, combination := range combinations {
+			for _, value := range values.Content {
+				if value.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("line %d: matrix values must be scalars", value.Line)
+				}
+				next = append(next, append(slices.Clone(combination), [2]string{variable.Value, value.Value}))
+			}
+		}
+		combinations = next
+	}
+	return combinations, nil
+}
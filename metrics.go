@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the histogram upper bounds, in seconds, for run and
+// upload durations; backups range from seconds to hours
+var durationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200}
+
+type histogram struct {
+	// counts holds the observations per bucket, the last one being +Inf
+	counts []uint64
+	sum    float64
+	total  uint64
+}
+
+func newHistogram() histogram {
+	return histogram{counts: make([]uint64, len(durationBuckets)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	i := sort.SearchFloat64s(durationBuckets, value)
+	h.counts[i]++
+	h.sum += value
+	h.total++
+}
+
+// jobMetrics is what is known about the runs of one job
+type jobMetrics struct {
+	lastRun        time.Time
+	lastSuccess    time.Time
+	lastSucceeded  bool
+	size           int64
+	runs           uint64
+	failures       uint64
+	runDuration    histogram
+	uploadDuration histogram
+}
+
+// runMetrics keeps per-job metrics about backup runs in memory and renders
+// them in the Prometheus text exposition format
+type runMetrics struct {
+	mu   sync.Mutex
+	jobs map[string]*jobMetrics
+}
+
+var metrics = &runMetrics{jobs: map[string]*jobMetrics{}}
+
+// job returns the metrics of the named job; the caller holds mu
+func (m *runMetrics) job(name string) *jobMetrics {
+	job, ok := m.jobs[name]
+	if !ok {
+		job = &jobMetrics{runDuration: newHistogram(), uploadDuration: newHistogram()}
+		m.jobs[name] = job
+	}
+	return job
+}
+
+// register makes a scheduled job show up before its first run
+func (m *runMetrics) register(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.job(name)
+}
+
+// record adds a finished run
+func (m *runMetrics) record(report *RunReport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.job(report.Task)
+	job.lastRun = report.Started
+	job.lastSucceeded = report.Succeeded
+	job.runs++
+	job.runDuration.observe(report.Duration.Seconds())
+	if report.Succeeded {
+		job.lastSuccess = report.Started.Add(report.Duration)
+		job.size = report.Size
+		job.uploadDuration.observe(report.UploadDuration.Seconds())
+	} else {
+		job.failures++
+	}
+}
+
+// write renders every metric in the text exposition format
+func (m *runMetrics) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.jobs))
+	for name := range m.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return m.render(w, names)
+}
+
+// render writes the metrics of the named jobs; the caller holds mu
+func (m *runMetrics) render(w io.Writer, names []string) error {
+	out := bufio.NewWriter(w)
+	metric := func(name, help, kind string, value func(*jobMetrics) float64) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, job := range names {
+			fmt.Fprintf(out, "%s{job=%s} %g\n", name, labelValue(job), value(m.jobs[job]))
+		}
+	}
+	timestamp := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.UnixMilli()) / 1000
+	}
+	histogramMetric := func(name, help string, value func(*jobMetrics) histogram) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		for _, job := range names {
+			h := value(m.jobs[job])
+			var cumulative uint64
+			for i, bound := range durationBuckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(out, "%s_bucket{job=%s,le=\"%g\"} %d\n", name, labelValue(job), bound, cumulative)
+			}
+			fmt.Fprintf(out, "%s_bucket{job=%s,le=\"+Inf\"} %d\n", name, labelValue(job), h.total)
+			fmt.Fprintf(out, "%s_sum{job=%s} %g\n", name, labelValue(job), h.sum)
+			fmt.Fprintf(out, "%s_count{job=%s} %d\n", name, labelValue(job), h.total)
+		}
+	}
+
+	metric("backup_last_run_timestamp_seconds", "Start time of the last run.", "gauge",
+		func(job *jobMetrics) float64 { return timestamp(job.lastRun) })
+	metric("backup_last_success_timestamp_seconds", "End time of the last successful run.", "gauge",
+		func(job *jobMetrics) float64 { return timestamp(job.lastSuccess) })
+	metric("backup_last_run_success", "Whether the last run succeeded.", "gauge",
+		func(job *jobMetrics) float64 {
+			if job.lastSucceeded {
+				return 1
+			}
+			return 0
+		})
+	metric("backup_artifact_size_bytes", "Size of the artifacts uploaded by the last successful run.", "gauge",
+		func(job *jobMetrics) float64 { return float64(job.size) })
+	metric("backup_runs_total", "Number of finished runs.", "counter",
+		func(job *jobMetrics) float64 { return float64(job.runs) })
+	metric("backup_failures_total", "Number of failed runs.", "counter",
+		func(job *jobMetrics) float64 { return float64(job.failures) })
+	histogramMetric("backup_run_duration_seconds", "Duration of runs, from the script to the last upload.",
+		func(job *jobMetrics) histogram { return job.runDuration })
+	histogramMetric("backup_upload_duration_seconds", "Time successful runs spent uploading.",
+		func(job *jobMetrics) histogram { return job.uploadDuration })
+	return out.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelValue(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+// pushgatewayURL is the Pushgateway that metrics are pushed to after every
+// run, or empty
+var pushgatewayURL string
+
+// push replaces the job's group on the Pushgateway with its current
+// metrics, so hosts that can't be scraped still report them
+func (m *runMetrics) push(ctx context.Context, gateway, job string) error {
+	var body bytes.Buffer
+	m.mu.Lock()
+	_, ok := m.jobs[job]
+	if ok {
+		m.render(&body, []string{job})
+	}
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	endpoint := strings.TrimRight(gateway, "/") + "/metrics/job/" + url.PathEscape(job)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &httpStatusError{status: resp.Status, code: resp.StatusCode}
+	}
+	return nil
+}
+
+// textfileDirectory is the node_exporter textfile collector directory
+// that metrics are written to after every run, or empty
+var textfileDirectory string
+
+// writeTextfile replaces poc-gocron.prom in the directory with the
+// current metrics
+func (m *runMetrics) writeTextfile(dir string) error {
+	var body bytes.Buffer
+	if err := m.write(&body); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, "poc-gocron.prom"), body.Bytes())
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RunReport describes the outcome of a single run of a backup task
+type RunReport struct {
+	Task      string
+	BackupID  string
+	Started   time.Time
+	Duration  time.Duration
+	Succeeded bool
+	Error     string
+	// Objects are the object names the run uploaded
+	Objects []string
+	// URLs locate the uploaded objects in the destinations that can tell
+	URLs []string
+	// DownloadURLs are presigned links to the uploaded objects
+	DownloadURLs []string
+	// Warnings describe anything suspicious about a successful run
+	Warnings []string
+	// Size is the total size of the uploaded artifacts in bytes
+	Size int64
+	// UploadDuration is the time spent uploading; streamed runs upload
+	// while their last command runs
+	UploadDuration time.Duration
+	// Attempts is how many times the run was tried, retries included
+	Attempts int
+	// FailureStreak is, for a failed run, how many runs of the task failed
+	// or were missed in a row up to it and, for a successful one, how many
+	// did before it
+	FailureStreak int
+
+	trace *runTrace
+}
+
+// resetAttempt forgets what a failed attempt recorded before a retry
+func (report *RunReport) resetAttempt() {
+	report.Objects, report.URLs, report.DownloadURLs, report.Warnings = nil, nil, nil, nil
+	report.Size, report.UploadDuration = 0, 0
+}
+
+func (report *RunReport) finish(err error) {
+	report.Duration = time.Since(report.Started)
+	report.Succeeded = err == nil
+	if err != nil {
+		report.Error = err.Error()
+	}
+}
+
+// summary renders the report as a few lines of plain text
+func (report *RunReport) summary() string {
+	var b strings.Builder
+	switch {
+	case report.Succeeded && report.FailureStreak > 0:
+		fmt.Fprintf(&b, "Backup %s succeeded after %d failed runs\n", report.Task, report.FailureStreak)
+	case report.Succeeded:
+		fmt.Fprintf(&b, "Backup %s succeeded\n", report.Task)
+	case report.FailureStreak > 1:
+		fmt.Fprintf(&b, "Backup %s failed %d times in a row: %s\n", report.Task, report.FailureStreak, report.Error)
+	default:
+		fmt.Fprintf(&b, "Backup %s failed: %s\n", report.Task, report.Error)
+	}
+	fmt.Fprintf(&b, "Backup ID: %s\n", report.BackupID)
+	fmt.Fprintf(&b, "Duration: %s\n", report.Duration.Round(time.Second))
+	if report.Attempts > 1 {
+		fmt.Fprintf(&b, "Attempts: %d\n", report.Attempts)
+	}
+	if report.Size > 0 {
+		fmt.Fprintf(&b, "Size: %s\n", ByteSize(report.Size))
+	}
+	for _, object := range report.Objects {
+		fmt.Fprintf(&b, "Object: %s\n", object)
+	}
+	for _, warning := range report.Warnings {
+		fmt.Fprintf(&b, "Warning: %s\n", warning)
+	}
+	for _, link := range report.DownloadURLs {
+		fmt.Fprintf(&b, "Download: %s\n", link)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// EventType is a point in a run that notifiers are told about
+type EventType string
+
+const (
+	EventStarted   EventType = "started"
+	EventUploaded  EventType = "uploaded"
+	EventSucceeded EventType = "succeeded"
+	EventFailed    EventType = "failed"
+	// EventMissed is sent when a task didn't succeed within its schedule
+	// and grace period, without a run to report on
+	EventMissed EventType = "missed"
+)
+
+// Event is passed to notifiers. Report is still being filled in for
+// started and uploaded events
+type Event struct {
+	Type   EventType
+	Time   time.Time
+	Report *RunReport
+	// Destination, Object, URL and DownloadURL are set for uploaded events
+	Destination string
+	Object      string
+	URL         string
+	DownloadURL string
+}
+
+func (event Event) finished() bool {
+	return event.Type == EventSucceeded || event.Type == EventFailed
+}
+
+// summary renders the event as a title line followed by details
+func (event Event) summary() string {
+	switch event.Type {
+	case EventStarted:
+		return fmt.Sprintf("Backup %s started\nBackup ID: %s", event.Report.Task, event.Report.BackupID)
+	case EventUploaded:
+		summary := fmt.Sprintf("Backup %s uploaded to %s\nBackup ID: %s\nObject: %s",
+			event.Report.Task, event.Destination, event.Report.BackupID, event.Object)
+		if event.DownloadURL != "" {
+			summary += "\nDownload: " + event.DownloadURL
+		}
+		return summary
+	case EventMissed:
+		return fmt.Sprintf("Backup %s missed its schedule\n%s", event.Report.Task, event.Report.Error)
+	default:
+		return event.Report.summary()
+	}
+}
+
+// Notifier is told about the events of every run of the tasks it is
+// configured for. Implementations pick the events they care about and must
+// return once ctx is done
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// notifierTypes maps the keys of a notifications block to constructors of
+// the notifier they configure; the returned value is decoded from YAML
+var notifierTypes = map[string]func() Notifier{}
+
+// registerNotifier makes a notification channel available in the
+// configuration file under name
+func registerNotifier(name string, newNotifier func() Notifier) {
+	notifierTypes[name] = newNotifier
+}
+
+func init() {
+	registerNotifier("slack", func() Notifier { return &SlackConfig{} })
+	registerNotifier("email", func() Notifier { return &EmailConfig{} })
+	registerNotifier("webhook", func() Notifier { return &WebhookConfig{} })
+	registerNotifier("ntfy", func() Notifier { return &NtfyConfig{} })
+	registerNotifier("telegram", func() Notifier { return &TelegramConfig{} })
+	registerNotifier("discord", func() Notifier { return &DiscordConfig{} })
+	registerNotifier("pagerduty", func() Notifier { return &PagerDutyConfig{} })
+	registerNotifier("opsgenie", func() Notifier { return &OpsgenieConfig{} })
+}
+
+type namedNotifier struct {
+	name string
+	Notifier
+}
+
+// NotificationConfig holds the notification channels of a task. Channels
+// of a job are combined with the top-level ones, replacing those of the
+// same name, unless the job sets "inherit: false"
+type NotificationConfig struct {
+	channels  []namedNotifier
+	noInherit bool
+}
+
+func (cfg *NotificationConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: notifications must be a mapping", value.Line)
+	}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key, node := value.Content[i], value.Content[i+1]
+		if key.Value == "inherit" {
+			var inherit bool
+			if err := node.Decode(&inherit); err != nil {
+				return err
+			}
+			cfg.noInherit = !inherit
+			continue
+		}
+		newNotifier, ok := notifierTypes[key.Value]
+		if !ok {
+			return fmt.Errorf("line %d: unknown notification channel %q", key.Line, key.Value)
+		}
+		notifier := newNotifier()
+		if err := node.Decode(notifier); err != nil {
+			return err
+		}
+		cfg.channels = append(cfg.channels, namedNotifier{name: key.Value, Notifier: notifier})
+	}
+	return nil
+}
+
+func (cfg NotificationConfig) validate() error {
+	for _, channel := range cfg.channels {
+		if validator, ok := channel.Notifier.(interface{ validate() error }); ok {
+			if err := validator.validate(); err != nil {
+				return fmt.Errorf("%s notifications: %s", channel.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// channel returns the notifier configured under name, or nil
+func (cfg *NotificationConfig) channel(name string) Notifier {
+	if cfg == nil {
+		return nil
+	}
+	for _, channel := range cfg.channels {
+		if channel.name == name {
+			return channel.Notifier
+		}
+	}
+	return nil
+}
+
+// merge combines the top-level channels with a job's own
+func (cfg *NotificationConfig) merge(job *NotificationConfig) *NotificationConfig {
+	if job == nil {
+		return cfg
+	}
+	if cfg == nil || job.noInherit {
+		return job
+	}
+	merged := &NotificationConfig{}
+	for _, channel := range cfg.channels {
+		if job.channel(channel.name) == nil {
+			merged.channels = append(merged.channels, channel)
+		}
+	}
+	merged.channels = append(merged.channels, job.channels...)
+	return merged
+}
+
+// eventFilter selects the events a channel reports. On is "failure" (the
+// default) for failed and missed runs and successful runs with warnings,
+// or "always" to add every successful one; Events lists event types
+// explicitly instead
+type eventFilter struct {
+	On     string      `yaml:"on"`
+	Events []EventType `yaml:"events"`
+	// After is how many runs of a task must fail in a row before the
+	// channel reports it, e.g. to page only on persistent breakage
+	After int `yaml:"after"`
+	// SuppressRepeats reports a streak of failures only once, and the run
+	// that ends it
+	SuppressRepeats bool `yaml:"suppress_repeats"`
+}
+
+func (filter eventFilter) validate() error {
+	if filter.On != "" && filter.On != "failure" && filter.On != "always" {
+		return fmt.Errorf("unknown on %q, expected failure or always", filter.On)
+	}
+	for _, event := range filter.Events {
+		switch event {
+		case EventStarted, EventUploaded, EventSucceeded, EventFailed, EventMissed:
+		default:
+			return fmt.Errorf("unknown event %q, expected started, uploaded, succeeded, failed or missed", event)
+		}
+	}
+	return validateAfter(filter.After)
+}
+
+func (filter eventFilter) wants(event Event) bool {
+	if event.Type == EventFailed && !filter.escalates(event) {
+		return false
+	}
+	if filter.recovered(event) {
+		return true
+	}
+	if len(filter.Events) > 0 {
+		for _, wanted := range filter.Events {
+			if wanted == event.Type {
+				return true
+			}
+		}
+		return false
+	}
+	if event.Type == EventSucceeded {
+		return filter.On == "always" || len(event.Report.Warnings) > 0
+	}
+	return event.Type == EventFailed || event.Type == EventMissed
+}
+
+// notifiers returns every notifier the task reports to
+func (task BackupTask) notifiers() []namedNotifier {
+	var notifiers []namedNotifier
+	if task.Notifications != nil {
+		notifiers = append(notifiers, task.Notifications.channels...)
+	}
+	if task.Ping != nil {
+		notifiers = append(notifiers, namedNotifier{name: "ping", Notifier: task.Ping})
+	}
+	return notifiers
+}
+
+// emit hands the event to the task's notifiers. Failures are logged and
+// never affect the run itself
+func (task BackupTask) emit(event Event, logger *slog.Logger) {
+	event.Time = time.Now()
+	if event.finished() {
+		dailySummary.record(event.Report)
+	}
+	notifiers := task.notifiers()
+	if len(notifiers) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(ctx, event); err != nil {
+			logger.Error("Failed to send notification", slog.String("channel", notifier.name),
+				slog.String("event", string(event.Type)), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// emitUploaded reports an object that reached some destinations, along
+// with a presigned download link when the task asks for one
+func (task BackupTask) emitUploaded(report *RunReport, destinations []Destination, objectName string, logger *slog.Logger) {
+	for _, destination := range destinations {
+		event := Event{Type: EventUploaded, Report: report, Destination: destination.Name, Object: objectName}
+		if locator, ok := storageAs[Locator](destination.Storage); ok {
+			event.URL = locator.Location(objectName)
+		}
+		if presigner, ok := storageAs[Presigner](destination.Storage); ok && task.Presign > 0 {
+			link, err := presigner.PresignGet(context.Background(), objectName, time.Duration(task.Presign))
+			if err != nil {
+				logger.Warn("Failed to presign the download URL", slog.String("destination", destination.Name), slog.String("error", err.Error()))
+			} else {
+				logger.Info("Generated a download URL", slog.String("destination", destination.Name),
+					slog.String("url", link), slog.Duration("expires_in", time.Duration(task.Presign)))
+				event.DownloadURL = link
+				report.DownloadURLs = append(report.DownloadURLs, link)
+			}
+		}
+		task.emit(event, logger)
+	}
+}
+
+func (task BackupTask) validatePresign() error {
+	if task.Presign < 0 || time.Duration(task.Presign) > maxPresignExpiry {
+		return fmt.Errorf("presign must be between 1s and 7d, got %s", task.Presign)
+	}
+	if task.Presign > 0 && task.Split != nil {
+		return fmt.Errorf("presign can't be combined with split, the parts have no single download URL")
+	}
+	return nil
+}
+
+// SlackConfig posts run reports to a Slack incoming webhook
+type SlackConfig struct {
+	WebhookURL  string `yaml:"webhook_url"`
+	eventFilter `yaml:",inline"`
+}
+
+func (cfg SlackConfig) validate() error {
+	if cfg.WebhookURL == "" {
+		return fmt.Errorf("a webhook_url is required")
+	}
+	return cfg.eventFilter.validate()
+}
+
+func (cfg SlackConfig) Notify(ctx context.Context, event Event) error {
+	if !cfg.wants(event) {
+		return nil
+	}
+	icon := ":white_check_mark:"
+	switch event.Type {
+	case EventFailed:
+		icon = ":x:"
+	case EventMissed:
+		icon = ":warning:"
+	case EventStarted, EventUploaded:
+		icon = ":arrow_forward:"
+	}
+	lines := strings.Split(event.summary(), "\n")
+	lines[0] = icon + " *" + lines[0] + "*"
+	for i := 1; i < len(lines); i++ {
+		name, value, _ := strings.Cut(lines[i], ": ")
+		if strings.HasPrefix(value, "https://") {
+			lines[i] = fmt.Sprintf("%s: <%s|link>", name, value)
+			continue
+		}
+		lines[i] = fmt.Sprintf("%s: `%s`", name, value)
+	}
+	return postJSON(ctx, cfg.WebhookURL, map[string]string{"text": strings.Join(lines, "\n")}, nil)
+}
+
+var notifyClient = &http.Client{Timeout: 15 * time.Second}
+
+// postJSON sends the payload and fails on any non-2xx response
+func postJSON(ctx context.Context, url string, payload any, headers map[string]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return postBody(ctx, url, body, headers)
+}
+
+func postBody(ctx context.Context, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &httpStatusError{status: resp.Status, code: resp.StatusCode, message: strings.TrimSpace(string(message))}
+	}
+	return nil
+}
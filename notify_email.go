@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EmailConfig sends run reports through an SMTP server
+type EmailConfig struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// TLS is "starttls" (the default), "tls" for implicit TLS, usually on
+	// port 465, or "none"
+	TLS         string `yaml:"tls"`
+	eventFilter `yaml:",inline"`
+	// DailySummary is a time of day ("08:00") at which a summary of every
+	// run since the last one is sent; only read from the top level
+	DailySummary string `yaml:"daily_summary"`
+}
+
+func (cfg EmailConfig) validate() error {
+	if cfg.Host == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return fmt.Errorf("email notifications need a host, from and to")
+	}
+	if cfg.TLS != "" && cfg.TLS != "starttls" && cfg.TLS != "tls" && cfg.TLS != "none" {
+		return fmt.Errorf("unknown email tls mode %q, expected starttls, tls or none", cfg.TLS)
+	}
+	if cfg.DailySummary != "" {
+		if _, err := time.Parse("15:04", cfg.DailySummary); err != nil {
+			return fmt.Errorf("email daily_summary must be a time like 08:00")
+		}
+	}
+	return cfg.eventFilter.validate()
+}
+
+func (cfg EmailConfig) address() string {
+	port := cfg.Port
+	if port == 0 {
+		port = 587
+		if cfg.TLS == "tls" {
+			port = 465
+		}
+	}
+	return net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+}
+
+func (cfg EmailConfig) Notify(ctx context.Context, event Event) error {
+	if !cfg.wants(event) {
+		return nil
+	}
+	status := string(event.Type)
+	switch event.Type {
+	case EventFailed:
+		status = "FAILED"
+	case EventMissed:
+		status = "MISSED"
+	}
+	return cfg.send(ctx, fmt.Sprintf("Backup %s %s", event.Report.Task, status), event.summary())
+}
+
+// send delivers a plain text message to every recipient
+func (cfg EmailConfig) send(ctx context.Context, subject, body string) error {
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	message.WriteString("\r\n")
+
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	var conn net.Conn
+	var err error
+	if cfg.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", cfg.address(), &tls.Config{ServerName: cfg.Host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", cfg.address())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %s", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if cfg.TLS == "" || cfg.TLS == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server does not support STARTTLS")
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %s", err)
+		}
+	}
+	if err := client.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, recipient := range cfg.To {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message.Bytes()); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// runHistory collects run reports for the daily summary
+type runHistory struct {
+	mu      sync.Mutex
+	reports []*RunReport
+}
+
+// dailySummary is nil unless a daily summary email is configured
+var dailySummary *runHistory
+
+func (h *runHistory) record(report *RunReport) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reports = append(h.reports, report)
+}
+
+// sendSummary emails every run recorded since the previous summary
+func (h *runHistory) sendSummary(cfg EmailConfig) {
+	h.mu.Lock()
+	reports := h.reports
+	h.reports = nil
+	h.mu.Unlock()
+
+	failed := 0
+	var body strings.Builder
+	for _, report := range reports {
+		if !report.Succeeded {
+			failed++
+		}
+		body.WriteString(report.summary() + "\n\n")
+	}
+	if len(reports) == 0 {
+		body.WriteString("No backups ran since the last summary.\n")
+	}
+	subject := fmt.Sprintf("Backup summary: %d runs, %d failed", len(reports), failed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := cfg.send(ctx, subject, strings.TrimSpace(body.String())); err != nil {
+		slog.Error("Failed to send daily summary email", slog.String("error", err.Error()))
+	}
+}
+
+// summarySchedule turns the daily summary time into a cron expression
+func (cfg EmailConfig) summarySchedule() string {
+	at, _ := time.Parse("15:04", cfg.DailySummary)
+	return fmt.Sprintf("%d %d * * *", at.Minute(), at.Hour())
+}
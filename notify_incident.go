@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// PagerDutyConfig opens a PagerDuty incident through the Events API v2
+// once a task failed After times in a row, and resolves it on the next
+// successful run. A missed schedule counts as a failure
+type PagerDutyConfig struct {
+	RoutingKey string `yaml:"routing_key"`
+	After      int    `yaml:"after"`
+	// Severity is critical, error (the default), warning or info
+	Severity string `yaml:"severity"`
+}
+
+// OpsgenieConfig does the same as PagerDutyConfig with Opsgenie alerts
+type OpsgenieConfig struct {
+	APIKey string `yaml:"api_key"`
+	// APIURL defaults to https://api.opsgenie.com, EU accounts use
+	// https://api.eu.opsgenie.com
+	APIURL string `yaml:"api_url"`
+	After  int    `yaml:"after"`
+	// Priority is P1 to P5, P3 by default
+	Priority string `yaml:"priority"`
+}
+
+func (cfg PagerDutyConfig) validate() error {
+	if cfg.RoutingKey == "" {
+		return fmt.Errorf("pagerduty notifications need a routing_key")
+	}
+	switch cfg.Severity {
+	case "", "critical", "error", "warning", "info":
+	default:
+		return fmt.Errorf("unknown pagerduty severity %q", cfg.Severity)
+	}
+	return validateAfter(cfg.After)
+}
+
+func (cfg OpsgenieConfig) validate() error {
+	if cfg.APIKey == "" {
+		return fmt.Errorf("opsgenie notifications need an api_key")
+	}
+	switch cfg.Priority {
+	case "", "P1", "P2", "P3", "P4", "P5":
+	default:
+		return fmt.Errorf("unknown opsgenie priority %q", cfg.Priority)
+	}
+	return validateAfter(cfg.After)
+}
+
+func validateAfter(after int) error {
+	if after < 0 {
+		return fmt.Errorf("after must not be negative")
+	}
+	return nil
+}
+
+// incidentState tracks a task's consecutive failures, missed schedules
+// included, and the channels it has an open incident on. It lives in
+// memory, so a restart forgets open incidents until the task fails or
+// succeeds again
+type incidentState struct {
+	failures int
+	open     map[string]bool
+}
+
+var (
+	incidentsMu sync.Mutex
+	incidents   = map[string]*incidentState{}
+)
+
+// incidentOf returns the task's incident state; incidentsMu must be held
+func incidentOf(task string) *incidentState {
+	state, ok := incidents[task]
+	if !ok {
+		state = &incidentState{open: map[string]bool{}}
+		incidents[task] = state
+	}
+	return state
+}
+
+// recordFailureStreak counts the failed or missed run towards the task's
+// streak and stores it in the report's FailureStreak. A successful run
+// gets the streak it ends
+func recordFailureStreak(report *RunReport) {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	state := incidentOf(report.Task)
+	if report.Succeeded {
+		report.FailureStreak = state.failures
+		state.failures = 0
+		return
+	}
+	state.failures++
+	report.FailureStreak = state.failures
+}
+
+// escalate triggers or resolves the task's incident on one channel as the
+// report and its failure streak require
+func escalate(channel string, after int, report *RunReport, trigger, resolve func() error) error {
+	if after == 0 {
+		after = 1
+	}
+
+	incidentsMu.Lock()
+	state := incidentOf(report.Task)
+	var action func() error
+	var opened bool
+	if report.Succeeded {
+		if state.open[channel] {
+			action = resolve
+		}
+	} else if report.FailureStreak >= after && !state.open[channel] {
+		action, opened = trigger, true
+	}
+	incidentsMu.Unlock()
+
+	if action == nil {
+		return nil
+	}
+	if err := action(); err != nil {
+		return err
+	}
+	incidentsMu.Lock()
+	state.open[channel] = opened
+	incidentsMu.Unlock()
+	return nil
+}
+
+func incidentKey(task string) string {
+	return "backup-" + task
+}
+
+func (cfg PagerDutyConfig) Notify(ctx context.Context, e Event) error {
+	if !e.finished() && e.Type != EventMissed {
+		return nil
+	}
+	report := e.Report
+	event := func(action string) func() error {
+		return func() error {
+			body := map[string]any{
+				"routing_key":  cfg.RoutingKey,
+				"event_action": action,
+				"dedup_key":    incidentKey(report.Task),
+			}
+			if action == "trigger" {
+				severity := cfg.Severity
+				if severity == "" {
+					severity = "error"
+				}
+				hostname, _ := os.Hostname()
+				body["payload"] = map[string]any{
+					"summary":  fmt.Sprintf("Backup %s failed: %s", report.Task, report.Error),
+					"source":   hostname,
+					"severity": severity,
+					"custom_details": map[string]any{
+						"backup_id": report.BackupID,
+						"duration":  report.Duration.String(),
+						"error":     report.Error,
+					},
+				}
+			}
+			return postJSON(ctx, "https://events.pagerduty.com/v2/enqueue", body, nil)
+		}
+	}
+	return escalate("pagerduty", cfg.After, report, event("trigger"), event("resolve"))
+}
+
+func (cfg OpsgenieConfig) Notify(ctx context.Context, event Event) error {
+	if !event.finished() && event.Type != EventMissed {
+		return nil
+	}
+	report := event.Report
+	apiURL := strings.TrimRight(cfg.APIURL, "/")
+	if apiURL == "" {
+		apiURL = "https://api.opsgenie.com"
+	}
+	headers := map[string]string{"Authorization": "GenieKey " + cfg.APIKey}
+	alias := incidentKey(report.Task)
+
+	trigger := func() error {
+		priority := cfg.Priority
+		if priority == "" {
+			priority = "P3"
+		}
+		return postJSON(ctx, apiURL+"/v2/alerts", map[string]any{
+			"message":     fmt.Sprintf("Backup %s failed", report.Task),
+			"alias":       alias,
+			"description": report.summary(),
+			"priority":    priority,
+		}, headers)
+	}
+	resolve := func() error {
+		return postJSON(ctx, apiURL+"/v2/alerts/"+url.PathEscape(alias)+"/close?identifierType=alias",
+			map[string]any{"note": fmt.Sprintf("Backup %s succeeded again", report.Task)}, headers)
+	}
+	return escalate("opsgenie", cfg.After, report, trigger, resolve)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// PingConfig reports run starts and outcomes to a dead-man monitor such as
+// healthchecks.io or Cronitor, which alerts when the pings stop coming.
+// The start, success and failure URLs are derived from URL unless given
+type PingConfig struct {
+	URL     string `yaml:"url"`
+	Start   string `yaml:"start_url"`
+	Success string `yaml:"success_url"`
+	Failure string `yaml:"failure_url"`
+}
+
+func (cfg PingConfig) validate() error {
+	if cfg.URL == "" && (cfg.Start == "" || cfg.Success == "" || cfg.Failure == "") {
+		return fmt.Errorf("ping needs a url, or all of start_url, success_url and failure_url")
+	}
+	for _, raw := range []string{cfg.URL, cfg.Start, cfg.Success, cfg.Failure} {
+		if raw == "" {
+			continue
+		}
+		if parsed, err := url.Parse(raw); err != nil || parsed.Host == "" {
+			return fmt.Errorf("invalid ping url %q", raw)
+		}
+	}
+	return nil
+}
+
+// endpoint returns the URL for the started, succeeded or failed event. Cronitor
+// telemetry URLs take the state as a query parameter, anything else is
+// treated like healthchecks.io, which uses /start and /fail suffixes
+func (cfg PingConfig) endpoint(event string) string {
+	explicit := map[string]string{"started": cfg.Start, "succeeded": cfg.Success, "failed": cfg.Failure}[event]
+	if explicit != "" {
+		return explicit
+	}
+
+	if strings.Contains(cfg.URL, "cronitor.link") {
+		state := map[string]string{"started": "run", "succeeded": "complete", "failed": "fail"}[event]
+		parsed, err := url.Parse(cfg.URL)
+		if err != nil {
+			return cfg.URL
+		}
+		query := parsed.Query()
+		query.Set("state", state)
+		parsed.RawQuery = query.Encode()
+		return parsed.String()
+	}
+
+	base := strings.TrimRight(cfg.URL, "/")
+	switch event {
+	case "started":
+		return base + "/start"
+	case "failed":
+		return base + "/fail"
+	default:
+		return base
+	}
+}
+
+func (cfg PingConfig) Notify(ctx context.Context, event Event) error {
+	message := ""
+	switch event.Type {
+	case EventStarted:
+	case EventSucceeded, EventFailed:
+		message = event.summary()
+	default:
+		return nil
+	}
+	return cfg.ping(ctx, string(event.Type), message)
+}
+
+// ping sends the event, with an optional plain text body that monitors
+// show alongside it
+func (cfg PingConfig) ping(ctx context.Context, event, message string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.endpoint(event), bytes.NewReader([]byte(message)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &httpStatusError{status: resp.Status, code: resp.StatusCode}
+	}
+	return nil
+}
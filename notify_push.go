@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// NtfyConfig publishes run reports to an ntfy topic
+type NtfyConfig struct {
+	// Server defaults to https://ntfy.sh
+	Server string `yaml:"server"`
+	Topic  string `yaml:"topic"`
+	// Token is an access token for protected topics
+	Token string `yaml:"token"`
+	// Priority is 1 (min) to 5 (max); failures are sent with at least 4
+	Priority    int `yaml:"priority"`
+	eventFilter `yaml:",inline"`
+}
+
+func (cfg NtfyConfig) validate() error {
+	if cfg.Topic == "" {
+		return fmt.Errorf("ntfy notifications need a topic")
+	}
+	if cfg.Priority < 0 || cfg.Priority > 5 {
+		return fmt.Errorf("ntfy priority must be between 1 and 5")
+	}
+	return cfg.eventFilter.validate()
+}
+
+func (cfg NtfyConfig) Notify(ctx context.Context, event Event) error {
+	if !cfg.wants(event) {
+		return nil
+	}
+	server := strings.TrimRight(cfg.Server, "/")
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	title, body, _ := strings.Cut(event.summary(), "\n")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server+"/"+url.PathEscape(cfg.Topic), bytes.NewReader([]byte(body)))
+	if err != nil {
+		return err
+	}
+	priority := cfg.Priority
+	tag := "white_check_mark"
+	switch event.Type {
+	case EventFailed, EventMissed:
+		priority, tag = max(priority, 4), "rotating_light"
+	case EventStarted, EventUploaded:
+		tag = "arrow_forward"
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Tags", tag)
+	if priority > 0 {
+		req.Header.Set("Priority", fmt.Sprint(priority))
+	}
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &httpStatusError{status: resp.Status, code: resp.StatusCode}
+	}
+	return nil
+}
+
+// TelegramConfig sends run reports to a chat through a Telegram bot
+type TelegramConfig struct {
+	BotToken    string `yaml:"bot_token"`
+	ChatID      string `yaml:"chat_id"`
+	eventFilter `yaml:",inline"`
+}
+
+func (cfg TelegramConfig) validate() error {
+	if cfg.BotToken == "" || cfg.ChatID == "" {
+		return fmt.Errorf("telegram notifications need a bot_token and chat_id")
+	}
+	return cfg.eventFilter.validate()
+}
+
+func (cfg TelegramConfig) Notify(ctx context.Context, event Event) error {
+	if !cfg.wants(event) {
+		return nil
+	}
+	return postJSON(ctx, "https://api.telegram.org/bot"+cfg.BotToken+"/sendMessage", map[string]any{
+		"chat_id":                  cfg.ChatID,
+		"text":                     event.summary(),
+		"disable_web_page_preview": true,
+	}, nil)
+}
+
+// DiscordConfig posts run reports to a Discord channel webhook
+type DiscordConfig struct {
+	WebhookURL  string `yaml:"webhook_url"`
+	eventFilter `yaml:",inline"`
+}
+
+func (cfg DiscordConfig) validate() error {
+	if cfg.WebhookURL == "" {
+		return fmt.Errorf("discord notifications need a webhook_url")
+	}
+	return cfg.eventFilter.validate()
+}
+
+func (cfg DiscordConfig) Notify(ctx context.Context, event Event) error {
+	if !cfg.wants(event) {
+		return nil
+	}
+	icon := ":white_check_mark:"
+	switch event.Type {
+	case EventFailed:
+		icon = ":x:"
+	case EventMissed:
+		icon = ":warning:"
+	case EventStarted, EventUploaded:
+		icon = ":arrow_forward:"
+	}
+	title, body, _ := strings.Cut(event.summary(), "\n")
+	return postJSON(ctx, cfg.WebhookURL, map[string]any{
+		"content": fmt.Sprintf("%s **%s**\n```\n%s\n```", icon, title, body),
+	}, nil)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// WebhookConfig POSTs a JSON document about each run to an arbitrary
+// endpoint. The body is rendered from Template, a Go text/template over
+// webhookPayload, and defaults to the payload itself
+type WebhookConfig struct {
+	URL      string            `yaml:"url"`
+	Template string            `yaml:"template"`
+	Headers  map[string]string `yaml:"headers"`
+	// Secret signs the body with HMAC-SHA256, sent as
+	// "X-Signature-256: sha256=<hex>"
+	Secret string `yaml:"secret"`
+	// Retries is the number of extra attempts after a failed delivery
+	Retries     *int `yaml:"retries"`
+	eventFilter `yaml:",inline"`
+}
+
+// webhookPayload is what webhook templates can refer to
+type webhookPayload struct {
+	Event           EventType `json:"event"`
+	Task            string    `json:"task"`
+	BackupID        string    `json:"backup_id"`
+	Status          string    `json:"status"`
+	Started         time.Time `json:"started"`
+	Duration        string    `json:"duration"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Size            int64     `json:"size"`
+	Error           string    `json:"error,omitempty"`
+	Warnings        []string  `json:"warnings,omitempty"`
+	Objects         []string  `json:"objects"`
+	ObjectURL       string    `json:"object_url,omitempty"`
+	ObjectURLs      []string  `json:"object_urls"`
+	DownloadURL     string    `json:"download_url,omitempty"`
+	DownloadURLs    []string  `json:"download_urls,omitempty"`
+	FailureStreak   int       `json:"failure_streak"`
+}
+
+func newWebhookPayload(event Event) webhookPayload {
+	report := event.Report
+	payload := webhookPayload{
+		Event:           event.Type,
+		Task:            report.Task,
+		BackupID:        report.BackupID,
+		Status:          "success",
+		Started:         report.Started,
+		Duration:        report.Duration.Round(time.Millisecond).String(),
+		DurationSeconds: report.Duration.Seconds(),
+		Size:            report.Size,
+		Error:           report.Error,
+		Warnings:        report.Warnings,
+		FailureStreak:   report.FailureStreak,
+		Objects:         report.Objects,
+		ObjectURLs:      report.URLs,
+		DownloadURLs:    report.DownloadURLs,
+	}
+	switch event.Type {
+	case EventFailed, EventMissed:
+		payload.Status = "failure"
+	case EventStarted, EventUploaded:
+		payload.Status = "running"
+	}
+	if event.Type == EventUploaded {
+		payload.Objects, payload.ObjectURLs = []string{event.Object}, nil
+		if event.URL != "" {
+			payload.ObjectURLs = []string{event.URL}
+		}
+		payload.DownloadURLs = nil
+		if event.DownloadURL != "" {
+			payload.DownloadURLs = []string{event.DownloadURL}
+		}
+	}
+	if len(payload.ObjectURLs) > 0 {
+		payload.ObjectURL = payload.ObjectURLs[0]
+	}
+	if len(payload.DownloadURLs) > 0 {
+		payload.DownloadURL = payload.DownloadURLs[0]
+	}
+	return payload
+}
+
+var webhookFuncs = template.FuncMap{
+	// json renders a value as a JSON literal, so strings are quoted and
+	// escaped correctly
+	"json": func(value any) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+func (cfg WebhookConfig) validate() error {
+	if cfg.URL == "" {
+		return fmt.Errorf("webhook notifications need a url")
+	}
+	if cfg.Template != "" {
+		if _, err := template.New("webhook").Funcs(webhookFuncs).Parse(cfg.Template); err != nil {
+			return fmt.Errorf("invalid webhook template: %s", err)
+		}
+	}
+	if cfg.Retries != nil && *cfg.Retries < 0 {
+		return fmt.Errorf("webhook retries must not be negative")
+	}
+	return cfg.eventFilter.validate()
+}
+
+func (cfg WebhookConfig) render(event Event) ([]byte, error) {
+	payload := newWebhookPayload(event)
+	if cfg.Template == "" {
+		return json.Marshal(payload)
+	}
+	tmpl, err := template.New("webhook").Funcs(webhookFuncs).Parse(cfg.Template)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, payload); err != nil {
+		return nil, fmt.Errorf("failed to render webhook template: %s", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, fmt.Errorf("webhook template did not render valid JSON")
+	}
+	return body.Bytes(), nil
+}
+
+func (cfg WebhookConfig) Notify(ctx context.Context, event Event) error {
+	if !cfg.wants(event) {
+		return nil
+	}
+	body, err := cfg.render(event)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{}
+	for key, value := range cfg.Headers {
+		headers[key] = value
+	}
+	if cfg.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(cfg.Secret))
+		mac.Write(body)
+		headers["X-Signature-256"] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	retries := 3
+	if cfg.Retries != nil {
+		retries = *cfg.Retries
+	}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err = postBody(ctx, cfg.URL, body, headers)
+		var status *httpStatusError
+		if err == nil || attempt >= retries || (errors.As(err, &status) && !status.retryable()) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// httpStatusError is returned for non-2xx responses
+type httpStatusError struct {
+	status  string
+	code    int
+	message string
+}
+
+func (e *httpStatusError) Error() string {
+	return strings.TrimSuffix(e.status+": "+e.message, ": ")
+}
+
+// retryable tells whether sending the same request again may succeed
+func (e *httpStatusError) retryable() bool {
+	return e.code == http.StatusTooManyRequests || e.code >= 500
+}
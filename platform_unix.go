@@ -0,0 +1,93 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// defaultShell runs the scripts of jobs that don't pick a shell
+const defaultShell = "sh"
+
+// pauseSignal pauses or resumes every job
+var pauseSignal os.Signal = syscall.SIGUSR1
+
+// processCredential is the user and groups a script runs as
+type processCredential = syscall.Credential
+
+// configureProcess starts the command in a process group of its own, as
+// the run_as user and in the run's cgroup. Cancelling it kills the whole
+// group, so a hung pg_dump started by a script doesn't outlive the shell
+func configureProcess(cmd *exec.Cmd, process scriptProcess) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: process.credential}
+	if process.cgroup != nil {
+		process.cgroup.apply(cmd.SysProcAttr)
+	}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// handOver gives the run_as user the directory its scripts write to
+func handOver(dir string, credential *processCredential) error {
+	return os.Chown(dir, int(credential.Uid), int(credential.Gid))
+}
+
+// credential looks up the user and group the scripts are started with
+func (r *RunAs) credential() (*processCredential, error) {
+	credential := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	if r.User != "" {
+		account, err := user.Lookup(r.User)
+		if err != nil {
+			if account, err = user.LookupId(r.User); err != nil {
+				return nil, fmt.Errorf("unknown user %s", r.User)
+			}
+		}
+		uid, _ := strconv.ParseUint(account.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(account.Gid, 10, 32)
+		credential.Uid, credential.Gid = uint32(uid), uint32(gid)
+		groups, err := account.GroupIds()
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up the groups of %s: %s", r.User, err)
+		}
+		for _, group := range groups {
+			if id, err := strconv.ParseUint(group, 10, 32); err == nil {
+				credential.Groups = append(credential.Groups, uint32(id))
+			}
+		}
+	}
+	if r.Group != "" {
+		group, err := user.LookupGroup(r.Group)
+		if err != nil {
+			if group, err = user.LookupGroupId(r.Group); err != nil {
+				return nil, fmt.Errorf("unknown group %s", r.Group)
+			}
+		}
+		gid, _ := strconv.ParseUint(group.Gid, 10, 32)
+		credential.Gid = uint32(gid)
+	}
+	return credential, nil
+}
+
+// setCommandLine is only needed for cmd on Windows, arguments are passed
+// as they are elsewhere
+func setCommandLine(cmd *exec.Cmd, line string) {}
+
+func checkNiceSupport() error {
+	return nil
+}
+
+// freeSpace returns how many bytes of the directory's volume are available
+// to unprivileged users
+func freeSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
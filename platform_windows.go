@@ -0,0 +1,68 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// defaultShell runs the scripts of jobs that don't pick a shell
+const defaultShell = "cmd"
+
+// pauseSignal pauses or resumes every job; Windows has none to spare
+var pauseSignal os.Signal
+
+// processCredential is unused, run_as isn't supported on Windows
+type processCredential struct{}
+
+// setCommandLine starts the command with the raw command line instead of
+// its escaped arguments
+func setCommandLine(cmd *exec.Cmd, line string) {
+	cmd.SysProcAttr.CmdLine = line
+}
+
+func checkNiceSupport() error {
+	return fmt.Errorf("nice and ionice are not available on Windows")
+}
+
+// configureProcess starts the command in a process group of its own.
+// Cancelling it kills the command's whole process tree with taskkill, as
+// Windows can't signal a group
+func configureProcess(cmd *exec.Cmd, process scriptProcess) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+	cmd.Cancel = func() error {
+		if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+}
+
+func handOver(dir string, credential *processCredential) error {
+	return nil
+}
+
+func (r *RunAs) credential() (*processCredential, error) {
+	return nil, fmt.Errorf("run_as isn't supported on Windows")
+}
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// freeSpace returns how many bytes of the directory's volume are available
+// to the scheduler's user
+func freeSpace(dir string) (int64, error) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	if ok, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&available)), 0, 0); ok == 0 {
+		return 0, err
+	}
+	return int64(available), nil
+}
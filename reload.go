@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+)
+
+// taskScheduler adds tasks to the scheduler and applies changes to the
+// configuration file to the running scheduler
+type taskScheduler struct {
+	scheduler  gocron.Scheduler
+	storage    Storage
+	configPath string
+
+	// mu serializes reloads
+	mu sync.Mutex
+}
+
+// configDelta names the tasks a reload changed
+type configDelta struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Updated []string `json:"updated"`
+}
+
+// schedule adds a validated task to the scheduler. On start, tasks may run
+// right away because of run_on_start or catch_up; when reloading they wait
+// for their schedule
+func (s *taskScheduler) schedule(task BackupTask, starting bool) error {
+	destinations, err := task.resolveDestinations(s.storage)
+	if err != nil {
+		return fmt.Errorf("failed to initialize task storage: %s", err)
+	}
+	return s.add(task, destinations, starting)
+}
+
+// add schedules a task whose destinations are resolved. The task is kept
+// as configured, for reloads to compare against
+func (s *taskScheduler) add(task BackupTask, destinations []Destination, starting bool) error {
+	configured := task
+	if !starting {
+		task.RunOnStart = false
+	} else if last, ok := state.lastRun(task.Name); ok && task.CatchUp {
+		if missed, _ := task.missedRun(last, time.Now()); missed {
+			slog.Info("Catching up on a run missed while the scheduler was down", slog.String("backup_task", task.Name), slog.Time("last_run", last))
+			task.RunOnStart = true
+		}
+	}
+
+	// tasks without a schedule of their own only run after the tasks
+	// they depend on
+	run := task.Execute(destinations)
+	if len(task.Schedule) == 0 && task.Every == 0 {
+		addDependent(task, run)
+		control.add(configured, run, nil)
+		metrics.register(task.Name)
+		runStatus.track(task.Name, nil)
+		return nil
+	}
+
+	// every schedule gets its own job, all running the same task
+	var jobs []gocron.Job
+	for i, definition := range task.jobDefinitions() {
+		if i > 0 {
+			task.RunOnStart = false
+		}
+		job, err := s.scheduler.NewJob(definition, gocron.NewTask(run), task.jobOptions()...)
+		if err != nil {
+			s.removeJobs(jobs)
+			return fmt.Errorf("failed to schedule backup job: %s", err)
+		}
+		jobs = append(jobs, job)
+	}
+	control.add(configured, run, jobs)
+	metrics.register(task.Name)
+	runStatus.track(task.Name, jobs)
+	health.track(task, jobs)
+	return nil
+}
+
+// unschedule removes a task from the scheduler; a run in progress finishes
+func (s *taskScheduler) unschedule(name string) {
+	s.unscheduleJobs(name)
+	control.remove(name)
+}
+
+// unscheduleJobs removes a task's jobs from the scheduler but keeps its
+// state, such as whether it is paused, for the task rescheduled with a new
+// configuration
+func (s *taskScheduler) unscheduleJobs(name string) {
+	s.removeJobs(control.jobsOf(name))
+	runStatus.untrack(name)
+	health.untrack(name)
+}
+
+func (s *taskScheduler) removeJobs(jobs []gocron.Job) {
+	for _, job := range jobs {
+		if err := s.scheduler.RemoveJob(job.ID()); err != nil {
+			slog.Warn("Failed to remove a scheduled job", slog.String("job", job.Name()), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// reloadLogged reloads the configuration and logs what changed, or why
+// nothing did
+func (s *taskScheduler) reloadLogged(trigger string) (configDelta, error) {
+	delta, err := s.reload()
+	if err != nil {
+		for _, problem := range errorList(err) {
+			slog.Error("Failed to reload the configuration", slog.String("error", problem.Error()), slog.String("trigger", trigger))
+		}
+		return delta, err
+	}
+	slog.Info("Configuration reloaded", slog.String("trigger", trigger), slog.Any("added", delta.Added), slog.Any("removed", delta.Removed), slog.Any("updated", delta.Updated))
+	return delta, nil
+}
+
+// reloadOnSignal reloads the configuration on each SIGHUP
+func (s *taskScheduler) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			s.reloadLogged("SIGHUP")
+		}
+	}()
+}
+
+// watchConfig polls the configuration file, or directory, and reloads it once it changed
+// and then stayed the same for one more interval, so a file that is still
+// being written isn't picked up half way. Polling the content, rather
+// than watching the file, also follows the symlink swaps of Kubernetes
+// ConfigMap volumes
+func (s *taskScheduler) watchConfig(interval time.Duration) {
+	checksum := func() []byte {
+		files, err := configFiles(s.configPath)
+		if err != nil {
+			slog.Warn("Failed to read the configuration file", slog.String("error", err.Error()))
+			return nil
+		}
+		hash := sha256.New()
+		for _, file := range files {
+			data, err := readConfigSource(file)
+			if err != nil {
+				slog.Warn("Failed to read the configuration file", slog.String("error", err.Error()))
+				return nil
+			}
+			fmt.Fprintf(hash, "%s\x00%d\x00", file, len(data))
+			hash.Write(data)
+		}
+		return hash.Sum(nil)
+	}
+	applied := checksum()
+	go func() {
+		var pending []byte
+		for range time.Tick(interval) {
+			current := checksum()
+			switch {
+			case current == nil || bytes.Equal(current, applied):
+				pending = nil
+			case !bytes.Equal(current, pending):
+				// changed since the last check, wait for it to settle
+				pending = current
+			default:
+				// an invalid file isn't retried until it changes again
+				applied, pending = current, nil
+				s.reloadLogged("file change")
+			}
+		}
+	}()
+}
+
+// reload reads the configuration file again and adds, removes and
+// reschedules the tasks that changed. Nothing is applied unless the whole
+// file is valid. Settings outside the jobs, such as max_concurrent_jobs,
+// need a restart
+func (s *taskScheduler) reload() (configDelta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var delta configDelta
+	var specs BackupSpecifications
+	if err := loadBackupConfig(s.configPath, &specs); err != nil {
+		return delta, err
+	}
+	if err := specs.validate(); err != nil {
+		return delta, err
+	}
+	tasks := specs.enabledTasks()
+
+	current := control.definitions()
+	var changed []BackupTask
+	for _, task := range tasks {
+		previous, ok := current[task.Name]
+		switch {
+		case !ok:
+			delta.Added = append(delta.Added, task.Name)
+			changed = append(changed, task)
+		case !reflect.DeepEqual(previous, task):
+			delta.Updated = append(delta.Updated, task.Name)
+			changed = append(changed, task)
+		}
+	}
+	for name := range current {
+		if !slices.ContainsFunc(tasks, func(task BackupTask) bool { return task.Name == name }) {
+			delta.Removed = append(delta.Removed, name)
+		}
+	}
+	slices.Sort(delta.Removed)
+
+	// the storages are set up before anything is unscheduled, so one that
+	// fails leaves the running tasks as they were
+	destinations := make([][]Destination, len(changed))
+	for i, task := range changed {
+		var err error
+		if destinations[i], err = task.resolveDestinations(s.storage); err != nil {
+			return delta, fmt.Errorf("backup task %s: failed to initialize task storage: %s", task.Name, err)
+		}
+	}
+
+	for _, name := range delta.Removed {
+		s.unschedule(name)
+	}
+	for _, name := range delta.Updated {
+		s.unscheduleJobs(name)
+	}
+	// dependencies are registered again, as the tasks they point at changed
+	resetDependents()
+	for i, task := range changed {
+		if err := s.add(task, destinations[i], false); err != nil {
+			return delta, fmt.Errorf("backup task %s: %s", task.Name, err)
+		}
+	}
+	for _, dependent := range control.dependents() {
+		if !slices.ContainsFunc(changed, func(task BackupTask) bool { return task.Name == dependent.task.Name }) {
+			addDependent(dependent.task, dependent.run)
+		}
+	}
+	return delta, nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var partPattern = regexp.MustCompile(`\.part\d{4}$`)
+
+// restoreCommand downloads a run of a job, the latest one, a given backup
+// ID or the latest one on a date, and by default undoes its encryption and
+// compression. Split artifacts are put back together from their parts
+func restoreCommand(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	jobName := flags.String("job", "", "the job whose backup is restored")
+	backupID := flags.String("id", "", "restore the run with this backup ID instead of the latest")
+	date := flags.String("date", "", "restore the latest run on or before this date, e.g. 2006-01-02")
+	destinationName := flags.String("destination", "", "restore from this destination instead of the job's first one")
+	output := flags.String("output", ".", "directory the artifacts are written to")
+	identity := flags.String("identity", "", "age identity file, or gpg secret key file, to decrypt with")
+	passphraseEnv := flags.String("passphrase-env", "", "environment variable holding the gpg secret key's passphrase")
+	raw := flags.Bool("raw", false, "keep the artifacts encrypted and compressed")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *jobName == "" {
+		fmt.Fprintln(os.Stderr, "usage: restore -job name [-id id | -date 2006-01-02] [-output dir]")
+		return 2
+	}
+	var before time.Time
+	if *date != "" {
+		day, err := time.ParseInLocation("2006-01-02", *date, time.Local)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid date %q, expected 2006-01-02\n", *date)
+			return 2
+		}
+		before = day.AddDate(0, 0, 1)
+	}
+
+	_, storage, backupPlans, err := loadEnvironment()
+	if err != nil {
+		slog.Error("Failed to initialize", slog.String("error", err.Error()))
+		return 1
+	}
+	index := slices.IndexFunc(backupPlans.Tasks, func(task BackupTask) bool { return task.Name == *jobName })
+	if index < 0 {
+		slog.Error("No such job", slog.String("backup_task", *jobName))
+		return 1
+	}
+	task := backupPlans.Tasks[index]
+	logger := slog.With(slog.String("backup_task", task.Name))
+
+	destinations, err := task.resolveDestinations(storage)
+	if err != nil {
+		logger.Error("Failed to initialize task storage", slog.String("error", err.Error()))
+		return 1
+	}
+	destination := destinations[0]
+	if *destinationName != "" {
+		index := slices.IndexFunc(destinations, func(destination Destination) bool { return destination.Name == *destinationName })
+		if index < 0 {
+			logger.Error("No such destination", slog.String("destination", *destinationName))
+			return 1
+		}
+		destination = destinations[index]
+	}
+
+	ctx := context.Background()
+	backups, err := backupsOf(ctx, destination.Storage, task.Name)
+	if err != nil {
+		logger.Error("Failed to list backups", slog.String("error", err.Error()))
+		return 1
+	}
+	objects := runObjects(backups, *backupID, before)
+	if len(objects) == 0 {
+		logger.Error("No matching backup found")
+		return 1
+	}
+
+	if err := os.MkdirAll(*output, 0o755); err != nil {
+		logger.Error("Failed to create the output directory", slog.String("error", err.Error()))
+		return 1
+	}
+	for _, object := range objects {
+		objectLogger := logger.With(slog.String("object", object))
+		filePath, err := downloadArtifact(ctx, destination.Storage, object, *output)
+		if err != nil {
+			objectLogger.Error("Failed to download the backup", slog.String("error", err.Error()))
+			return 1
+		}
+		if !*raw {
+			if filePath, err = decodeArtifact(ctx, filePath, *identity, *passphraseEnv); err != nil {
+				objectLogger.Error("Failed to decode the backup", slog.String("error", err.Error()))
+				return 1
+			}
+		}
+		objectLogger.Info("Restored backup", slog.String("path", filePath))
+	}
+	return 0
+}
+
+// runObjects returns the artifacts of the chosen run among the backups,
+// which are sorted newest first. Split artifacts are named by their
+// manifest's object; the parts themselves are left out
+func runObjects(backups []StoredObject, backupID string, before time.Time) []string {
+	if backupID == "" {
+		for _, backup := range backups {
+			if before.IsZero() || backup.LastModified.Before(before) {
+				_, backupID, _ = parseFileName(backup.Name)
+				break
+			}
+		}
+	}
+	if backupID == "" {
+		return nil
+	}
+
+	names := map[string]bool{}
+	for _, backup := range backups {
+		if _, id, _ := parseFileName(backup.Name); id == backupID {
+			names[backup.Name] = true
+		}
+	}
+	var objects []string
+	for name := range names {
+		if partPattern.MatchString(name) || strings.HasSuffix(name, ".sha256") || isRunLog(name, names) {
+			continue
+		}
+		objects = append(objects, name)
+	}
+	slices.Sort(objects)
+	return objects
+}
+
+// downloadArtifact writes the object into dir and returns the file's path.
+// A manifest is replaced by the artifact its parts add up to, each part
+// checked against its checksum
+func downloadArtifact(ctx context.Context, storage Storage, object, dir string) (string, error) {
+	manifestObject, split := strings.CutSuffix(object, ".manifest.json")
+	if !split {
+		filePath := filepath.Join(dir, path.Base(object))
+		return filePath, downloadObject(ctx, storage, object, filePath)
+	}
+
+	reader, err := storage.Get(ctx, object)
+	if err != nil {
+		return "", err
+	}
+	var manifest splitManifest
+	err = json.NewDecoder(reader).Decode(&manifest)
+	reader.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the manifest: %s", err)
+	}
+
+	filePath := filepath.Join(dir, path.Base(manifestObject))
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	for _, part := range manifest.Parts {
+		reader, err := storage.Get(ctx, part.Name)
+		if err != nil {
+			return "", fmt.Errorf("failed to download part %s: %s", part.Name, err)
+		}
+		hash := sha256.New()
+		written, err := io.Copy(io.MultiWriter(file, hash), reader)
+		reader.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to download part %s: %s", part.Name, err)
+		}
+		if written != part.Size || hex.EncodeToString(hash.Sum(nil)) != part.SHA256 {
+			return "", fmt.Errorf("part %s doesn't match its checksum", part.Name)
+		}
+	}
+	return filePath, file.Close()
+}
+
+func downloadObject(ctx context.Context, storage Storage, object, filePath string) error {
+	reader, err := storage.Get(ctx, object)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, reader); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// decodeArtifact undoes the encryption and compression named by the file's
+// extensions, the reverse of prepareArtifact, and returns the result's path
+func decodeArtifact(ctx context.Context, filePath, identity, passphraseEnv string) (string, error) {
+	for {
+		var err error
+		decoded := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+		switch filepath.Ext(filePath) {
+		case ".age":
+			if identity == "" {
+				return "", fmt.Errorf("the backup is encrypted with age, pass -identity or -raw")
+			}
+			err = decryptAge(ctx, filePath, decoded, identity)
+		case ".gpg":
+			if identity == "" {
+				return "", fmt.Errorf("the backup is encrypted with gpg, pass -identity or -raw")
+			}
+			err = decryptGPG(ctx, filePath, decoded, identity, passphraseEnv)
+		case ".gz", ".zst":
+			err = decompress(filePath, decoded)
+		case ".xz":
+			err = decompressXZ(ctx, filePath, decoded)
+		default:
+			return filePath, nil
+		}
+		if err != nil {
+			os.Remove(decoded)
+			return "", err
+		}
+		os.Remove(filePath)
+		filePath = decoded
+	}
+}
+
+func decryptAge(ctx context.Context, filePath, output, identity string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "age", "--decrypt", "--identity", identity, "--output", output, filePath)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("age decryption failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func decryptGPG(ctx context.Context, filePath, output, keyFile, passphraseEnv string) error {
+	keys, err := os.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read gpg key: %s", err)
+	}
+	home, _, err := gpgHome(ctx, keys)
+	if err != nil {
+		return err
+	}
+	defer removeGPGHome(home)
+
+	// the passphrase is passed on stdin rather than the command line, where
+	// other users could read it
+	args := []string{"--homedir", home, "--batch", "--yes", "--pinentry-mode", "loopback"}
+	var stdin io.Reader
+	if passphraseEnv != "" {
+		args = append(args, "--passphrase-fd", "0")
+		stdin = strings.NewReader(os.Getenv(passphraseEnv))
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "gpg", append(args, "--output", output, "--decrypt", filePath)...)
+	cmd.Stdin = stdin
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("gpg decryption failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// decompress undoes gzip or zstd compression
+func decompress(filePath, output string) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var reader io.ReadCloser
+	format := "gzip"
+	if filepath.Ext(filePath) == ".zst" {
+		format = "zstd"
+		decoder, err := zstd.NewReader(src)
+		if err != nil {
+			return fmt.Errorf("failed to create zstd reader: %s", err)
+		}
+		reader = decoder.IOReadCloser()
+	} else if reader, err = gzip.NewReader(src); err != nil {
+		return fmt.Errorf("gzip decompression failed: %s", err)
+	}
+	defer reader.Close()
+
+	dst, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, reader); err != nil {
+		return fmt.Errorf("%s decompression failed: %s", format, err)
+	}
+	return dst.Close()
+}
+
+func decompressXZ(ctx context.Context, filePath, output string) error {
+	dst, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "xz", "--decompress", "--stdout", filePath)
+	cmd.Stdout = dst
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("xz decompression failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return dst.Close()
+}
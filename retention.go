@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy controls which of a task's backups are kept in storage.
+// A backup survives when any of the rules keeps it; the grandfather-father-son
+// rules keep the newest backup of each of the last N days, weeks, months
+// or years that have backups
+type RetentionPolicy struct {
+	KeepLast    int `yaml:"keep_last"`
+	KeepDaily   int `yaml:"keep_daily"`
+	KeepWeekly  int `yaml:"keep_weekly"`
+	KeepMonthly int `yaml:"keep_monthly"`
+	KeepYearly  int `yaml:"keep_yearly"`
+}
+
+// backupsOf returns the task's backups in the storage, newest first
+func backupsOf(ctx context.Context, storage Storage, taskName string) ([]StoredObject, error) {
+	return taskObjectsOf(ctx, storage, taskName, "")
+}
+
+// failedRunLogsOf returns the script output of the task's runs that
+// uploaded nothing, newest first
+func failedRunLogsOf(ctx context.Context, storage Storage, taskName string) ([]StoredObject, error) {
+	return taskObjectsOf(ctx, storage, taskName, failedRunLogPrefix)
+}
+
+// taskObjectsOf returns the task's objects under the prefix, newest first.
+// The logs of failed runs are only returned when asked for by their prefix
+func taskObjectsOf(ctx context.Context, storage Storage, taskName, prefix string) ([]StoredObject, error) {
+	objects, err := storage.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []StoredObject
+	for _, object := range objects {
+		if prefix == "" && strings.HasPrefix(object.Name, failedRunLogPrefix) {
+			continue
+		}
+		if name, _, ok := parseFileName(object.Name); ok && name == taskName {
+			backups = append(backups, object)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].LastModified.Equal(backups[j].LastModified) {
+			return backups[i].Name > backups[j].Name
+		}
+		return backups[i].LastModified.After(backups[j].LastModified)
+	})
+	return backups, nil
+}
+
+// expired returns the backups the policy no longer wants to keep; backups
+// must be sorted newest first. Objects sharing a backup ID belong to one run
+// and are kept or expired together
+func (policy RetentionPolicy) expired(backups []StoredObject) []StoredObject {
+	rules := []struct {
+		count  int
+		period func(time.Time) string
+	}{
+		{policy.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{policy.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+		{policy.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{policy.KeepYearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+
+	// runs holds the newest object of every backup run, newest first
+	var runs []StoredObject
+	runOf := map[string]int{}
+	for _, backup := range backups {
+		_, id, _ := parseFileName(backup.Name)
+		if _, ok := runOf[id]; !ok {
+			runOf[id] = len(runs)
+			runs = append(runs, backup)
+		}
+	}
+
+	keep := make([]bool, len(runs))
+	anyRule := policy.KeepLast > 0
+	for i := 0; i < policy.KeepLast && i < len(runs); i++ {
+		keep[i] = true
+	}
+	for _, rule := range rules {
+		if rule.count <= 0 {
+			continue
+		}
+		anyRule = true
+		seen := map[string]bool{}
+		for i, run := range runs {
+			if len(seen) >= rule.count {
+				break
+			}
+			period := rule.period(run.LastModified.Local())
+			if !seen[period] {
+				seen[period] = true
+				keep[i] = true
+			}
+		}
+	}
+	if !anyRule {
+		return nil
+	}
+
+	var expired []StoredObject
+	for _, backup := range backups {
+		_, id, _ := parseFileName(backup.Name)
+		if !keep[runOf[id]] {
+			expired = append(expired, backup)
+		}
+	}
+	return expired
+}
+
+// applyRetention deletes the task's backups that fall outside its
+// retention policy from the destination. In dry-run mode the backups are
+// only logged. The returned error reports whether anything went wrong
+func (task BackupTask) applyRetention(ctx context.Context, destination Destination, logger *slog.Logger, dryRun bool) error {
+	if task.Retention == nil {
+		return nil
+	}
+	logger = logger.With(slog.String("destination", destination.Name))
+
+	backups, err := backupsOf(ctx, destination.Storage, task.Name)
+	if err != nil {
+		logger.Error("Failed to list backups for retention", slog.String("error", err.Error()))
+		return err
+	}
+
+	// the logs of failed runs are kept by the same rules, counted apart so
+	// they never take the place of a backup
+	logs, err := failedRunLogsOf(ctx, destination.Storage, task.Name)
+	if err != nil {
+		logger.Error("Failed to list the logs of failed runs for retention", slog.String("error", err.Error()))
+		return err
+	}
+
+	failed := 0
+	for _, backup := range append(task.Retention.expired(backups), task.Retention.expired(logs)...) {
+		if dryRun {
+			logger.Info("Would delete expired backup", slog.String("object", backup.Name))
+			continue
+		}
+		if err := destination.Storage.Delete(ctx, backup.Name); err != nil {
+			logger.Error("Failed to delete expired backup", slog.String("object", backup.Name), slog.String("error", err.Error()))
+			failed++
+			continue
+		}
+		logger.Info("Deleted expired backup", slog.String("object", backup.Name))
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d expired backups", failed)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestApplyRetentionPrunesFailedRunLogs(t *testing.T) {
+	storage := newFakeStorage()
+	now := time.Now()
+	store := func(name string, age time.Duration) {
+		storage.objects[name] = []byte("x")
+		storage.modified[name] = now.Add(-age)
+	}
+	store("2024_01_01_01_00_00_00-db-aaaaaaaa.sql.gz", 5*time.Hour)
+	store("2024_01_02_02_00_00_00-db-bbbbbbbb.sql.gz", 3*time.Hour)
+	store("logs/2024_01_01_01_00_00_00-db-cccccccc.log", 4*time.Hour)
+	store("logs/2024_01_02_02_00_00_00-db-dddddddd.log", 2*time.Hour)
+	store("logs/2024_01_02_02_00_00_00-db-eeeeeeee.log", time.Hour)
+	store("logs/2024_01_02_02_00_00_00-web-ffffffff.log", 6*time.Hour)
+	task := BackupTask{Name: "db", Retention: &RetentionPolicy{KeepLast: 1}}
+
+	if err := task.applyRetention(context.Background(), Destination{Name: "primary", Storage: storage}, discardLogger, false); err != nil {
+		t.Fatal(err)
+	}
+	// the newer logs don't push out the newest backup, and another task's
+	// logs are left alone
+	want := []string{
+		"2024_01_02_02_00_00_00-db-bbbbbbbb.sql.gz",
+		"logs/2024_01_02_02_00_00_00-db-eeeeeeee.log",
+		"logs/2024_01_02_02_00_00_00-web-ffffffff.log",
+	}
+	if got := storage.names(); !slices.Equal(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+}
+
+func TestBackupsOfLeavesOutFailedRunLogs(t *testing.T) {
+	storage := newFakeStorage()
+	storage.objects["2024_01_01_01_00_00_00-db-aaaaaaaa.sql.gz"] = []byte("x")
+	storage.objects["logs/2024_01_01_01_00_00_00-db-bbbbbbbb.log"] = []byte("x")
+
+	backups, err := backupsOf(context.Background(), storage, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Name != "2024_01_01_01_00_00_00-db-aaaaaaaa.sql.gz" {
+		t.Errorf("got %v", backups)
+	}
+}
+
+func TestRetentionExpired(t *testing.T) {
+	day := func(date string, id string) StoredObject {
+		modified, _ := time.ParseInLocation("2006-01-02 15:04", date, time.Local)
+		return StoredObject{Name: modified.Format("2006_01_02_02_15_04_05") + "-db-" + id + ".sql.gz", LastModified: modified}
+	}
+	// newest first, two runs on 2024-03-10 and one with a checksum
+	backups := []StoredObject{
+		day("2024-03-10 18:00", "run00010"),
+		day("2024-03-10 06:00", "run00009"),
+		day("2024-03-09 06:00", "run00008"),
+		day("2024-03-04 06:00", "run00007"),
+		day("2024-03-03 06:00", "run00006"),
+		day("2024-02-15 06:00", "run00005"),
+		day("2024-02-01 06:00", "run00004"),
+		day("2024-01-20 06:00", "run00003"),
+		day("2023-12-31 06:00", "run00002"),
+		day("2023-06-01 06:00", "run00001"),
+	}
+	checksum := backups[2]
+	checksum.Name += ".sha256"
+	backups = append(backups[:3], append([]StoredObject{checksum}, backups[3:]...)...)
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		kept   []string
+	}{
+		{"no rules", RetentionPolicy{}, []string{"run00010", "run00009", "run00008", "run00007", "run00006", "run00005", "run00004", "run00003", "run00002", "run00001"}},
+		{"keep last", RetentionPolicy{KeepLast: 3}, []string{"run00010", "run00009", "run00008"}},
+		{"daily", RetentionPolicy{KeepDaily: 3}, []string{"run00010", "run00008", "run00007"}},
+		// 2024-03-04 starts ISO week 10, 2024-03-03 ends week 9
+		{"weekly", RetentionPolicy{KeepWeekly: 2}, []string{"run00010", "run00006"}},
+		{"monthly", RetentionPolicy{KeepMonthly: 3}, []string{"run00010", "run00005", "run00003"}},
+		{"yearly", RetentionPolicy{KeepYearly: 5}, []string{"run00010", "run00002"}},
+		{"combined", RetentionPolicy{KeepLast: 1, KeepDaily: 2, KeepMonthly: 2}, []string{"run00010", "run00008", "run00005"}},
+		{"more than there are", RetentionPolicy{KeepDaily: 100}, []string{"run00010", "run00008", "run00007", "run00006", "run00005", "run00004", "run00003", "run00002", "run00001"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expired := map[string]bool{}
+			for _, backup := range test.policy.expired(backups) {
+				expired[backup.Name] = true
+			}
+			var kept []string
+			for _, backup := range backups {
+				_, id, _ := parseFileName(backup.Name)
+				if !expired[backup.Name] && !slices.Contains(kept, id) {
+					kept = append(kept, id)
+				}
+				// a run is kept or expired as a whole
+				if expired[backup.Name] && slices.Contains(kept, id) {
+					t.Errorf("%s is expired while its run is kept", backup.Name)
+				}
+			}
+			if !slices.Equal(kept, test.kept) {
+				t.Errorf("kept %v, want %v", kept, test.kept)
+			}
+		})
+	}
+}
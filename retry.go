@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// defaultRetryDelay is the wait before the first retry
+	defaultRetryDelay = 30 * time.Second
+	// defaultMaxRetryDelay caps the wait between exponential retries
+	defaultMaxRetryDelay = 10 * time.Minute
+)
+
+func (task BackupTask) validateRetries() error {
+	switch {
+	case task.Retries < 0:
+		return fmt.Errorf("retries must not be negative")
+	case task.Backoff != "" && task.Backoff != "constant" && task.Backoff != "exponential":
+		return fmt.Errorf("backoff must be constant or exponential")
+	case task.RetryDelay < 0 || task.MaxRetryDelay < 0:
+		return fmt.Errorf("retry delays must not be negative")
+	}
+	return nil
+}
+
+// retryDelay is the wait before the given retry, counted from one. With
+// exponential backoff it doubles every time, up to max_retry_delay
+func (task BackupTask) retryDelay(retry int) time.Duration {
+	delay := time.Duration(task.RetryDelay)
+	if delay == 0 {
+		delay = defaultRetryDelay
+	}
+	if task.Backoff == "constant" {
+		return delay
+	}
+	limit := time.Duration(task.MaxRetryDelay)
+	if limit == 0 {
+		limit = defaultMaxRetryDelay
+	}
+	for i := 1; i < retry && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
+// runWithRetries runs the whole backup pipeline again after a failure, up
+// to the task's retries, as most failures are transient, like a database
+// lock or a network blip. The run only counts as failed once the last
+// attempt did; it stops retrying when ctx is cancelled
+func (task BackupTask) runWithRetries(ctx context.Context, report *RunReport, destinations []Destination, logger *slog.Logger) error {
+	report.Attempts = 1
+	err := task.run(ctx, report, destinations, logger)
+	for ; err != nil && report.Attempts <= task.Retries; report.Attempts++ {
+		delay := task.retryDelay(report.Attempts)
+		logger.Warn("Backup task failed, retrying",
+			slog.String("error", err.Error()),
+			slog.Int("attempt", report.Attempts),
+			slog.Duration("delay", delay),
+		)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		report.resetAttempt()
+		err = task.run(ctx, report, destinations, logger)
+	}
+	return err
+}
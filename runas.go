@@ -0,0 +1,20 @@
+package main
+
+import "fmt"
+
+// RunAs is the user and group a task's scripts run as, so the scheduler
+// can run as root while dumps run with least privilege
+type RunAs struct {
+	// User is a user name or ID; the scripts also get its groups
+	User string `yaml:"user"`
+	// Group is a group name or ID, the user's primary group by default
+	Group string `yaml:"group"`
+}
+
+func (r *RunAs) validate() error {
+	if r.User == "" && r.Group == "" {
+		return fmt.Errorf("needs a user or group")
+	}
+	_, err := r.credential()
+	return err
+}
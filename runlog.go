@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// runLogName is the file in a run's temporary directory its scripts'
+	// output is written to
+	runLogName = "run.log"
+	// failedRunLogPrefix holds the script output of runs that uploaded
+	// nothing, apart from the backups
+	failedRunLogPrefix = "logs/"
+)
+
+// runLog collects the output of a run's scripts, stdout and stderr alike,
+// so a failed backup can be looked into without digging through the
+// scheduler's interleaved logs
+type runLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newRunLog(tempDir string) (*runLog, error) {
+	file, err := os.Create(filepath.Join(tempDir, runLogName))
+	if err != nil {
+		return nil, err
+	}
+	return &runLog{file: file}, nil
+}
+
+func (l *runLog) Write(data []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Write(data)
+}
+
+func (l *runLog) Close() error {
+	return l.file.Close()
+}
+
+// isRunLog tells whether an object is the script output of a run rather
+// than one of its artifacts, which may be log files themselves, given the
+// objects of the run
+func isRunLog(objectName string, objects map[string]bool) bool {
+	artifact, ok := strings.CutSuffix(objectName, ".log")
+	return ok && objects[artifact]
+}
+
+// uploadRunLog stores the run's script output next to its first artifact
+// as <object>.log. A run that uploaded nothing, e.g. as it failed, has it
+// stored under logs/ named like a backup, which retention prunes by the
+// task's policy
+func (task BackupTask) uploadRunLog(log *runLog, report *RunReport, destinations []Destination, logger *slog.Logger) {
+	objectName := failedRunLogPrefix + generateFileName(task.Name, report.BackupID, ".log")
+	if len(report.Objects) > 0 {
+		objectName = report.Objects[0] + ".log"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	for _, destination := range destinations {
+		err := destination.Storage.Put(ctx, objectName, log.file.Name(), PutOptions{ContentType: "text/plain; charset=utf-8"})
+		if err != nil {
+			logger.Warn("Failed to upload the script output", slog.String("destination", destination.Name), slog.String("error", err.Error()))
+		}
+	}
+}
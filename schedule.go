@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+	"github.com/robfig/cron/v3"
+)
+
+func (task BackupTask) validateSchedule() error {
+	var problems []error
+	if len(task.Schedule) > 0 && task.Every != 0 {
+		problems = append(problems, fmt.Errorf("only one of schedule and every may be set"))
+	}
+	if len(task.Schedule) == 0 && task.Every == 0 && len(task.After) == 0 {
+		problems = append(problems, fmt.Errorf("one of schedule, every and after is required"))
+	}
+	if task.Every < 0 {
+		problems = append(problems, fmt.Errorf("every must be positive"))
+	}
+	switch task.Overlap {
+	case "", "allow", "skip", "queue", "cancel_previous":
+	default:
+		problems = append(problems, fmt.Errorf("unknown overlap %q, expected allow, skip, queue or cancel_previous", task.Overlap))
+	}
+	if task.Jitter < 0 {
+		problems = append(problems, fmt.Errorf("jitter must not be negative"))
+	}
+	for _, window := range task.Blackout {
+		if err := window.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("blackout: %s", err))
+		}
+	}
+	if task.Timezone != "" {
+		if _, err := time.LoadLocation(task.Timezone); err != nil {
+			// the schedules can't be read in an unknown zone either
+			return errors.Join(append(problems, fmt.Errorf("unknown timezone %q", task.Timezone))...)
+		}
+	}
+	for _, expression := range task.Schedule {
+		if _, err := parseCron(expression, task.Timezone, task.ScheduleWithSeconds); err != nil {
+			problems = append(problems, fmt.Errorf("invalid schedule %q: %s", expression, err))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// cronParser reads schedules the way gocron does, with an optional
+// seconds field
+var (
+	cronParser        = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	cronSecondsParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+)
+
+// cronExpression returns the schedule with its timezone prefix and whether
+// it has a seconds field
+func cronExpression(expression, timezone string, withSeconds bool) (string, bool) {
+	fields := strings.Fields(expression)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		fields = fields[1:]
+	} else if timezone != "" {
+		expression = "CRON_TZ=" + timezone + " " + expression
+	}
+	return expression, withSeconds || len(fields) == 6
+}
+
+func parseCron(expression, timezone string, withSeconds bool) (cron.Schedule, error) {
+	expression, withSeconds = cronExpression(expression, timezone, withSeconds)
+	if withSeconds {
+		return cronSecondsParser.Parse(expression)
+	}
+	return cronParser.Parse(expression)
+}
+
+// cronSchedules fire whenever the first of them does
+type cronSchedules []cron.Schedule
+
+func (schedules cronSchedules) Next(t time.Time) time.Time {
+	var next time.Time
+	for _, schedule := range schedules {
+		if n := schedule.Next(t); !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	return next
+}
+
+// cronSchedule parses the task's cron schedules to compute firing times
+func (task BackupTask) cronSchedule() (cron.Schedule, error) {
+	var schedules cronSchedules
+	for _, expression := range task.Schedule {
+		schedule, err := parseCron(expression, task.Timezone, task.ScheduleWithSeconds)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+// cronJob interprets the cron expression in the timezone, or in the
+// process' local one when it is empty. Expressions with their own CRON_TZ=
+// prefix keep it. Six-field expressions start with a seconds field
+func cronJob(expression, timezone string, withSeconds bool) gocron.JobDefinition {
+	return gocron.CronJob(cronExpression(expression, timezone, withSeconds))
+}
+
+// jobDefinitions return when the task runs, one for each of its schedules
+func (task BackupTask) jobDefinitions() []gocron.JobDefinition {
+	if task.Every > 0 {
+		return []gocron.JobDefinition{gocron.DurationJob(time.Duration(task.Every))}
+	}
+	var definitions []gocron.JobDefinition
+	for _, expression := range task.Schedule {
+		definitions = append(definitions, cronJob(expression, task.Timezone, task.ScheduleWithSeconds))
+	}
+	return definitions
+}
+
+// scheduleDescription tells when the task runs, for listings
+func (task BackupTask) scheduleDescription() string {
+	switch {
+	case task.Every > 0:
+		return "every " + time.Duration(task.Every).String()
+	case len(task.Schedule) > 0:
+		return strings.Join(task.Schedule, ", ")
+	case len(task.After) > 0:
+		return "after " + strings.Join(task.After, ", ")
+	}
+	return ""
+}
+
+// nextRun returns the earliest next run of the jobs, if the scheduler knows
+// one
+func nextRun(jobs []gocron.Job) (time.Time, bool) {
+	var next time.Time
+	for _, job := range jobs {
+		if n, err := job.NextRun(); err == nil && !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// jobOptions returns how the scheduler treats the task's runs
+func (task BackupTask) jobOptions() []gocron.JobOption {
+	options := []gocron.JobOption{gocron.WithName(task.Name)}
+	if task.RunOnStart {
+		options = append(options, gocron.WithStartAt(gocron.WithStartImmediately()))
+	}
+	return options
+}
+
+// runSlot holds the run of a task in progress, so that a new run can
+// cancel it, be skipped or wait for it. It is shared by the scheduler's
+// jobs for each of the task's schedules
+type runSlot struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	busy sync.Mutex
+}
+
+// enter applies the skip and queue overlap modes. It returns false when the
+// run is skipped, or otherwise a function to call once the run is over
+func (slot *runSlot) enter(overlap string, logger *slog.Logger) (func(), bool) {
+	switch overlap {
+	case "skip":
+		if !slot.busy.TryLock() {
+			logger.Warn("Skipping backup task, the previous run is still in progress")
+			return nil, false
+		}
+	case "queue":
+		if !slot.busy.TryLock() {
+			logger.Info("Waiting for the previous run to finish")
+			slot.busy.Lock()
+		}
+	default:
+		return func() {}, true
+	}
+	return slot.busy.Unlock, true
+}
+
+// acquire cancels the previous run if it is still going and waits for it
+// to wind down. It returns the new run's context and a function to call
+// once the run is over
+func (slot *runSlot) acquire(parent context.Context, logger *slog.Logger) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	done := make(chan struct{})
+
+	slot.mu.Lock()
+	previousCancel, previousDone := slot.cancel, slot.done
+	slot.cancel, slot.done = cancel, done
+	slot.mu.Unlock()
+
+	if previousDone != nil {
+		select {
+		case <-previousDone:
+		default:
+			logger.Warn("Cancelling the previous run, which is still in progress")
+			previousCancel()
+			<-previousDone
+		}
+	}
+	return ctx, func() {
+		cancel()
+		close(done)
+	}
+}
+
+// delay waits a random part of the task's jitter before a run, so
+// instances sharing a configuration don't all start at the same moment
+func (task BackupTask) delay() {
+	if task.Jitter <= 0 {
+		return
+	}
+	wait := rand.N(time.Duration(task.Jitter))
+	slog.Info("Delaying backup task by its jitter", slog.String("backup_task", task.Name), slog.Duration("delay", wait))
+	time.Sleep(wait)
+}
+
+// jobSlots caps how many tasks run at once, or is nil without a limit.
+// Runs wait for a slot rather than gocron's scheduler-wide limit, which
+// would also hold up the scheduler's own jobs like the watchdog
+var jobSlots chan struct{}
+
+// waitForSlot blocks until the task may run and returns a function that
+// frees the slot again
+func waitForSlot(logger *slog.Logger) func() {
+	if jobSlots == nil {
+		return func() {}
+	}
+	select {
+	case jobSlots <- struct{}{}:
+	default:
+		logger.Info("Waiting for other backup tasks to finish", slog.Int("max_concurrent_jobs", cap(jobSlots)))
+		jobSlots <- struct{}{}
+	}
+	return func() { <-jobSlots }
+}
+
+var (
+	groupLocksMu sync.Mutex
+	groupLocks   = map[string]*sync.Mutex{}
+)
+
+// lockGroup blocks until no other task of the group runs and returns a
+// function that lets the next one go
+func lockGroup(group string, logger *slog.Logger) func() {
+	if group == "" {
+		return func() {}
+	}
+	groupLocksMu.Lock()
+	lock, ok := groupLocks[group]
+	if !ok {
+		lock = &sync.Mutex{}
+		groupLocks[group] = lock
+	}
+	groupLocksMu.Unlock()
+
+	if !lock.TryLock() {
+		logger.Info("Waiting for another backup task of the group to finish", slog.String("group", group))
+		lock.Lock()
+	}
+	return lock.Unlock
+}
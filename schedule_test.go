@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	if _, err := time.LoadLocation("Europe/Berlin"); err != nil {
+		t.Skip("needs the timezone database")
+	}
+	from := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		task     BackupTask
+		expected time.Time
+	}{
+		{
+			name:     "utc",
+			task:     BackupTask{Schedule: stringList{"0 2 * * *"}, Timezone: "UTC"},
+			expected: time.Date(2024, 3, 4, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "task timezone",
+			task:     BackupTask{Schedule: stringList{"0 2 * * *"}, Timezone: "Europe/Berlin"},
+			expected: time.Date(2024, 3, 4, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "own timezone prefix wins",
+			task:     BackupTask{Schedule: stringList{"CRON_TZ=America/New_York 0 2 * * *"}, Timezone: "Europe/Berlin"},
+			expected: time.Date(2024, 3, 4, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "six fields have seconds",
+			task:     BackupTask{Schedule: stringList{"30 0 2 * * *"}, Timezone: "UTC"},
+			expected: time.Date(2024, 3, 4, 2, 0, 30, 0, time.UTC),
+		},
+		{
+			name:     "earliest of a list",
+			task:     BackupTask{Schedule: stringList{"0 2 * * *", "30 1 * * *"}, Timezone: "UTC"},
+			expected: time.Date(2024, 3, 4, 1, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "descriptor",
+			task:     BackupTask{Schedule: stringList{"@weekly"}, Timezone: "UTC"},
+			expected: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := test.task.cronSchedule()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next := schedule.Next(from); !next.Equal(test.expected) {
+				t.Errorf("got %s, want %s", next.UTC(), test.expected)
+			}
+		})
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	tests := []struct {
+		name  string
+		task  BackupTask
+		valid bool
+	}{
+		{"cron", BackupTask{Schedule: stringList{"0 2 * * *"}}, true},
+		{"every", BackupTask{Every: Duration(time.Hour)}, true},
+		{"after", BackupTask{After: stringList{"db"}}, true},
+		{"none", BackupTask{}, false},
+		{"both", BackupTask{Schedule: stringList{"0 2 * * *"}, Every: Duration(time.Hour)}, false},
+		{"bad expression", BackupTask{Schedule: stringList{"0 25 * * *"}}, false},
+		{"seconds field missing", BackupTask{Schedule: stringList{"0 2 * * *"}, ScheduleWithSeconds: true}, false},
+		{"seven fields", BackupTask{Schedule: stringList{"0 0 2 * * * *"}}, false},
+		{"unknown timezone", BackupTask{Schedule: stringList{"0 2 * * *"}, Timezone: "Mars/Olympus"}, false},
+		{"unknown overlap", BackupTask{Schedule: stringList{"0 2 * * *"}, Overlap: "sometimes"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.task.validateSchedule(); (err == nil) != test.valid {
+				t.Errorf("got %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestMissedRun(t *testing.T) {
+	last := time.Date(2024, 3, 4, 2, 0, 0, 0, time.UTC)
+	daily := BackupTask{Schedule: stringList{"0 2 * * *"}, Timezone: "UTC"}
+	hourly := BackupTask{Every: Duration(time.Hour)}
+
+	tests := []struct {
+		name   string
+		task   BackupTask
+		now    time.Time
+		missed bool
+	}{
+		{"cron not yet due", daily, last.Add(23*time.Hour + 59*time.Minute), false},
+		{"cron due", daily, last.Add(24*time.Hour + time.Minute), true},
+		{"every not yet due", hourly, last.Add(59 * time.Minute), false},
+		{"every due", hourly, last.Add(61 * time.Minute), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			missed, err := test.task.missedRun(last, test.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if missed != test.missed {
+				t.Errorf("got %v, want %v", missed, test.missed)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tempDirPrefix starts the name of every run's temporary directory
+	tempDirPrefix = "backup-"
+	// scratchCheckInterval is how often a run's temporary directories are
+	// measured against SCRATCH_QUOTA
+	scratchCheckInterval = 5 * time.Second
+)
+
+var (
+	// scratchDir holds the runs' temporary directories, such as a
+	// dedicated volume; the platform's temporary directory when empty
+	scratchDir string
+	// scratchQuota caps the total size of the runs' temporary directories
+	// in scratchDir, which is set along with it; unlimited when zero
+	scratchQuota ByteSize
+
+	// activeTempDirs are the temporary directories of runs in progress,
+	// which are never removed to make room
+	activeTempDirs   = map[string]bool{}
+	activeTempDirsMu sync.Mutex
+)
+
+// errScratchQuota fails runs whose temporary directories outgrow the quota
+var errScratchQuota = errors.New("temporary directories exceed SCRATCH_QUOTA")
+
+// createTemporaryDirectory creates the run's temporary directory in
+// SCRATCH_DIR, or the platform's temporary directory such as /tmp or
+// %TEMP%. With a quota, the kept directories of earlier failed runs are
+// removed, oldest first, when it is already used up
+func createTemporaryDirectory(name, id string) (string, error) {
+	if scratchQuota > 0 {
+		if err := makeScratchRoom(); err != nil {
+			return "", err
+		}
+	}
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	dir, err := os.MkdirTemp(scratchDir, fmt.Sprintf("%s%s-%s-", tempDirPrefix, name, id))
+	if err != nil {
+		return "", err
+	}
+	activeTempDirsMu.Lock()
+	activeTempDirs[dir] = true
+	activeTempDirsMu.Unlock()
+	return dir, nil
+}
+
+// removeTemporaryDirectory deletes the run's temporary directory once it is
+// over, unless it failed and the task keeps such directories for
+// inspection
+func (task BackupTask) removeTemporaryDirectory(dir string, runErr error, logger *slog.Logger) {
+	activeTempDirsMu.Lock()
+	delete(activeTempDirs, dir)
+	activeTempDirsMu.Unlock()
+	if runErr != nil && task.KeepFailedTempDir {
+		logger.Info("Keeping the temporary directory of the failed run", slog.String("dir", dir))
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		logger.Warn("Failed to remove the temporary directory", slog.String("dir", dir), slog.String("error", err.Error()))
+	}
+}
+
+// watchScratchQuota cancels the run with errScratchQuota when the
+// temporary directories of all runs outgrow SCRATCH_QUOTA, before they
+// fill up the disk. It returns a function that stops watching
+func watchScratchQuota(cancel context.CancelCauseFunc, logger *slog.Logger) func() {
+	if scratchQuota <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(scratchCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if size, _ := scratchUsage(); size > int64(scratchQuota) {
+				logger.Error("Cancelling the run, the temporary directories exceed the quota",
+					slog.String("size", ByteSize(size).String()), slog.String("quota", scratchQuota.String()))
+				cancel(errScratchQuota)
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// makeScratchRoom removes the kept temporary directories of failed runs,
+// oldest first, until the ones left fit in the quota
+func makeScratchRoom() error {
+	size, dirs := scratchUsage()
+	for _, dir := range dirs {
+		if size <= int64(scratchQuota) {
+			break
+		}
+		activeTempDirsMu.Lock()
+		active := activeTempDirs[dir.path]
+		activeTempDirsMu.Unlock()
+		if active {
+			continue
+		}
+		if err := os.RemoveAll(dir.path); err != nil {
+			return fmt.Errorf("failed to remove the kept temporary directory %s: %s", dir.path, err)
+		}
+		slog.Info("Removed a kept temporary directory to stay within the quota", slog.String("dir", dir.path))
+		size -= dir.size
+	}
+	if size > int64(scratchQuota) {
+		return fmt.Errorf("%s: %s in use, %s allowed", errScratchQuota, ByteSize(size), scratchQuota)
+	}
+	return nil
+}
+
+// scratchDirUsage is the size of a run's temporary directory
+type scratchDirUsage struct {
+	path     string
+	size     int64
+	modified time.Time
+}
+
+// scratchUsage returns the total size of the runs' temporary directories
+// in scratchDir and the directories themselves, oldest first. Files that
+// disappear while they are measured don't count
+func scratchUsage() (int64, []scratchDirUsage) {
+	base := scratchDir
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		return 0, nil
+	}
+	var total int64
+	var dirs []scratchDirUsage
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), tempDirPrefix) {
+			continue
+		}
+		usage := scratchDirUsage{path: filepath.Join(base, entry.Name())}
+		if info, err := entry.Info(); err == nil {
+			usage.modified = info.ModTime()
+		}
+		filepath.WalkDir(usage.path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				usage.size += info.Size()
+			}
+			return nil
+		})
+		total += usage.size
+		dirs = append(dirs, usage)
+	}
+	slices.SortFunc(dirs, func(a, b scratchDirUsage) int { return a.modified.Compare(b.modified) })
+	return total, dirs
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMakeScratchRoom(t *testing.T) {
+	scratchDir, scratchQuota = t.TempDir(), 250
+	defer func() { scratchDir, scratchQuota = "", 0 }()
+
+	write := func(name string, size int, age time.Duration) string {
+		dir := filepath.Join(scratchDir, name)
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "dump"), make([]byte, size), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		modified := time.Now().Add(-age)
+		os.Chtimes(dir, modified, modified)
+		return dir
+	}
+	oldest := write("backup-db-1-x", 100, 3*time.Hour)
+	older := write("backup-db-2-x", 100, 2*time.Hour)
+	active := write("backup-db-3-x", 100, 4*time.Hour)
+	unrelated := write("cache", 1000, 5*time.Hour)
+	activeTempDirs[active] = true
+	defer delete(activeTempDirs, active)
+
+	if err := makeScratchRoom(); err != nil {
+		t.Fatal(err)
+	}
+	for dir, want := range map[string]bool{oldest: false, older: true, active: true, unrelated: true} {
+		if _, err := os.Stat(dir); (err == nil) != want {
+			t.Errorf("%s: exists %t, want %t", filepath.Base(dir), err == nil, want)
+		}
+	}
+
+	scratchQuota = 50
+	if err := makeScratchRoom(); err == nil {
+		t.Error("an active directory over the quota was not reported")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// secretVariables lists the environment variables that may instead be read
+// from the file named by the same variable with a _FILE suffix, such as a
+// Docker or Kubernetes secret mount
+func (settings *Config) secretVariables() map[string]*string {
+	return map[string]*string{
+		"S3_ACCESS_KEY":               &settings.StorageConfig.PublicKey,
+		"S3_SECRET_KEY":               &settings.StorageConfig.PrivateKey,
+		"S3_SSE_C_KEY":                &settings.StorageConfig.SSECustomerKey,
+		"AZURE_SAS_TOKEN":             &settings.StorageConfig.AzureSASToken,
+		"SFTP_PRIVATE_KEY_PASSPHRASE": &settings.StorageConfig.SFTPPassphrase,
+		"ADMIN_TOKEN":                 &settings.AdminToken,
+		"VAULT_TOKEN":                 &settings.VaultToken,
+	}
+}
+
+// loadSecretFiles fills the secrets whose _FILE variable is set. They are
+// kept out of the environment, so scripts don't inherit them
+func (settings *Config) loadSecretFiles() error {
+	for name, value := range settings.secretVariables() {
+		path := os.Getenv(name + "_FILE")
+		if path == "" {
+			continue
+		}
+		if _, ok := os.LookupEnv(name); ok {
+			return fmt.Errorf("both %s and %s_FILE are set", name, name)
+		}
+		secret, err := readSecretFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s_FILE: %s", name, err)
+		}
+		*value = secret
+	}
+	return nil
+}
+
+// readSecretFile returns the file's content without the trailing newline
+// editors and `echo` leave
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolveFromFile replaces every `{from_file: path}` value in the parsed
+// configuration by the content of the file, e.g. a mounted secret
+func resolveFromFile(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode && len(node.Content) == 2 && node.Content[0].Value == "from_file" {
+		path := node.Content[1].Value
+		secret, err := readSecretFile(path)
+		if err != nil {
+			return fmt.Errorf("line %d: failed to read %s: %s", node.Line, path, err)
+		}
+		*node = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: secret, Line: node.Line, Column: node.Column}
+		return nil
+	}
+	for _, child := range node.Content {
+		if err := resolveFromFile(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// serveHTTP exposes /metrics, /healthz and /readyz on the address in the
+// background
+func serveHTTP(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := metrics.write(w); err != nil {
+			slog.Warn("Failed to write metrics", slog.String("error", err.Error()))
+		}
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !health.isReady() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		problems := health.problems()
+		status, code := "ok", http.StatusOK
+		if len(problems) > 0 {
+			status, code = "degraded", http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]any{"status": status, "problems": problems})
+	})
+	go func() {
+		slog.Info("Serving metrics and health checks", slog.String("address", address))
+		if err := http.ListenAndServe(address, mux); err != nil {
+			slog.Error("HTTP listener stopped", slog.String("error", err.Error()))
+		}
+	}()
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTP protocol version 3 packet types and flags, see
+// draft-ietf-secsh-filexfer-02
+const (
+	sftpInit      = 1
+	sftpVersion   = 2
+	sftpOpen      = 3
+	sftpClose     = 4
+	sftpRead      = 5
+	sftpWrite     = 6
+	sftpOpendir   = 11
+	sftpReaddir   = 12
+	sftpRemove    = 13
+	sftpMkdir     = 14
+	sftpStat      = 17
+	sftpStatus    = 101
+	sftpHandle    = 102
+	sftpData      = 103
+	sftpName      = 104
+	sftpAttrs     = 105
+	sftpFlagRead  = 0x01
+	sftpFlagWrite = 0x02
+	sftpFlagCreat = 0x08
+	sftpFlagTrunc = 0x10
+
+	sftpAttrSize        = 0x01
+	sftpAttrUIDGID      = 0x02
+	sftpAttrPermissions = 0x04
+	sftpAttrTimes       = 0x08
+	sftpAttrExtended    = 0x80000000
+	sftpModeDir         = 0o040000
+	sftpModeType        = 0o170000
+
+	sftpStatusOK         = 0
+	sftpStatusEOF        = 1
+	sftpStatusNoSuchFile = 2
+
+	sftpChunkSize      = 32 << 10
+	sftpMaxOutstanding = 64
+)
+
+// sftpStatusError is returned when the server answers a request with a
+// non-OK status packet
+type sftpStatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *sftpStatusError) Error() string {
+	return fmt.Sprintf("sftp status %d: %s", e.Code, e.Message)
+}
+
+// sftpClient is a minimal SFTP v3 client covering the handful of
+// operations needed to manage backup artifacts on a remote host
+type sftpClient struct {
+	session *ssh.Session
+	in      io.WriteCloser
+	out     io.Reader
+	nextID  uint32
+}
+
+func newSFTPClient(conn *ssh.Client) (*sftpClient, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	in, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	out, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start sftp subsystem: %s", err)
+	}
+
+	client := &sftpClient{session: session, in: in, out: out}
+	if err := client.send(sftpInit, uint32(3)); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if typ, _, err := client.recv(); err != nil {
+		client.Close()
+		return nil, err
+	} else if typ != sftpVersion {
+		client.Close()
+		return nil, fmt.Errorf("unexpected sftp packet %d during handshake", typ)
+	}
+	return client, nil
+}
+
+func (c *sftpClient) Close() error {
+	c.in.Close()
+	return c.session.Close()
+}
+
+// send writes a single packet; fields may be uint32, uint64, string or []byte
+func (c *sftpClient) send(typ byte, fields ...any) error {
+	payload := []byte{typ}
+	for _, field := range fields {
+		switch v := field.(type) {
+		case uint32:
+			payload = binary.BigEndian.AppendUint32(payload, v)
+		case uint64:
+			payload = binary.BigEndian.AppendUint64(payload, v)
+		case string:
+			payload = binary.BigEndian.AppendUint32(payload, uint32(len(v)))
+			payload = append(payload, v...)
+		case []byte:
+			payload = binary.BigEndian.AppendUint32(payload, uint32(len(v)))
+			payload = append(payload, v...)
+		default:
+			return fmt.Errorf("unsupported sftp field type %T", field)
+		}
+	}
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	_, err := c.in.Write(append(packet, payload...))
+	return err
+}
+
+func (c *sftpClient) recv() (byte, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(c.out, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length == 0 || length > 1<<24 {
+		return 0, nil, fmt.Errorf("invalid sftp packet length %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.out, data); err != nil {
+		return 0, nil, err
+	}
+	return data[0], data[1:], nil
+}
+
+// request sends a packet carrying a fresh request id and waits for its reply
+func (c *sftpClient) request(typ byte, fields ...any) (byte, []byte, error) {
+	c.nextID++
+	if err := c.send(typ, append([]any{c.nextID}, fields...)...); err != nil {
+		return 0, nil, err
+	}
+	replyType, data, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != c.nextID {
+		return 0, nil, errors.New("unexpected sftp reply id")
+	}
+	return replyType, data[4:], nil
+}
+
+func parseSFTPStatus(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("malformed sftp status packet")
+	}
+	code := binary.BigEndian.Uint32(data)
+	if code == sftpStatusOK {
+		return nil
+	}
+	message, _ := sftpString(data[4:])
+	return &sftpStatusError{Code: code, Message: string(message)}
+}
+
+func sftpString(data []byte) ([]byte, []byte) {
+	if len(data) < 4 {
+		return nil, nil
+	}
+	length := binary.BigEndian.Uint32(data)
+	if uint32(len(data)-4) < length {
+		return nil, nil
+	}
+	return data[4 : 4+length], data[4+length:]
+}
+
+// expectStatus turns a reply into an error unless it is an OK status
+func expectStatus(typ byte, data []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	if typ != sftpStatus {
+		return fmt.Errorf("unexpected sftp packet %d", typ)
+	}
+	return parseSFTPStatus(data)
+}
+
+func (c *sftpClient) stat(p string) error {
+	typ, data, err := c.request(sftpStat, p)
+	if err != nil {
+		return err
+	}
+	if typ == sftpAttrs {
+		return nil
+	}
+	return expectStatus(typ, data, nil)
+}
+
+// MkdirAll creates the directory and any missing parents
+func (c *sftpClient) MkdirAll(dir string) error {
+	if dir == "" || dir == "." || dir == "/" {
+		return nil
+	}
+	err := c.stat(dir)
+	if err == nil {
+		return nil
+	}
+	var status *sftpStatusError
+	if !errors.As(err, &status) || status.Code != sftpStatusNoSuchFile {
+		return err
+	}
+	if err := c.MkdirAll(path.Dir(dir)); err != nil {
+		return err
+	}
+	return expectStatus(c.request(sftpMkdir, dir, uint32(0)))
+}
+
+func (c *sftpClient) open(p string, flags uint32) (string, error) {
+	typ, data, err := c.request(sftpOpen, p, flags, uint32(0))
+	if err != nil {
+		return "", err
+	}
+	if typ != sftpHandle {
+		return "", expectStatus(typ, data, nil)
+	}
+	handle, _ := sftpString(data)
+	return string(handle), nil
+}
+
+func (c *sftpClient) close(handle string) error {
+	return expectStatus(c.request(sftpClose, handle))
+}
+
+// WriteFile copies the local file to the remote path, keeping several
+// write requests in flight to hide network latency
+func (c *sftpClient) WriteFile(remotePath string, local *os.File) error {
+	handle, err := c.open(remotePath, sftpFlagWrite|sftpFlagCreat|sftpFlagTrunc)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", remotePath, err)
+	}
+
+	var offset uint64
+	outstanding := 0
+	buf := make([]byte, sftpChunkSize)
+	writeErr := func() error {
+		for {
+			n, readErr := local.Read(buf)
+			if n > 0 {
+				c.nextID++
+				if err := c.send(sftpWrite, c.nextID, handle, offset, buf[:n]); err != nil {
+					return err
+				}
+				offset += uint64(n)
+				outstanding++
+			}
+			for outstanding >= sftpMaxOutstanding || (readErr != nil && outstanding > 0) {
+				typ, data, err := c.recv()
+				if err == nil && len(data) >= 4 {
+					data = data[4:]
+				}
+				if err := expectStatus(typ, data, err); err != nil {
+					return err
+				}
+				outstanding--
+			}
+			if readErr == io.EOF {
+				return nil
+			}
+			if readErr != nil {
+				return readErr
+			}
+		}
+	}()
+
+	if closeErr := c.close(handle); writeErr == nil {
+		writeErr = closeErr
+	}
+	return writeErr
+}
+
+// sftpEntry is a directory entry returned by ReadDir
+type sftpEntry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// parseSFTPAttrs decodes an ATTRS structure into the entry and returns
+// the remaining bytes
+func parseSFTPAttrs(data []byte, entry *sftpEntry) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errors.New("malformed sftp attributes")
+	}
+	flags := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	next := func(n int) ([]byte, error) {
+		if len(data) < n {
+			return nil, errors.New("malformed sftp attributes")
+		}
+		field := data[:n]
+		data = data[n:]
+		return field, nil
+	}
+
+	if flags&sftpAttrSize != 0 {
+		field, err := next(8)
+		if err != nil {
+			return nil, err
+		}
+		entry.Size = int64(binary.BigEndian.Uint64(field))
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		if _, err := next(8); err != nil {
+			return nil, err
+		}
+	}
+	if flags&sftpAttrPermissions != 0 {
+		field, err := next(4)
+		if err != nil {
+			return nil, err
+		}
+		entry.IsDir = binary.BigEndian.Uint32(field)&sftpModeType == sftpModeDir
+	}
+	if flags&sftpAttrTimes != 0 {
+		field, err := next(8)
+		if err != nil {
+			return nil, err
+		}
+		entry.ModTime = time.Unix(int64(binary.BigEndian.Uint32(field[4:])), 0)
+	}
+	if flags&sftpAttrExtended != 0 {
+		field, err := next(4)
+		if err != nil {
+			return nil, err
+		}
+		for i := binary.BigEndian.Uint32(field); i > 0; i-- {
+			for j := 0; j < 2; j++ {
+				value, rest := sftpString(data)
+				if value == nil && rest == nil {
+					return nil, errors.New("malformed sftp attributes")
+				}
+				data = rest
+			}
+		}
+	}
+	return data, nil
+}
+
+// ReadDir lists the entries of a directory, skipping . and ..
+func (c *sftpClient) ReadDir(dir string) ([]sftpEntry, error) {
+	typ, data, err := c.request(sftpOpendir, dir)
+	if err != nil {
+		return nil, err
+	}
+	if typ != sftpHandle {
+		return nil, expectStatus(typ, data, nil)
+	}
+	rawHandle, _ := sftpString(data)
+	handle := string(rawHandle)
+	defer c.close(handle)
+
+	var entries []sftpEntry
+	for {
+		typ, data, err := c.request(sftpReaddir, handle)
+		if err != nil {
+			return nil, err
+		}
+		if typ == sftpStatus {
+			var status *sftpStatusError
+			if err := parseSFTPStatus(data); errors.As(err, &status) && status.Code == sftpStatusEOF {
+				return entries, nil
+			} else if err != nil {
+				return nil, err
+			}
+			return nil, errors.New("unexpected sftp status while reading directory")
+		}
+		if typ != sftpName || len(data) < 4 {
+			return nil, fmt.Errorf("unexpected sftp packet %d", typ)
+		}
+
+		count := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		for i := uint32(0); i < count; i++ {
+			name, rest := sftpString(data)
+			_, rest = sftpString(rest)
+			if rest == nil {
+				return nil, errors.New("malformed sftp name packet")
+			}
+			entry := sftpEntry{Name: string(name)}
+			if data, err = parseSFTPAttrs(rest, &entry); err != nil {
+				return nil, err
+			}
+			if entry.Name != "." && entry.Name != ".." {
+				entries = append(entries, entry)
+			}
+		}
+	}
+}
+
+// Remove deletes a file
+func (c *sftpClient) Remove(p string) error {
+	return expectStatus(c.request(sftpRemove, p))
+}
+
+// sftpReader streams a remote file with sequential READ requests
+type sftpReader struct {
+	client *sftpClient
+	handle string
+	offset uint64
+	eof    bool
+}
+
+// OpenReader opens a remote file for reading; closing the reader only
+// closes the remote handle
+func (c *sftpClient) OpenReader(p string) (*sftpReader, error) {
+	handle, err := c.open(p, sftpFlagRead)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpReader{client: c, handle: handle}, nil
+}
+
+func (r *sftpReader) Read(p []byte) (int, error) {
+	if r.eof {
+		return 0, io.EOF
+	}
+	if len(p) > sftpChunkSize {
+		p = p[:sftpChunkSize]
+	}
+	typ, data, err := r.client.request(sftpRead, r.handle, r.offset, uint32(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	if typ == sftpStatus {
+		var status *sftpStatusError
+		if err := parseSFTPStatus(data); errors.As(err, &status) && status.Code == sftpStatusEOF {
+			r.eof = true
+			return 0, io.EOF
+		} else if err != nil {
+			return 0, err
+		}
+		return 0, errors.New("unexpected sftp status while reading")
+	}
+	if typ != sftpData {
+		return 0, fmt.Errorf("unexpected sftp packet %d", typ)
+	}
+	chunk, _ := sftpString(data)
+	n := copy(p, chunk)
+	r.offset += uint64(n)
+	return n, nil
+}
+
+func (r *sftpReader) Close() error {
+	return r.client.close(r.handle)
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSFTPAttrs(t *testing.T) {
+	u32 := func(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+	u64 := func(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+	str := func(s string) []byte { return append(u32(uint32(len(s))), s...) }
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	tests := []struct {
+		name  string
+		data  []byte
+		entry sftpEntry
+		rest  string
+		err   bool
+	}{
+		{
+			name: "no attributes",
+			data: join(u32(0), []byte("rest")),
+			rest: "rest",
+		},
+		{
+			name:  "size",
+			data:  join(u32(sftpAttrSize), u64(1<<33)),
+			entry: sftpEntry{Size: 1 << 33},
+		},
+		{
+			name: "every attribute",
+			data: join(u32(sftpAttrSize|sftpAttrUIDGID|sftpAttrPermissions|sftpAttrTimes|sftpAttrExtended),
+				u64(42), u32(1000), u32(1000), u32(0o100644), u32(1), u32(1700000000),
+				u32(1), str("name"), str("value"), []byte("rest")),
+			entry: sftpEntry{Size: 42, ModTime: time.Unix(1700000000, 0)},
+			rest:  "rest",
+		},
+		{
+			name:  "directory",
+			data:  join(u32(sftpAttrPermissions), u32(0o040755)),
+			entry: sftpEntry{IsDir: true},
+		},
+		{
+			name: "truncated size",
+			data: join(u32(sftpAttrSize), u32(1)),
+			err:  true,
+		},
+		{
+			name: "truncated extension",
+			data: join(u32(sftpAttrExtended), u32(1), str("name")),
+			err:  true,
+		},
+		{
+			name: "empty",
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var entry sftpEntry
+			rest, err := parseSFTPAttrs(test.data, &entry)
+			if test.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry != test.entry || string(rest) != test.rest {
+				t.Errorf("got %+v and %q, want %+v and %q", entry, rest, test.entry, test.rest)
+			}
+		})
+	}
+}
+
+func TestParseSFTPStatus(t *testing.T) {
+	status := func(code uint32, message string) []byte {
+		data := binary.BigEndian.AppendUint32(nil, code)
+		data = binary.BigEndian.AppendUint32(data, uint32(len(message)))
+		return append(data, message...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"ok", status(sftpStatusOK, ""), nil},
+		{"no such file", status(sftpStatusNoSuchFile, "No such file"), &sftpStatusError{Code: sftpStatusNoSuchFile, Message: "No such file"}},
+		{"no message", binary.BigEndian.AppendUint32(nil, sftpStatusEOF), &sftpStatusError{Code: sftpStatusEOF}},
+		{"malformed", []byte{0, 0}, errors.New("malformed sftp status packet")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := parseSFTPStatus(test.data)
+			if fmt.Sprint(err) != fmt.Sprint(test.err) {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+// fakeSFTPServer answers the requests of an sftpClient from memory
+type fakeSFTPServer struct {
+	files   map[string][]byte
+	dirs    map[string]bool
+	handles map[string]string
+	listed  map[string]bool
+	next    int
+}
+
+// newFakeSFTP connects a client to a fake server holding only the root
+// directory
+func newFakeSFTP(t *testing.T) (*sftpClient, *fakeSFTPServer) {
+	t.Helper()
+	requests, requestWriter := io.Pipe()
+	replyReader, replies := io.Pipe()
+	server := &fakeSFTPServer{files: map[string][]byte{}, dirs: map[string]bool{"/": true}, handles: map[string]string{}, listed: map[string]bool{}}
+
+	// replies are queued, as the client sends several writes before it
+	// reads their replies
+	queue := make(chan []byte, 1024)
+	go func() {
+		for reply := range queue {
+			replies.Write(reply)
+		}
+		replies.Close()
+	}()
+	go func() {
+		defer close(queue)
+		reader := &sftpClient{out: requests}
+		for {
+			typ, data, err := reader.recv()
+			if err != nil {
+				return
+			}
+			var reply bytes.Buffer
+			writer := &sftpClient{in: nopWriteCloser{&reply}}
+			server.handle(writer, typ, data)
+			queue <- reply.Bytes()
+		}
+	}()
+
+	client := &sftpClient{in: requestWriter, out: replyReader}
+	t.Cleanup(func() { requestWriter.Close() })
+	return client, server
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func (s *fakeSFTPServer) handle(reply *sftpClient, typ byte, data []byte) {
+	id := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	str := func() string {
+		value, rest := sftpString(data)
+		data = rest
+		return string(value)
+	}
+	u32 := func() uint32 {
+		value := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		return value
+	}
+	u64 := func() uint64 {
+		value := binary.BigEndian.Uint64(data)
+		data = data[8:]
+		return value
+	}
+	status := func(code uint32) {
+		reply.send(sftpStatus, id, code, "", "")
+	}
+	newHandle := func(p string) {
+		s.next++
+		handle := fmt.Sprintf("h%d", s.next)
+		s.handles[handle] = p
+		reply.send(sftpHandle, id, handle)
+	}
+
+	switch typ {
+	case sftpStat:
+		p := str()
+		if _, ok := s.files[p]; ok || s.dirs[p] {
+			reply.send(sftpAttrs, id, uint32(0))
+			return
+		}
+		status(sftpStatusNoSuchFile)
+	case sftpMkdir:
+		p := str()
+		if !s.dirs[path.Dir(p)] {
+			status(sftpStatusNoSuchFile)
+			return
+		}
+		s.dirs[p] = true
+		status(sftpStatusOK)
+	case sftpOpen:
+		p, flags := str(), u32()
+		if flags&sftpFlagTrunc != 0 {
+			s.files[p] = nil
+		} else if _, ok := s.files[p]; !ok {
+			status(sftpStatusNoSuchFile)
+			return
+		}
+		newHandle(p)
+	case sftpWrite:
+		p, offset, chunk := s.handles[str()], u64(), []byte(str())
+		file := s.files[p]
+		if end := int(offset) + len(chunk); end > len(file) {
+			file = append(file, make([]byte, end-len(file))...)
+		}
+		copy(file[offset:], chunk)
+		s.files[p] = file
+		status(sftpStatusOK)
+	case sftpRead:
+		p, offset, length := s.handles[str()], u64(), u32()
+		file := s.files[p]
+		if int(offset) >= len(file) {
+			status(sftpStatusEOF)
+			return
+		}
+		end := min(int(offset)+int(length), len(file))
+		reply.send(sftpData, id, file[offset:end])
+	case sftpOpendir:
+		p := str()
+		if !s.dirs[p] {
+			status(sftpStatusNoSuchFile)
+			return
+		}
+		newHandle(p)
+	case sftpReaddir:
+		handle := str()
+		if s.listed[handle] {
+			status(sftpStatusEOF)
+			return
+		}
+		s.listed[handle] = true
+		dir := s.handles[handle]
+		fields := []any{id, uint32(0), ".", ".", uint32(0)}
+		count := uint32(1)
+		for p, file := range s.files {
+			if path.Dir(p) == dir {
+				count++
+				fields = append(fields, path.Base(p), "", uint32(sftpAttrSize|sftpAttrTimes), uint64(len(file)), uint32(0), uint32(1700000000))
+			}
+		}
+		for p := range s.dirs {
+			if p != "/" && path.Dir(p) == dir {
+				count++
+				fields = append(fields, path.Base(p), "", uint32(sftpAttrPermissions), uint32(0o040755))
+			}
+		}
+		fields[1] = count
+		reply.send(sftpName, fields...)
+	case sftpClose:
+		delete(s.handles, str())
+		status(sftpStatusOK)
+	case sftpRemove:
+		p := str()
+		if _, ok := s.files[p]; !ok {
+			status(sftpStatusNoSuchFile)
+			return
+		}
+		delete(s.files, p)
+		status(sftpStatusOK)
+	default:
+		status(8)
+	}
+}
+
+func TestSFTPClient(t *testing.T) {
+	client, server := newFakeSFTP(t)
+
+	if err := client.MkdirAll("/backups/db"); err != nil {
+		t.Fatal(err)
+	}
+	if !server.dirs["/backups"] || !server.dirs["/backups/db"] {
+		t.Fatalf("got directories %v", server.dirs)
+	}
+
+	// large enough to keep more writes in flight than the client waits for
+	content := bytes.Repeat([]byte("0123456789abcdef"), sftpChunkSize*sftpMaxOutstanding/8)
+	local := filepath.Join(t.TempDir(), "dump.sql")
+	os.WriteFile(local, content, 0o600)
+	file, _ := os.Open(local)
+	defer file.Close()
+	if err := client.WriteFile("/backups/db/dump.sql", file); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(server.files["/backups/db/dump.sql"], content) {
+		t.Fatalf("stored %d bytes, want %d", len(server.files["/backups/db/dump.sql"]), len(content))
+	}
+	if len(server.handles) != 0 {
+		t.Errorf("handles left open: %v", server.handles)
+	}
+
+	entries, err := client.ReadDir("/backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "db" || !entries[0].IsDir {
+		t.Errorf("got entries %+v", entries)
+	}
+	entries, _ = client.ReadDir("/backups/db")
+	if len(entries) != 1 || entries[0].Size != int64(len(content)) || entries[0].ModTime.Unix() != 1700000000 {
+		t.Errorf("got entries %+v", entries)
+	}
+
+	reader, err := client.OpenReader("/backups/db/dump.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(read, content) {
+		t.Errorf("read back %d bytes, %v", len(read), err)
+	}
+
+	if err := client.Remove("/backups/db/dump.sql"); err != nil {
+		t.Fatal(err)
+	}
+	var status *sftpStatusError
+	if err := client.Remove("/backups/db/dump.sql"); !errors.As(err, &status) || status.Code != sftpStatusNoSuchFile {
+		t.Errorf("removing a missing file returned %v", err)
+	}
+	if _, err := client.OpenReader("/missing"); err == nil || !strings.Contains(err.Error(), "sftp status 2") {
+		t.Errorf("opening a missing file returned %v", err)
+	}
+	if len(server.files) != 0 {
+		t.Errorf("files left: %v", server.files)
+	}
+}
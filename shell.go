@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// shellArgs returns the command line that runs a script with the shell:
+// sh or bash, PowerShell as pwsh or powershell, or cmd
+func shellArgs(shell, script string) []string {
+	switch shell {
+	case "":
+		return shellArgs(defaultShell, script)
+	case "pwsh", "powershell":
+		return []string{shell, "-NoProfile", "-NonInteractive", "-Command", script}
+	case "cmd":
+		return []string{"cmd", "/C", script}
+	default:
+		return []string{shell, "-c", script}
+	}
+}
+
+// cmdLine is the command line cmd runs a script with. cmd doesn't follow
+// the quoting rules arguments are escaped with on Windows, so the script is
+// passed as is; /S has cmd strip the outer quotes and leave the rest alone
+func cmdLine(script string) string {
+	return `cmd /S /C "` + script + `"`
+}
+
+// joinScript joins the lines of a script into one for the shell. cmd only
+// runs the first line of what it is given, so its lines are chained with
+// &, which runs them one after the other like the other shells do
+func joinScript(shell string, lines []string) string {
+	if shell == "cmd" || shell == "" && defaultShell == "cmd" {
+		return strings.Join(lines, " & ")
+	}
+	return strings.Join(lines, " \n")
+}
+
+func validateShell(shell string) error {
+	switch shell {
+	case "", "sh", "bash", "pwsh", "powershell", "cmd":
+		return nil
+	default:
+		return fmt.Errorf("unknown shell %q, expected sh, bash, pwsh, powershell or cmd", shell)
+	}
+}
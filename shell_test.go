@@ -0,0 +1,41 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestShellArgs(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  []string
+	}{
+		{"bash", []string{"bash", "-c", `echo "a b"`}},
+		{"sh", []string{"sh", "-c", `echo "a b"`}},
+		{"pwsh", []string{"pwsh", "-NoProfile", "-NonInteractive", "-Command", `echo "a b"`}},
+		{"cmd", []string{"cmd", "/C", `echo "a b"`}},
+		{"", shellArgs(defaultShell, `echo "a b"`)},
+	}
+	for _, test := range tests {
+		if got := shellArgs(test.shell, `echo "a b"`); !slices.Equal(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.shell, got, test.want)
+		}
+	}
+}
+
+func TestCmdLine(t *testing.T) {
+	// cmd gets the quotes of the script as they are
+	if got := cmdLine(`copy "C:\a b.txt" "%TEMP%\x" & echo done`); got != `cmd /S /C "copy "C:\a b.txt" "%TEMP%\x" & echo done"` {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestJoinScript(t *testing.T) {
+	lines := []string{"pg_dump app > app.sql", "gzip app.sql"}
+	if got := joinScript("cmd", lines); got != "pg_dump app > app.sql & gzip app.sql" {
+		t.Errorf("cmd: got %q", got)
+	}
+	if got := joinScript("bash", lines); got != "pg_dump app > app.sql \ngzip app.sql" {
+		t.Errorf("bash: got %q", got)
+	}
+}
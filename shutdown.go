@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// shutdownGrace is how long cancelled runs get to wind down, e.g. to kill
+// their scripts, once the drain timeout is over
+const shutdownGrace = 30 * time.Second
+
+// errShutdown fails the runs cancelled as the scheduler shuts down
+var errShutdown = errors.New("cancelled on shutdown")
+
+// inFlight holds the runs in progress, which the scheduler lets finish
+// before it exits
+var inFlight = newRunGroup()
+
+// runGroup tracks runs so they can be drained on shutdown
+type runGroup struct {
+	// ctx is the parent of every run's context, cancelled when the runs
+	// outlast the drain timeout
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	draining bool
+}
+
+func newRunGroup() *runGroup {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	return &runGroup{ctx: ctx, cancel: cancel}
+}
+
+// enter registers a run and returns its context and a function to call
+// once it is over. It returns false when the scheduler is shutting down
+func (g *runGroup) enter() (context.Context, func(), bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining {
+		return nil, nil, false
+	}
+	g.wg.Add(1)
+	return g.ctx, g.wg.Done, true
+}
+
+// drain keeps new runs from starting and waits up to timeout for the ones
+// in progress to finish. Runs still going after that are cancelled
+func (g *runGroup) drain(timeout time.Duration) {
+	g.mu.Lock()
+	g.draining = true
+	g.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	default:
+		slog.Info("Waiting for backup tasks in progress to finish", slog.Duration("timeout", timeout))
+	}
+
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+	slog.Warn("Cancelling backup tasks still in progress after the shutdown timeout")
+	g.cancel(errShutdown)
+	select {
+	case <-done:
+	case <-time.After(shutdownGrace):
+		slog.Error("Backup tasks did not stop after being cancelled")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SizeCheck compares the size of each run's artifacts with the previous
+// runs found in storage. A dump a fraction of its usual size is almost
+// always a broken backup
+type SizeCheck struct {
+	// MaxDeviation is how far, in percent, the size may differ from the
+	// median of the previous runs
+	MaxDeviation percent `yaml:"max_deviation"`
+	// Runs is how many previous runs the median is taken over, 5 by default
+	Runs int `yaml:"runs"`
+	// Action is "warn" (the default) to only log and notify, or "fail" to
+	// fail the run, which also skips retention so older backups are kept
+	Action string `yaml:"action"`
+}
+
+// percent is written as "50%" or 50 in the configuration file
+type percent float64
+
+func (p *percent) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value.Value, "%")), 64)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid percentage %q", value.Line, value.Value)
+	}
+	*p = percent(parsed)
+	return nil
+}
+
+func (check SizeCheck) validate() error {
+	if check.MaxDeviation <= 0 {
+		return fmt.Errorf("size_check max_deviation must be positive")
+	}
+	if check.Runs < 0 {
+		return fmt.Errorf("size_check runs must not be negative")
+	}
+	if check.Action != "" && check.Action != "warn" && check.Action != "fail" {
+		return fmt.Errorf("unknown size_check action %q, expected warn or fail", check.Action)
+	}
+	return nil
+}
+
+// previousRunSizes returns the total size of the artifacts of the task's
+// runs in the storage other than the current one, newest first. Like the
+// run's own size, it leaves out checksums, split manifests and run logs
+func previousRunSizes(ctx context.Context, storage Storage, taskName, currentID string, limit int) ([]int64, error) {
+	backups, err := backupsOf(ctx, storage, taskName)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	objects := map[string]map[string]bool{}
+	for _, backup := range backups {
+		_, id, _ := parseFileName(backup.Name)
+		if id == currentID {
+			continue
+		}
+		if _, ok := objects[id]; !ok {
+			ids = append(ids, id)
+			objects[id] = map[string]bool{}
+		}
+		objects[id][backup.Name] = true
+	}
+	sizes := map[string]int64{}
+	for _, backup := range backups {
+		_, id, _ := parseFileName(backup.Name)
+		if id != currentID && !isSidecar(backup.Name, objects[id]) {
+			sizes[id] += backup.Size
+		}
+	}
+
+	var result []int64
+	for _, id := range ids {
+		if len(result) == limit {
+			break
+		}
+		result = append(result, sizes[id])
+	}
+	return result, nil
+}
+
+// isSidecar tells whether an object accompanies an artifact of its run,
+// such as its checksum, split manifest or the run's log, rather than
+// holding backup data, given the objects of the run
+func isSidecar(objectName string, objects map[string]bool) bool {
+	if artifact, ok := strings.CutSuffix(objectName, ".sha256"); ok && objects[artifact] {
+		return true
+	}
+	if artifact, ok := strings.CutSuffix(objectName, ".manifest.json"); ok && objects[partName(artifact, 0)] {
+		return true
+	}
+	return isRunLog(objectName, objects)
+}
+
+func median(values []int64) float64 {
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return float64(sorted[middle-1]+sorted[middle]) / 2
+	}
+	return float64(sorted[middle])
+}
+
+// checkSize compares the run's size with the previous runs in the first
+// destination that received it. Anomalies are logged and added to the
+// report as a warning, or returned as an error with action fail
+func (task BackupTask) checkSize(ctx context.Context, report *RunReport, destinations []Destination, logger *slog.Logger) error {
+	check := task.SizeCheck
+	if check == nil || len(destinations) == 0 {
+		return nil
+	}
+	runs := check.Runs
+	if runs == 0 {
+		runs = 5
+	}
+
+	sizes, err := previousRunSizes(ctx, destinations[0].Storage, task.Name, report.BackupID, runs)
+	if err != nil {
+		logger.Warn("Failed to list previous backups for the size check", slog.String("error", err.Error()))
+		return nil
+	}
+	if len(sizes) == 0 {
+		return nil
+	}
+	usual := median(sizes)
+	if usual == 0 {
+		return nil
+	}
+	deviation := math.Abs(float64(report.Size)-usual) / usual * 100
+	if deviation <= float64(check.MaxDeviation) {
+		return nil
+	}
+
+	message := fmt.Sprintf("artifact size %s differs by %.0f%% from the usual %s", ByteSize(report.Size), deviation, ByteSize(int64(usual)))
+	if check.Action == "fail" {
+		return fmt.Errorf("size check failed: %s", message)
+	}
+	logger.Warn("Backup size is unusual", slog.String("warning", message))
+	report.Warnings = append(report.Warnings, message)
+	return nil
+}
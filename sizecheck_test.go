@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreviousRunSizes(t *testing.T) {
+	storage := newFakeStorage()
+	now := time.Now()
+	store := func(name string, size int, age time.Duration) {
+		storage.objects[name] = []byte(strings.Repeat("x", size))
+		storage.modified[name] = now.Add(-age)
+	}
+	// a plain run with its checksum and log
+	store("2024_01_01_01_00_00_00-db-aaaaaaaa.sql.gz", 1000, 3*time.Hour)
+	store("2024_01_01_01_00_00_00-db-aaaaaaaa.sql.gz.sha256", 90, 3*time.Hour)
+	store("2024_01_01_01_00_00_00-db-aaaaaaaa.sql.gz.log", 5000, 3*time.Hour)
+	// a split run with its manifest
+	store("2024_01_02_02_00_00_00-db-bbbbbbbb.sql.gz.part0001", 600, 2*time.Hour)
+	store("2024_01_02_02_00_00_00-db-bbbbbbbb.sql.gz.part0002", 400, 2*time.Hour)
+	store("2024_01_02_02_00_00_00-db-bbbbbbbb.sql.gz.manifest.json", 300, 2*time.Hour)
+	// a run with two artifacts
+	store("2024_01_03_03_00_00_00-db-cccccccc.sql.gz", 700, time.Hour)
+	store("2024_01_03_03_00_00_00-db-cccccccc.tar.gz", 500, time.Hour)
+	// the current run and another task's
+	store("2024_01_04_04_00_00_00-db-dddddddd.sql.gz", 9999, 0)
+	store("2024_01_04_04_00_00_00-web-eeeeeeee.tar.gz", 9999, 0)
+
+	sizes, err := previousRunSizes(context.Background(), storage, "db", "dddddddd", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{1200, 1000, 1000}; !slices.Equal(sizes, want) {
+		t.Errorf("got %v, want %v", sizes, want)
+	}
+
+	sizes, _ = previousRunSizes(context.Background(), storage, "db", "dddddddd", 2)
+	if want := []int64{1200, 1000}; !slices.Equal(sizes, want) {
+		t.Errorf("with a limit of 2, got %v, want %v", sizes, want)
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		values []int64
+		want   float64
+	}{
+		{[]int64{5}, 5},
+		{[]int64{3, 1, 2}, 2},
+		{[]int64{4, 1, 3, 2}, 2.5},
+	}
+	for _, test := range tests {
+		if got := median(test.values); got != test.want {
+			t.Errorf("median(%v) = %v, want %v", test.values, got, test.want)
+		}
+	}
+}
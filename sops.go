@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// isSOPSEncrypted tells whether the configuration file was encrypted with
+// SOPS, which leaves its metadata in a top-level sops key
+func isSOPSEncrypted(data []byte) bool {
+	var document struct {
+		SOPS map[string]any `yaml:"sops"`
+	}
+	return yaml.Unmarshal(data, &document) == nil && document.SOPS["mac"] != nil
+}
+
+// decryptSOPS returns the decrypted configuration. The sops binary finds
+// the keys on its own, an age key in SOPS_AGE_KEY_FILE or cloud KMS
+// credentials from the environment. The format is "yaml" or "json". The
+// encrypted data is handed over in a temporary file, as sops can't read
+// stdin on every platform
+func decryptSOPS(data []byte, format string) ([]byte, error) {
+	file, err := os.CreateTemp("", "config-*."+format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a temporary file for sops: %s", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write a temporary file for sops: %s", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sops", "--decrypt", "--input-type", format, "--output-type", format, file.Name())
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("sops decryption failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SplitConfig uploads artifacts larger than PartSize as separate parts,
+// plus a manifest that lists them, so huge dumps don't depend on a single
+// multi-hour upload succeeding
+type SplitConfig struct {
+	PartSize ByteSize `yaml:"part_size"`
+	// Parallelism is the number of parts prepared and uploaded at once;
+	// each needs PartSize of scratch disk space
+	Parallelism int `yaml:"parallelism"`
+}
+
+func (cfg SplitConfig) validate() error {
+	if cfg.PartSize < 1<<20 {
+		return fmt.Errorf("split part_size must be at least 1MiB")
+	}
+	if cfg.Parallelism < 0 {
+		return fmt.Errorf("split parallelism must not be negative")
+	}
+	return nil
+}
+
+// splitManifest is stored next to the parts as "<object>.manifest.json"
+// and describes how to put the artifact back together
+type splitManifest struct {
+	Object    string      `json:"object"`
+	Size      int64       `json:"size"`
+	PartSize  int64       `json:"part_size"`
+	CreatedAt time.Time   `json:"created_at"`
+	Parts     []splitPart `json:"parts"`
+}
+
+type splitPart struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func manifestName(objectName string) string {
+	return objectName + ".manifest.json"
+}
+
+func partName(objectName string, index int) string {
+	return fmt.Sprintf("%s.part%04d", objectName, index+1)
+}
+
+// upload sends the artifact to every destination in parts and finishes with
+// the manifest. Artifacts no larger than a part are uploaded as usual. It
+// returns the destinations that received every part and the manifest
+func (cfg SplitConfig) upload(ctx context.Context, destinations []Destination, objectName, filePath, workDir string, opts PutOptions, logger *slog.Logger) []Destination {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		logger.Error("Failed to read the backup file", slog.String("error", err.Error()))
+		return nil
+	}
+	partSize := int64(cfg.PartSize)
+	if info.Size() <= partSize {
+		return uploadFile(ctx, destinations, objectName, filePath, opts, logger)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		logger.Error("Failed to read the backup file", slog.String("error", err.Error()))
+		return nil
+	}
+	defer file.Close()
+
+	parallelism := cfg.Parallelism
+	if parallelism == 0 {
+		parallelism = 4
+	}
+	manifest := splitManifest{
+		Object:    objectName,
+		Size:      info.Size(),
+		PartSize:  partSize,
+		CreatedAt: time.Now().UTC(),
+		Parts:     make([]splitPart, (info.Size()+partSize-1)/partSize),
+	}
+	logger.Info("Uploading the file in parts", slog.Int("parts", len(manifest.Parts)), slog.String("part_size", cfg.PartSize.String()))
+
+	partOptions := opts
+	partOptions.ContentType = "application/octet-stream"
+
+	var mu sync.Mutex
+	failed := map[string]bool{}
+	healthy := func() []Destination {
+		mu.Lock()
+		defer mu.Unlock()
+		var remaining []Destination
+		for _, destination := range destinations {
+			if !failed[destination.Name] {
+				remaining = append(remaining, destination)
+			}
+		}
+		return remaining
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				part, err := cfg.writePart(file, objectName, index, workDir, &manifest.Parts[index])
+				if err != nil {
+					logger.Error("Failed to prepare a part of the backup file", slog.Int("part", index+1), slog.String("error", err.Error()))
+					mu.Lock()
+					for _, destination := range destinations {
+						failed[destination.Name] = true
+					}
+					mu.Unlock()
+					continue
+				}
+				name := manifest.Parts[index].Name
+				for _, destination := range healthy() {
+					if err := destination.Storage.Put(ctx, name, part, partOptions); err != nil {
+						logger.Error("Failed to upload a part of the backup file", slog.String("destination", destination.Name),
+							slog.String("object", name), slog.String("error", err.Error()))
+						mu.Lock()
+						failed[destination.Name] = true
+						mu.Unlock()
+					}
+				}
+				os.Remove(part)
+			}
+		}()
+	}
+	for index := range manifest.Parts {
+		if len(healthy()) == 0 || ctx.Err() != nil {
+			break
+		}
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	remaining := healthy()
+	if err := ctx.Err(); err != nil {
+		logger.Error("Failed to upload the file in parts", slog.String("error", err.Error()))
+		return nil
+	}
+	if len(remaining) == 0 {
+		logger.Error("Failed to upload the file to any destination")
+		return nil
+	}
+
+	manifestPath := filepath.Join(workDir, filepath.Base(manifestName(objectName)))
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		err = os.WriteFile(manifestPath, data, 0o600)
+	}
+	if err != nil {
+		logger.Error("Failed to write the backup manifest", slog.String("error", err.Error()))
+		return nil
+	}
+	defer os.Remove(manifestPath)
+
+	manifestOptions := opts
+	manifestOptions.ContentType = "application/json"
+	return uploadFile(ctx, remaining, manifestName(objectName), manifestPath, manifestOptions, logger)
+}
+
+// writePart copies one part of the file into its own scratch file and
+// records its name, position and checksum
+func (cfg SplitConfig) writePart(file *os.File, objectName string, index int, workDir string, info *splitPart) (string, error) {
+	part, err := os.CreateTemp(workDir, fmt.Sprintf("part%04d-*", index+1))
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	offset := int64(index) * int64(cfg.PartSize)
+	size, err := io.Copy(io.MultiWriter(part, hash), io.NewSectionReader(file, offset, int64(cfg.PartSize)))
+	if err != nil {
+		part.Close()
+		os.Remove(part.Name())
+		return "", err
+	}
+	*info = splitPart{
+		Name:   partName(objectName, index),
+		Offset: offset,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}
+	return part.Name(), part.Close()
+}
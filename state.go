@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// schedulerState persists when each job last ran, so runs missed while the
+// process was down can be caught up on after a restart
+type schedulerState struct {
+	path string
+
+	mu       sync.Mutex
+	LastRuns map[string]time.Time `json:"last_runs"`
+}
+
+// state is nil unless STATE_FILE is set
+var state *schedulerState
+
+func loadSchedulerState(path string) (*schedulerState, error) {
+	if path == "" {
+		return nil, nil
+	}
+	s := &schedulerState{path: path, LastRuns: map[string]time.Time{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *schedulerState) lastRun(name string) (time.Time, bool) {
+	if s == nil {
+		return time.Time{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, ok := s.LastRuns[name]
+	return last, ok
+}
+
+// recordRun stores the start of a run
+func (s *schedulerState) recordRun(name string, started time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LastRuns[name] = started
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.path, append(data, '\n'))
+	}
+	if err != nil {
+		slog.Warn("Failed to write the scheduler state", slog.String("path", s.path), slog.String("error", err.Error()))
+	}
+}
+
+// missedRun tells whether the task was due to run after its last recorded
+// run and before now
+func (task BackupTask) missedRun(last, now time.Time) (bool, error) {
+	if task.Every > 0 {
+		return last.Add(time.Duration(task.Every)).Before(now), nil
+	}
+	schedule, err := task.cronSchedule()
+	if err != nil {
+		return false, err
+	}
+	return schedule.Next(last).Before(now), nil
+}
+
+// writeFileAtomic replaces the file through a rename, so readers never see
+// a partial one
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
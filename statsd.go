@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// statsdClient sends per-run metrics to a StatsD server over UDP. With
+// Datadog tags the job is sent as a "job" tag, plain StatsD has no tags so
+// the job name becomes part of the metric name instead
+type statsdClient struct {
+	conn    net.Conn
+	prefix  string
+	tags    []string
+	datadog bool
+}
+
+// statsd is nil unless STATSD_ADDRESS is set
+var statsd *statsdClient
+
+func newStatsdClient(address, prefix, tags string, datadog bool) (*statsdClient, error) {
+	if address == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to StatsD: %s", err)
+	}
+	client := &statsdClient{conn: conn, prefix: prefix, datadog: datadog}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			client.tags = append(client.tags, tag)
+		}
+	}
+	return client, nil
+}
+
+var statsdUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// record sends the run's duration, uploaded bytes and outcome
+func (c *statsdClient) record(report *RunReport) error {
+	if c == nil {
+		return nil
+	}
+	outcome := "success"
+	if !report.Succeeded {
+		outcome = "failure"
+	}
+	lines := []string{
+		c.line(report.Task, "run.duration", fmt.Sprintf("%d|ms", report.Duration.Milliseconds())),
+		c.line(report.Task, "run."+outcome, "1|c"),
+	}
+	if report.Size > 0 {
+		lines = append(lines, c.line(report.Task, "bytes_uploaded", fmt.Sprintf("%d|c", report.Size)))
+	}
+	// one packet per metric keeps each well below common MTUs
+	for _, line := range lines {
+		if _, err := c.conn.Write([]byte(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *statsdClient) line(job, name, value string) string {
+	if !c.datadog {
+		return fmt.Sprintf("%s%s.%s:%s", c.prefix, statsdUnsafe.ReplaceAllString(job, "_"), name, value)
+	}
+	tags := append([]string{"job:" + job}, c.tags...)
+	return fmt.Sprintf("%s%s:%s|#%s", c.prefix, name, value, strings.Join(tags, ","))
+}
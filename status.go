@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+)
+
+// jobStatus is the state of one job as written to the status file
+type jobStatus struct {
+	Running     bool       `json:"running"`
+	LastStart   *time.Time `json:"last_start,omitempty"`
+	LastEnd     *time.Time `json:"last_end,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	NextRun     *time.Time `json:"next_run,omitempty"`
+	// Artifacts are the object names of the last successful run
+	Artifacts []string `json:"artifacts,omitempty"`
+
+	jobs []gocron.Job
+}
+
+// statusFile keeps a JSON document describing every job up to date at a
+// path, for health checks and dashboards that don't talk to an API
+type statusFile struct {
+	path string
+
+	mu   sync.Mutex
+	jobs map[string]*jobStatus
+}
+
+// runStatus is nil unless STATUS_FILE is set
+var runStatus *statusFile
+
+func newStatusFile(path string) *statusFile {
+	if path == "" {
+		return nil
+	}
+	return &statusFile{path: path, jobs: map[string]*jobStatus{}}
+}
+
+// track adds a task with the scheduler's jobs for its schedules, whose
+// next run is read from the scheduler
+func (s *statusFile) track(name string, jobs []gocron.Job) {
+	if s == nil {
+		return
+	}
+	s.update(name, func(status *jobStatus) { status.jobs = jobs })
+}
+
+// untrack removes a task that is no longer scheduled
+func (s *statusFile) untrack(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, name)
+	if err := s.write(); err != nil {
+		slog.Warn("Failed to write the status file", slog.String("path", s.path), slog.String("error", err.Error()))
+	}
+}
+
+func (s *statusFile) started(report *RunReport) {
+	if s == nil {
+		return
+	}
+	s.update(report.Task, func(status *jobStatus) {
+		started := report.Started
+		status.Running = true
+		status.LastStart = &started
+	})
+}
+
+func (s *statusFile) finished(report *RunReport) {
+	if s == nil {
+		return
+	}
+	s.update(report.Task, func(status *jobStatus) {
+		ended := report.Started.Add(report.Duration)
+		status.Running = false
+		status.LastEnd = &ended
+		if report.Succeeded {
+			status.LastSuccess = &ended
+			status.LastError = ""
+			status.Artifacts = report.Objects
+		} else {
+			status.LastError = report.Error
+		}
+	})
+}
+
+// update changes a job's status and rewrites the file
+func (s *statusFile) update(name string, change func(*jobStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.jobs[name]
+	if !ok {
+		status = &jobStatus{}
+		s.jobs[name] = status
+	}
+	change(status)
+	for _, status := range s.jobs {
+		status.NextRun = nil
+		if next, ok := nextRun(status.jobs); ok {
+			status.NextRun = &next
+		}
+	}
+
+	if err := s.write(); err != nil {
+		slog.Warn("Failed to write the status file", slog.String("path", s.path), slog.String("error", err.Error()))
+	}
+}
+
+// write renders the document; the caller holds mu
+func (s *statusFile) write() error {
+	data, err := json.MarshalIndent(map[string]any{
+		"updated": time.Now(),
+		"jobs":    s.jobs,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, append(data, '\n'))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Storage is a backend that backup artifacts are kept in. Implementations
+// address artifacts by object name relative to their configured root
+type Storage interface {
+	// Put stores the local file under the object name
+	Put(ctx context.Context, objectName, filePath string, opts PutOptions) error
+	// List returns every object whose name starts with the prefix
+	List(ctx context.Context, prefix string) ([]StoredObject, error)
+	// Delete removes the object
+	Delete(ctx context.Context, objectName string) error
+	// Get opens the object for reading; the caller must close it
+	Get(ctx context.Context, objectName string) (io.ReadCloser, error)
+}
+
+// StreamPutter is implemented by storages that can store an object of
+// unknown size directly from a reader, without a local file
+type StreamPutter interface {
+	PutStream(ctx context.Context, objectName string, r io.Reader, opts PutOptions) error
+}
+
+// Locator is implemented by storages that can tell where an object lives,
+// as a URL or remote path suitable for people and notifications
+type Locator interface {
+	Location(objectName string) string
+}
+
+// Presigner is implemented by storages that can hand out a temporary
+// download link for an object that works without credentials
+type Presigner interface {
+	PresignGet(ctx context.Context, objectName string, expiry time.Duration) (string, error)
+}
+
+// maxPresignExpiry is the longest expiry S3 and GCS accept for signed URLs
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// PutOptions carries per-upload settings; backends ignore the ones they
+// have no equivalent for
+type PutOptions struct {
+	ContentType string
+	// StorageClass is the S3 or GCS storage class, or the Azure access tier
+	StorageClass string
+	// LockMode is "governance" or "compliance" when the object must be
+	// protected from deletion until RetainUntil
+	LockMode    string
+	RetainUntil time.Time
+	// PartSize is the multipart chunk size used for streamed uploads,
+	// whose total size isn't known upfront
+	PartSize int64
+}
+
+// ObjectLock protects uploaded backups from being deleted or overwritten
+// for a while, even by credentials that are otherwise allowed to
+type ObjectLock struct {
+	Mode      string   `yaml:"mode"`
+	RetainFor Duration `yaml:"retain_for"`
+}
+
+func (lock ObjectLock) validate() error {
+	if lock.Mode != "governance" && lock.Mode != "compliance" {
+		return fmt.Errorf("object lock mode must be governance or compliance, got %q", lock.Mode)
+	}
+	if lock.RetainFor <= 0 {
+		return fmt.Errorf("object lock retain_for must be positive")
+	}
+	return nil
+}
+
+// StoredObject describes an artifact kept in a storage backend
+type StoredObject struct {
+	Name         string
+	Size         int64
+	LastModified time.Time
+	StorageClass string
+}
+
+// Destination is a named storage backend that a task uploads its artifact to
+type Destination struct {
+	Name    string
+	Storage Storage
+}
+
+// resolveDestinations returns where the task's artifacts go: the destinations list
+// when present, otherwise its storage override or the global storage
+func (task BackupTask) resolveDestinations(global Storage) ([]Destination, error) {
+	if len(task.Destinations) == 0 {
+		if task.Storage == nil {
+			return []Destination{{Name: "default", Storage: global}}, nil
+		}
+		storage, err := newStorage(context.Background(), task.Storage.inherit(defaultStorageDetails))
+		if err != nil {
+			return nil, err
+		}
+		return []Destination{{Name: task.Storage.DisplayName(0), Storage: storage}}, nil
+	}
+
+	destinations := make([]Destination, 0, len(task.Destinations))
+	for i, details := range task.Destinations {
+		storage, err := newStorage(context.Background(), details.inherit(defaultStorageDetails))
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %s", details.DisplayName(i), err)
+		}
+		destinations = append(destinations, Destination{Name: details.DisplayName(i), Storage: storage})
+	}
+	return destinations, nil
+}
+
+// DisplayName identifies the storage in logs, falling back to its type
+// and position when no explicit name was configured
+func (details StorageDetails) DisplayName(index int) string {
+	if details.Name != "" {
+		return details.Name
+	}
+	kind := details.Kind
+	if kind == "" {
+		kind = "s3"
+	}
+	return fmt.Sprintf("%s-%d", strings.ToLower(kind), index)
+}
+
+func newStorage(ctx context.Context, details StorageDetails) (Storage, error) {
+	storage, err := newBackend(ctx, details)
+	if err != nil {
+		return nil, err
+	}
+	return withPrefix(storage, details.Prefix), nil
+}
+
+func newBackend(ctx context.Context, details StorageDetails) (Storage, error) {
+	switch strings.ToLower(details.Kind) {
+	case "", "s3":
+		return newS3Storage(ctx, details)
+	case "gcs":
+		return newGCSStorage(details)
+	case "azure":
+		return newAzureStorage(details)
+	case "sftp":
+		return newSFTPStorage(details)
+	case "local":
+		return newLocalStorage(details)
+	case "plugin":
+		return newPluginStorage(details)
+	case "rclone":
+		return newRcloneStorage(details)
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", details.Kind)
+	}
+}
+
+// requireSettings returns an error naming every environment variable
+// in the list whose value is empty
+func requireSettings(settings ...[2]string) error {
+	var missing []string
+	for _, setting := range settings {
+		if setting[1] == "" {
+			missing = append(missing, setting[0])
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required settings: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
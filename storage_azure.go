@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	azureAPIVersion = "2021-08-06"
+	azureBlockSize  = 16 << 20
+	azureIMDSURL    = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+type azureStorage struct {
+	containerURL    string
+	sasToken        url.Values
+	managedIdentity bool
+	clientID        string
+	client          *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func newAzureStorage(details StorageDetails) (*azureStorage, error) {
+	if err := requireSettings(
+		[2]string{"AZURE_STORAGE_ACCOUNT", details.AzureAccount},
+		[2]string{"AZURE_CONTAINER", details.AzureContainer},
+	); err != nil {
+		return nil, err
+	}
+	if details.AzureSASToken == "" && !details.AzureManagedIdentity {
+		return nil, fmt.Errorf("either AZURE_SAS_TOKEN or AZURE_USE_MANAGED_IDENTITY must be set")
+	}
+
+	endpoint := details.AzureEndpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", details.AzureAccount)
+	}
+
+	storage := &azureStorage{
+		containerURL:    strings.TrimRight(endpoint, "/") + "/" + url.PathEscape(details.AzureContainer),
+		managedIdentity: details.AzureManagedIdentity,
+		clientID:        details.AzureClientID,
+		client:          &http.Client{},
+	}
+	if details.AzureSASToken != "" {
+		sas, err := url.ParseQuery(strings.TrimPrefix(details.AzureSASToken, "?"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse AZURE_SAS_TOKEN: %s", err)
+		}
+		storage.sasToken = sas
+	}
+	return storage, nil
+}
+
+// Put stages the file as a sequence of blocks and commits them as a
+// single block blob, which keeps memory usage bounded for large artifacts
+func (a *azureStorage) Put(ctx context.Context, objectName, filePath string, opts PutOptions) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var blockIDs []string
+	buf := make([]byte, azureBlockSize)
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(blockIDs))))
+			query := url.Values{"comp": {"block"}, "blockid": {blockID}}
+			resp, err := a.do(ctx, http.MethodPut, objectName, query, bytes.NewReader(buf[:n]), int64(n), nil)
+			if err != nil {
+				return fmt.Errorf("failed to upload block %d: %s", len(blockIDs), err)
+			}
+			resp.Body.Close()
+			blockIDs = append(blockIDs, blockID)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	blockList := struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: blockIDs}
+	body, err := xml.Marshal(blockList)
+	if err != nil {
+		return err
+	}
+	headers := http.Header{}
+	headers.Set("x-ms-blob-content-type", opts.ContentType)
+	if opts.StorageClass != "" {
+		headers.Set("x-ms-access-tier", opts.StorageClass)
+	}
+	if opts.LockMode != "" {
+		// a locked immutability policy can't be shortened, like S3's compliance mode
+		mode := "Unlocked"
+		if opts.LockMode == "compliance" {
+			mode = "Locked"
+		}
+		headers.Set("x-ms-immutability-policy-mode", mode)
+		headers.Set("x-ms-immutability-policy-until-date", opts.RetainUntil.UTC().Format(http.TimeFormat))
+	}
+	resp, err := a.do(ctx, http.MethodPut, objectName, url.Values{"comp": {"blocklist"}}, bytes.NewReader(body), int64(len(body)), headers)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (a *azureStorage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	var objects []StoredObject
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := a.do(ctx, http.MethodGet, "", query, nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Blobs []struct {
+				Name       string `xml:"Name"`
+				Properties struct {
+					LastModified  string `xml:"Last-Modified"`
+					ContentLength int64  `xml:"Content-Length"`
+					AccessTier    string `xml:"AccessTier"`
+				} `xml:"Properties"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode Azure blob listing: %s", err)
+		}
+
+		for _, blob := range page.Blobs {
+			lastModified, _ := http.ParseTime(blob.Properties.LastModified)
+			objects = append(objects, StoredObject{
+				Name:         blob.Name,
+				Size:         blob.Properties.ContentLength,
+				LastModified: lastModified,
+				StorageClass: blob.Properties.AccessTier,
+			})
+		}
+		if page.NextMarker == "" {
+			return objects, nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+func (a *azureStorage) Delete(ctx context.Context, objectName string) error {
+	resp, err := a.do(ctx, http.MethodDelete, objectName, url.Values{}, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// SetStorageClass changes the blob's access tier in place
+func (a *azureStorage) SetStorageClass(ctx context.Context, objectName, storageClass string) error {
+	headers := http.Header{}
+	headers.Set("x-ms-access-tier", storageClass)
+	resp, err := a.do(ctx, http.MethodPut, objectName, url.Values{"comp": {"tier"}}, nil, 0, headers)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (a *azureStorage) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	resp, err := a.do(ctx, http.MethodGet, objectName, url.Values{}, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// do sends an authorized request against the container, or a blob in it,
+// and returns the response when the service answers with a 2xx status;
+// the caller must close its body
+func (a *azureStorage) do(ctx context.Context, method, blobName string, query url.Values, body io.Reader, size int64, headers http.Header) (*http.Response, error) {
+	target := a.containerURL
+	if blobName != "" {
+		segments := strings.Split(blobName, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		target += "/" + strings.Join(segments, "/")
+	}
+	for key, values := range a.sasToken {
+		query[key] = values
+	}
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	if a.managedIdentity {
+		token, err := a.accessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Azure request failed with status %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
+
+// accessToken returns a cached managed identity token, asking the
+// instance metadata service for a new one shortly before it expires
+func (a *azureStorage) accessToken(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Now().Before(a.tokenExpiry.Add(-time.Minute)) {
+		return a.token, nil
+	}
+
+	query := url.Values{
+		"api-version": {"2018-02-01"},
+		"resource":    {"https://storage.azure.com/"},
+	}
+	if a.clientID != "" {
+		query.Set("client_id", a.clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, azureIMDSURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch managed identity token: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to fetch managed identity token: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode managed identity token: %s", err)
+	}
+	expiresOn, err := strconv.ParseInt(result.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse managed identity token expiry: %s", err)
+	}
+
+	a.token = result.AccessToken
+	a.tokenExpiry = time.Unix(expiresOn, 0)
+	return a.token, nil
+}
+
+func (a *azureStorage) Location(objectName string) string {
+	return a.containerURL + "/" + objectName
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewAzureStorage(t *testing.T) {
+	tests := []struct {
+		name         string
+		details      StorageDetails
+		containerURL string
+		err          string
+	}{
+		{
+			name:         "sas token",
+			details:      StorageDetails{AzureAccount: "acct", AzureContainer: "backups", AzureSASToken: "?sv=2021&sig=abc%2B"},
+			containerURL: "https://acct.blob.core.windows.net/backups",
+		},
+		{
+			name:         "custom endpoint",
+			details:      StorageDetails{AzureAccount: "acct", AzureContainer: "backups", AzureManagedIdentity: true, AzureEndpoint: "http://127.0.0.1:10000/acct/"},
+			containerURL: "http://127.0.0.1:10000/acct/backups",
+		},
+		{
+			name:    "no credentials",
+			details: StorageDetails{AzureAccount: "acct", AzureContainer: "backups"},
+			err:     "either AZURE_SAS_TOKEN or AZURE_USE_MANAGED_IDENTITY",
+		},
+		{
+			name:    "no container",
+			details: StorageDetails{AzureAccount: "acct", AzureSASToken: "sig=abc"},
+			err:     "AZURE_CONTAINER",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, err := newAzureStorage(test.details)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v, want an error about %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if storage.containerURL != test.containerURL {
+				t.Errorf("got container URL %q, want %q", storage.containerURL, test.containerURL)
+			}
+		})
+	}
+}
+
+// azureRequest is what the fake Blob service saw of a request
+type azureRequest struct {
+	method, path string
+	query        url.Values
+	header       http.Header
+	body         string
+}
+
+func fakeAzure(t *testing.T, respond func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, *[]azureRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []azureRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, azureRequest{r.Method, r.URL.EscapedPath(), r.URL.Query(), r.Header, string(body)})
+		mu.Unlock()
+		if respond != nil {
+			respond(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestAzurePutWithSASToken(t *testing.T) {
+	server, requests := fakeAzure(t, nil)
+	storage, err := newAzureStorage(StorageDetails{AzureAccount: "acct", AzureContainer: "backups", AzureSASToken: "?sv=2021&sig=abc%2B", AzureEndpoint: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact := filepath.Join(t.TempDir(), "dump.sql")
+	os.WriteFile(artifact, []byte("dump"), 0o600)
+
+	err = storage.Put(context.Background(), "team a/dump #1.sql", artifact, PutOptions{ContentType: "application/sql", StorageClass: "Cool"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 2 {
+		t.Fatalf("got %d requests, want a block and the block list", len(*requests))
+	}
+	block, commit := (*requests)[0], (*requests)[1]
+	blockID := base64.StdEncoding.EncodeToString([]byte("00000000"))
+
+	for _, request := range *requests {
+		if request.path != "/backups/team%20a/dump%20%231.sql" {
+			t.Errorf("got path %s", request.path)
+		}
+		if request.query.Get("sig") != "abc+" || request.query.Get("sv") != "2021" {
+			t.Errorf("SAS token missing from %v", request.query)
+		}
+		if request.header.Get("x-ms-version") != azureAPIVersion || request.header.Get("x-ms-date") == "" {
+			t.Errorf("got headers %v", request.header)
+		}
+		if request.header.Get("Authorization") != "" {
+			t.Error("a SAS request carries an Authorization header")
+		}
+	}
+	if block.query.Get("comp") != "block" || block.query.Get("blockid") != blockID || block.body != "dump" {
+		t.Errorf("got block %+v", block)
+	}
+	if commit.query.Get("comp") != "blocklist" || !strings.Contains(commit.body, "<Latest>"+blockID+"</Latest>") {
+		t.Errorf("got block list %+v", commit)
+	}
+	if commit.header.Get("x-ms-access-tier") != "Cool" || commit.header.Get("x-ms-blob-content-type") != "application/sql" {
+		t.Errorf("got block list headers %v", commit.header)
+	}
+}
+
+func TestAzureListPages(t *testing.T) {
+	server, _ := fakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("marker") == "" {
+			fmt.Fprint(w, `<EnumerationResults><Blobs><Blob><Name>a.sql</Name><Properties><Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified><Content-Length>3</Content-Length><AccessTier>Hot</AccessTier></Properties></Blob></Blobs><NextMarker>next</NextMarker></EnumerationResults>`)
+			return
+		}
+		fmt.Fprint(w, `<EnumerationResults><Blobs><Blob><Name>b.sql</Name><Properties><Content-Length>5</Content-Length><AccessTier>Cool</AccessTier></Properties></Blob></Blobs><NextMarker/></EnumerationResults>`)
+	})
+	storage, _ := newAzureStorage(StorageDetails{AzureAccount: "acct", AzureContainer: "backups", AzureSASToken: "sig=abc", AzureEndpoint: server.URL})
+
+	objects, err := storage.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Size != 3 || objects[1].StorageClass != "Cool" {
+		t.Fatalf("got %+v", objects)
+	}
+	if want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC); !objects[0].LastModified.Equal(want) {
+		t.Errorf("got last modified %s", objects[0].LastModified)
+	}
+}
+
+func TestAzureManagedIdentity(t *testing.T) {
+	tokens := 0
+	server, requests := fakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metadata/identity/oauth2/token" {
+			tokens++
+			if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("client_id") != "client" {
+				http.Error(w, "bad token request", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"access_token": "token", "expires_on": "%d"}`, time.Now().Add(time.Hour).Unix())
+		}
+	})
+	target, _ := url.Parse(server.URL)
+	storage, _ := newAzureStorage(StorageDetails{AzureAccount: "acct", AzureContainer: "backups", AzureManagedIdentity: true, AzureClientID: "client"})
+	storage.client = &http.Client{Transport: redirectTransport{target}}
+
+	for range 2 {
+		if err := storage.Delete(context.Background(), "a.sql"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tokens != 1 {
+		t.Errorf("fetched %d tokens, want 1", tokens)
+	}
+	last := (*requests)[len(*requests)-1]
+	if last.method != http.MethodDelete || last.header.Get("Authorization") != "Bearer token" {
+		t.Errorf("got %+v", last)
+	}
+}
+
+func TestAzureRequestFailure(t *testing.T) {
+	server, _ := fakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "AuthenticationFailed", http.StatusForbidden)
+	})
+	storage, _ := newAzureStorage(StorageDetails{AzureAccount: "acct", AzureContainer: "backups", AzureSASToken: "sig=abc", AzureEndpoint: server.URL})
+
+	_, err := storage.Get(context.Background(), "a.sql")
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "AuthenticationFailed") {
+		t.Errorf("got %v", err)
+	}
+}
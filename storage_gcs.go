@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcsScope     = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsUploadURL = "https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=multipart"
+	gcsObjectURL = "https://storage.googleapis.com/storage/v1/b/%s/o"
+)
+
+// gcsServiceAccount is the subset of a service account key file needed
+// to obtain OAuth2 access tokens
+type gcsServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type gcsStorage struct {
+	bucket  string
+	account gcsServiceAccount
+	key     *rsa.PrivateKey
+	client  *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func newGCSStorage(details StorageDetails) (*gcsStorage, error) {
+	credentialsFile := details.GCSCredentialsFile
+	if credentialsFile == "" {
+		credentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if err := requireSettings(
+		[2]string{"GCS_BUCKET", details.GCSBucket},
+		[2]string{"GCS_CREDENTIALS_FILE", credentialsFile},
+	); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GCS credentials: %s", err)
+	}
+	var account gcsServiceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("failed to parse GCS credentials: %s", err)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("GCS credentials do not contain a PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GCS private key: %s", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("GCS private key is not an RSA key")
+	}
+
+	return &gcsStorage{
+		bucket:  details.GCSBucket,
+		account: account,
+		key:     key,
+		client:  &http.Client{},
+	}, nil
+}
+
+// Put sends the object's metadata and content in a single multipart
+// request, which is the only simple upload that can set a storage class
+func (g *gcsStorage) Put(ctx context.Context, objectName, filePath string, opts PutOptions) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]any{"name": objectName, "contentType": opts.ContentType}
+	if opts.StorageClass != "" {
+		fields["storageClass"] = opts.StorageClass
+	}
+	if opts.LockMode != "" {
+		mode := "Unlocked"
+		if opts.LockMode == "compliance" {
+			mode = "Locked"
+		}
+		fields["retention"] = map[string]string{
+			"mode":            mode,
+			"retainUntilTime": opts.RetainUntil.UTC().Format(time.RFC3339),
+		}
+	}
+	metadata, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	boundary := "backup-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	head := "--" + boundary + "\r\nContent-Type: application/json; charset=UTF-8\r\n\r\n" + string(metadata) +
+		"\r\n--" + boundary + "\r\nContent-Type: " + opts.ContentType + "\r\n\r\n"
+	tail := "\r\n--" + boundary + "--\r\n"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf(gcsUploadURL, url.PathEscape(g.bucket)),
+		io.MultiReader(strings.NewReader(head), file, strings.NewReader(tail)))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(head)) + info.Size() + int64(len(tail))
+	req.Header.Set("Content-Type", "multipart/related; boundary="+boundary)
+
+	resp, err := g.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (g *gcsStorage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	var objects []StoredObject
+	pageToken := ""
+	for {
+		query := url.Values{"prefix": {prefix}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			fmt.Sprintf(gcsObjectURL, url.PathEscape(g.bucket))+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := g.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Items []struct {
+				Name         string    `json:"name"`
+				Size         string    `json:"size"`
+				Updated      time.Time `json:"updated"`
+				StorageClass string    `json:"storageClass"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode GCS object listing: %s", err)
+		}
+
+		for _, item := range page.Items {
+			size, _ := strconv.ParseInt(item.Size, 10, 64)
+			objects = append(objects, StoredObject{
+				Name:         item.Name,
+				Size:         size,
+				LastModified: item.Updated,
+				StorageClass: item.StorageClass,
+			})
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func (g *gcsStorage) Delete(ctx context.Context, objectName string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, g.objectURL(objectName), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := g.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (g *gcsStorage) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.objectURL(objectName)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// SetStorageClass rewrites the object in place; large objects may need
+// several rewrite calls, each continuing where the previous one stopped
+func (g *gcsStorage) SetStorageClass(ctx context.Context, objectName, storageClass string) error {
+	body, err := json.Marshal(map[string]string{"storageClass": storageClass})
+	if err != nil {
+		return err
+	}
+	rewriteURL := g.objectURL(objectName) + "/rewriteTo/b/" + url.PathEscape(g.bucket) + "/o/" + url.PathEscape(objectName)
+
+	token := ""
+	for {
+		target := rewriteURL
+		if token != "" {
+			target += "?rewriteToken=" + url.QueryEscape(token)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := g.do(req)
+		if err != nil {
+			return err
+		}
+
+		var result struct {
+			Done         bool   `json:"done"`
+			RewriteToken string `json:"rewriteToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to decode GCS rewrite response: %s", err)
+		}
+		if result.Done {
+			return nil
+		}
+		token = result.RewriteToken
+	}
+}
+
+func (g *gcsStorage) objectURL(objectName string) string {
+	return fmt.Sprintf(gcsObjectURL, url.PathEscape(g.bucket)) + "/" + url.PathEscape(objectName)
+}
+
+// do authorizes and sends the request, returning the response when the
+// API answers with a 2xx status; the caller must close its body
+func (g *gcsStorage) do(req *http.Request) (*http.Response, error) {
+	token, err := g.accessToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GCS request failed with status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// accessToken returns a cached OAuth2 token, exchanging a freshly signed
+// JWT assertion for a new one shortly before the current token expires
+func (g *gcsStorage) accessToken(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.token != "" && time.Now().Before(g.tokenExpiry.Add(-time.Minute)) {
+		return g.token, nil
+	}
+
+	assertion, err := g.signAssertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch GCS access token: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to fetch GCS access token: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode GCS access token: %s", err)
+	}
+
+	g.token = result.AccessToken
+	g.tokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	return g.token, nil
+}
+
+func (g *gcsStorage) signAssertion(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   g.account.ClientEmail,
+		"scope": gcsScope,
+		"aud":   g.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, g.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign GCS token assertion: %s", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (g *gcsStorage) Location(objectName string) string {
+	return "gs://" + g.bucket + "/" + objectName
+}
+
+// PresignGet builds a V4 signed URL with the service account key, see
+// https://cloud.google.com/storage/docs/access-control/signing-urls-manually
+func (g *gcsStorage) PresignGet(ctx context.Context, objectName string, expiry time.Duration) (string, error) {
+	now := time.Now().UTC()
+	timestamp := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/auto/storage/goog4_request"
+	path := (&url.URL{Path: "/" + g.bucket + "/" + objectName}).EscapedPath()
+
+	query := url.Values{
+		"X-Goog-Algorithm":     {"GOOG4-RSA-SHA256"},
+		"X-Goog-Credential":    {g.account.ClientEmail + "/" + scope},
+		"X-Goog-Date":          {timestamp},
+		"X-Goog-Expires":       {strconv.Itoa(int(expiry.Seconds()))},
+		"X-Goog-SignedHeaders": {"host"},
+	}
+	canonicalQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet, path, canonicalQuery, "host:storage.googleapis.com\n", "host", "UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestDigest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"GOOG4-RSA-SHA256", timestamp, scope, hex.EncodeToString(requestDigest[:])}, "\n")
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, g.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign GCS URL: %s", err)
+	}
+	return "https://storage.googleapis.com" + path + "?" + canonicalQuery + "&X-Goog-Signature=" + hex.EncodeToString(signature), nil
+}
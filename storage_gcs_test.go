@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redirectTransport sends every request to the test server instead of
+// the host it was made for
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writeGCSCredentials(t *testing.T, privateKey string) string {
+	t.Helper()
+	data, _ := json.Marshal(map[string]string{"client_email": "backup@project.iam.gserviceaccount.com", "private_key": privateKey})
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	os.WriteFile(file, data, 0o600)
+	return file
+}
+
+func TestNewGCSStorage(t *testing.T) {
+	key := testRSAKey(t)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(key)
+	pkcs1 := x509.MarshalPKCS1PrivateKey(key)
+
+	tests := []struct {
+		name       string
+		bucket     string
+		privateKey string
+		err        string
+	}{
+		{"pkcs8 key", "backups", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})), ""},
+		{"pkcs1 key", "backups", string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: pkcs1})), "failed to parse GCS private key"},
+		{"no pem", "backups", "not a key", "do not contain a PEM private key"},
+		{"no bucket", "", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})), "GCS_BUCKET"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, err := newGCSStorage(StorageDetails{GCSBucket: test.bucket, GCSCredentialsFile: writeGCSCredentials(t, test.privateKey)})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v, want an error about %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if storage.account.TokenURI != "https://oauth2.googleapis.com/token" {
+				t.Errorf("token URI defaulted to %q", storage.account.TokenURI)
+			}
+		})
+	}
+}
+
+func TestGCSSignAssertion(t *testing.T) {
+	key := testRSAKey(t)
+	storage := &gcsStorage{key: key, account: gcsServiceAccount{ClientEmail: "backup@project.iam.gserviceaccount.com", TokenURI: "https://oauth2.googleapis.com/token"}}
+	now := time.Unix(1700000000, 0)
+
+	assertion, err := storage.signAssertion(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		t.Fatalf("got %d parts, want 3", len(parts))
+	}
+	var header map[string]string
+	var claims map[string]any
+	decode := func(part string, v any) {
+		data, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decode(parts[0], &header)
+	decode(parts[1], &claims)
+	if header["alg"] != "RS256" || header["typ"] != "JWT" {
+		t.Errorf("got header %v", header)
+	}
+	want := map[string]any{
+		"iss":   "backup@project.iam.gserviceaccount.com",
+		"scope": gcsScope,
+		"aud":   "https://oauth2.googleapis.com/token",
+		"iat":   float64(1700000000),
+		"exp":   float64(1700003600),
+	}
+	for claim, value := range want {
+		if claims[claim] != value {
+			t.Errorf("claim %s = %v, want %v", claim, claims[claim], value)
+		}
+	}
+
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("signature doesn't verify: %s", err)
+	}
+}
+
+func TestGCSAccessTokenIsCached(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || strings.Count(r.Form.Get("assertion"), ".") != 2 {
+			http.Error(w, "bad grant", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token": "token-%d", "expires_in": 3600}`, requests)
+	}))
+	defer server.Close()
+	storage := &gcsStorage{key: testRSAKey(t), account: gcsServiceAccount{TokenURI: server.URL}, client: server.Client()}
+
+	for range 2 {
+		token, err := storage.accessToken(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token-1" {
+			t.Errorf("got %q, want the first token", token)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("fetched %d tokens, want 1", requests)
+	}
+
+	// a token about to expire is replaced
+	storage.tokenExpiry = time.Now().Add(30 * time.Second)
+	if token, _ := storage.accessToken(context.Background()); token != "token-2" {
+		t.Errorf("got %q, want a fresh token", token)
+	}
+}
+
+func TestGCSPutAndList(t *testing.T) {
+	var uploaded string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/backups/o":
+			body, _ := io.ReadAll(r.Body)
+			uploaded = string(body)
+		case r.Method == http.MethodGet && r.URL.Query().Get("pageToken") == "":
+			fmt.Fprint(w, `{"items": [{"name": "a.sql", "size": "3", "storageClass": "STANDARD"}], "nextPageToken": "next"}`)
+		case r.Method == http.MethodGet:
+			fmt.Fprint(w, `{"items": [{"name": "b.sql", "size": "5", "storageClass": "NEARLINE"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+	storage := &gcsStorage{
+		bucket:      "backups",
+		client:      &http.Client{Transport: redirectTransport{target}},
+		token:       "token",
+		tokenExpiry: time.Now().Add(time.Hour),
+	}
+
+	artifact := filepath.Join(t.TempDir(), "a.sql")
+	os.WriteFile(artifact, []byte("dump"), 0o600)
+	if err := storage.Put(context.Background(), "a.sql", artifact, PutOptions{ContentType: "application/sql", StorageClass: "NEARLINE"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{`"name":"a.sql"`, `"storageClass":"NEARLINE"`, "Content-Type: application/sql\r\n\r\ndump\r\n"} {
+		if !strings.Contains(uploaded, part) {
+			t.Errorf("upload is missing %q:\n%s", part, uploaded)
+		}
+	}
+
+	objects, err := storage.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Name != "a.sql" || objects[1].Size != 5 || objects[1].StorageClass != "NEARLINE" {
+		t.Errorf("got %+v", objects)
+	}
+}
+
+func TestGCSPresignGet(t *testing.T) {
+	key := testRSAKey(t)
+	storage := &gcsStorage{bucket: "backups", key: key, account: gcsServiceAccount{ClientEmail: "backup@project.iam.gserviceaccount.com"}}
+
+	tests := []struct {
+		object string
+		path   string
+	}{
+		{"dump.sql", "/backups/dump.sql"},
+		{"team a/dump #1.sql", "/backups/team%20a/dump%20%231.sql"},
+	}
+	for _, test := range tests {
+		t.Run(test.object, func(t *testing.T) {
+			signed, err := storage.PresignGet(context.Background(), test.object, 15*time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := url.Parse(signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Host != "storage.googleapis.com" || parsed.EscapedPath() != test.path {
+				t.Errorf("got %s", signed)
+			}
+			query := parsed.Query()
+			scope := strings.TrimPrefix(query.Get("X-Goog-Credential"), "backup@project.iam.gserviceaccount.com/")
+			if query.Get("X-Goog-Algorithm") != "GOOG4-RSA-SHA256" || query.Get("X-Goog-Expires") != "900" || !strings.HasSuffix(scope, "/auto/storage/goog4_request") {
+				t.Errorf("got query %v", query)
+			}
+
+			// the signature covers the canonical request without itself
+			canonicalQuery, signature, _ := strings.Cut(parsed.RawQuery, "&X-Goog-Signature=")
+			canonicalRequest := strings.Join([]string{"GET", test.path, canonicalQuery, "host:storage.googleapis.com\n", "host", "UNSIGNED-PAYLOAD"}, "\n")
+			requestDigest := sha256.Sum256([]byte(canonicalRequest))
+			stringToSign := strings.Join([]string{"GOOG4-RSA-SHA256", query.Get("X-Goog-Date"), scope, hex.EncodeToString(requestDigest[:])}, "\n")
+			digest := sha256.Sum256([]byte(stringToSign))
+			decoded, err := hex.DecodeString(signature)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], decoded); err != nil {
+				t.Errorf("signature doesn't verify: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type localStorage struct {
+	directory string
+}
+
+func newLocalStorage(details StorageDetails) (*localStorage, error) {
+	if err := requireSettings([2]string{"LOCAL_DIRECTORY", details.LocalDirectory}); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(details.LocalDirectory, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create local storage directory: %s", err)
+	}
+	return &localStorage{directory: details.LocalDirectory}, nil
+}
+
+// Put copies the artifact next to its final name first and renames it
+// into place, so a half-written copy is never mistaken for a backup
+func (l *localStorage) Put(ctx context.Context, objectName, filePath string, opts PutOptions) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return l.PutStream(ctx, objectName, src, opts)
+}
+
+func (l *localStorage) PutStream(ctx context.Context, objectName string, src io.Reader, opts PutOptions) error {
+	target := filepath.Join(l.directory, filepath.FromSlash(objectName))
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return err
+	}
+
+	dst, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+
+	if _, err := io.Copy(dst, readerWithContext(ctx, src)); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Rename(dst.Name(), target)
+}
+
+func (l *localStorage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	var objects []StoredObject
+	err := filepath.WalkDir(l.directory, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.directory, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, StoredObject{Name: name, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+func (l *localStorage) Delete(ctx context.Context, objectName string) error {
+	return os.Remove(filepath.Join(l.directory, filepath.FromSlash(objectName)))
+}
+
+func (l *localStorage) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.directory, filepath.FromSlash(objectName)))
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// readerWithContext stops a long copy as soon as the context is cancelled
+func readerWithContext(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+func (l *localStorage) Location(objectName string) string {
+	target, err := filepath.Abs(filepath.Join(l.directory, filepath.FromSlash(objectName)))
+	if err != nil {
+		return ""
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(target)}).String()
+}
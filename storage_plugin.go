@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// pluginRequest is written as JSON to the plugin's stdin, one request per
+// invocation
+type pluginRequest struct {
+	Action       string            `json:"action"`
+	Object       string            `json:"object,omitempty"`
+	Prefix       string            `json:"prefix,omitempty"`
+	File         string            `json:"file,omitempty"`
+	ContentType  string            `json:"content_type,omitempty"`
+	StorageClass string            `json:"storage_class,omitempty"`
+	Options      map[string]string `json:"options,omitempty"`
+}
+
+// pluginResponse is read as JSON from the plugin's stdout
+type pluginResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Objects []struct {
+		Name         string    `json:"name"`
+		Size         int64     `json:"size"`
+		LastModified time.Time `json:"last_modified"`
+	} `json:"objects"`
+}
+
+// pluginStorage delegates every operation to an external executable, so
+// teams can add backends without forking the tool
+type pluginStorage struct {
+	command []string
+	options map[string]string
+}
+
+func newPluginStorage(details StorageDetails) (*pluginStorage, error) {
+	if err := requireSettings([2]string{"PLUGIN_COMMAND", details.PluginCommand}); err != nil {
+		return nil, err
+	}
+	command := strings.Fields(details.PluginCommand)
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return nil, fmt.Errorf("storage plugin not found: %s", err)
+	}
+	return &pluginStorage{command: command, options: details.PluginOptions}, nil
+}
+
+func (p *pluginStorage) call(ctx context.Context, request pluginRequest) (*pluginResponse, error) {
+	request.Options = p.options
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.command[0], p.command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	var response pluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("storage plugin failed: %s: %s", runErr, strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("failed to decode storage plugin response: %s", err)
+	}
+	if !response.OK {
+		if response.Error == "" {
+			response.Error = "unknown error"
+		}
+		return nil, fmt.Errorf("storage plugin %s failed: %s", request.Action, response.Error)
+	}
+	return &response, nil
+}
+
+func (p *pluginStorage) Put(ctx context.Context, objectName, filePath string, opts PutOptions) error {
+	_, err := p.call(ctx, pluginRequest{
+		Action:       "put",
+		Object:       objectName,
+		File:         filePath,
+		ContentType:  opts.ContentType,
+		StorageClass: opts.StorageClass,
+	})
+	return err
+}
+
+func (p *pluginStorage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	response, err := p.call(ctx, pluginRequest{Action: "list", Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]StoredObject, 0, len(response.Objects))
+	for _, object := range response.Objects {
+		objects = append(objects, StoredObject{Name: object.Name, Size: object.Size, LastModified: object.LastModified})
+	}
+	return objects, nil
+}
+
+func (p *pluginStorage) Delete(ctx context.Context, objectName string) error {
+	_, err := p.call(ctx, pluginRequest{Action: "delete", Object: objectName})
+	return err
+}
+
+// Get asks the plugin to download the object into a temporary file, which
+// is removed again when the returned reader is closed
+func (p *pluginStorage) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	file, err := os.CreateTemp("", "plugin-get-*")
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	if _, err := p.call(ctx, pluginRequest{Action: "get", Object: objectName, File: file.Name()}); err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+	reader, err := os.Open(file.Name())
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &removeOnClose{File: reader}, nil
+}
+
+// removeOnClose deletes the underlying file once it has been read
+type removeOnClose struct {
+	*os.File
+}
+
+func (r *removeOnClose) Close() error {
+	err := r.File.Close()
+	os.Remove(r.File.Name())
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// defaultStorageDetails are the global storage settings, which a job's
+// storage block and destinations of the same type inherit
+var defaultStorageDetails StorageDetails
+
+// inherit fills the settings the job left empty from the global storage
+// when both are of the same type, so a job can override just the bucket,
+// prefix or endpoint
+func (details StorageDetails) inherit(defaults StorageDetails) StorageDetails {
+	kind := func(kind string) string {
+		if kind == "" {
+			return "s3"
+		}
+		return strings.ToLower(kind)
+	}
+	if kind(details.Kind) != kind(defaults.Kind) {
+		return details
+	}
+	merged := reflect.ValueOf(&details).Elem()
+	values := reflect.ValueOf(defaults)
+	for i := 0; i < merged.NumField(); i++ {
+		if merged.Type().Field(i).Name != "Name" && merged.Field(i).IsZero() {
+			merged.Field(i).Set(values.Field(i))
+		}
+	}
+	return details
+}
+
+// prefixedStorage keeps every object of a storage under a key prefix, such
+// as a directory per team, and hides it from the rest of the scheduler
+type prefixedStorage struct {
+	inner  Storage
+	prefix string
+}
+
+// withPrefix puts the storage's objects under the prefix, if any
+func withPrefix(storage Storage, prefix string) Storage {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return storage
+	}
+	return &prefixedStorage{inner: storage, prefix: prefix + "/"}
+}
+
+func (s *prefixedStorage) Put(ctx context.Context, objectName, filePath string, opts PutOptions) error {
+	return s.inner.Put(ctx, s.prefix+objectName, filePath, opts)
+}
+
+func (s *prefixedStorage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	objects, err := s.inner.List(ctx, s.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i := range objects {
+		objects[i].Name = strings.TrimPrefix(objects[i].Name, s.prefix)
+	}
+	return objects, nil
+}
+
+func (s *prefixedStorage) Delete(ctx context.Context, objectName string) error {
+	return s.inner.Delete(ctx, s.prefix+objectName)
+}
+
+func (s *prefixedStorage) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	return s.inner.Get(ctx, s.prefix+objectName)
+}
+
+// the optional capabilities are only reached through storageAs, when the
+// inner storage has them
+
+func (s *prefixedStorage) PutStream(ctx context.Context, objectName string, r io.Reader, opts PutOptions) error {
+	return s.inner.(StreamPutter).PutStream(ctx, s.prefix+objectName, r, opts)
+}
+
+func (s *prefixedStorage) Location(objectName string) string {
+	return s.inner.(Locator).Location(s.prefix + objectName)
+}
+
+func (s *prefixedStorage) PresignGet(ctx context.Context, objectName string, expiry time.Duration) (string, error) {
+	return s.inner.(Presigner).PresignGet(ctx, s.prefix+objectName, expiry)
+}
+
+func (s *prefixedStorage) SetStorageClass(ctx context.Context, objectName, storageClass string) error {
+	return s.inner.(Tierer).SetStorageClass(ctx, s.prefix+objectName, storageClass)
+}
+
+// storageAs returns the storage as one of the optional capabilities, such
+// as Locator, when it has it
+func storageAs[T any](storage Storage) (T, bool) {
+	if prefixed, ok := storage.(*prefixedStorage); ok {
+		if _, ok := prefixed.inner.(T); !ok {
+			var none T
+			return none, false
+		}
+	}
+	capability, ok := storage.(T)
+	return capability, ok
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// rcloneStorage hands artifacts to an rclone remote through the rclone
+// binary, reusing whatever remotes the host already has configured
+type rcloneStorage struct {
+	binary string
+	remote string
+	flags  []string
+}
+
+func newRcloneStorage(details StorageDetails) (*rcloneStorage, error) {
+	if err := requireSettings([2]string{"RCLONE_REMOTE", details.RcloneRemote}); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(details.RcloneRemote, ":") {
+		return nil, fmt.Errorf("RCLONE_REMOTE must be in the form remote:path")
+	}
+
+	binary := details.RcloneBinary
+	if binary == "" {
+		binary = "rclone"
+	}
+	if _, err := exec.LookPath(binary); err != nil {
+		return nil, fmt.Errorf("rclone not found: %s", err)
+	}
+
+	flags := strings.Fields(details.RcloneFlags)
+	if details.RcloneConfig != "" {
+		flags = append(flags, "--config", details.RcloneConfig)
+	}
+	return &rcloneStorage{binary: binary, remote: details.RcloneRemote, flags: flags}, nil
+}
+
+// target joins the object name onto the remote, which may be a bare
+// "remote:" or "remote:some/path"
+func (r *rcloneStorage) target(objectName string) string {
+	if strings.HasSuffix(r.remote, ":") {
+		return r.remote + objectName
+	}
+	return strings.TrimRight(r.remote, "/") + "/" + objectName
+}
+
+func (r *rcloneStorage) command(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, r.binary, append(append([]string{}, r.flags...), args...)...)
+}
+
+func (r *rcloneStorage) run(ctx context.Context, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := r.command(ctx, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("rclone %s failed: %s: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (r *rcloneStorage) Put(ctx context.Context, objectName, filePath string, opts PutOptions) error {
+	_, err := r.run(ctx, "copyto", filePath, r.target(objectName))
+	return err
+}
+
+func (r *rcloneStorage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	dir := ""
+	if strings.Contains(prefix, "/") {
+		dir = path.Dir(prefix)
+	}
+	output, err := r.run(ctx, "lsjson", "--recursive", "--files-only", r.target(dir))
+	if err != nil {
+		if strings.Contains(err.Error(), "directory not found") {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []struct {
+		Path    string    `json:"Path"`
+		Size    int64     `json:"Size"`
+		ModTime time.Time `json:"ModTime"`
+	}
+	if err := json.Unmarshal(output, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode rclone listing: %s", err)
+	}
+
+	var objects []StoredObject
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Path)
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, StoredObject{Name: name, Size: entry.Size, LastModified: entry.ModTime})
+		}
+	}
+	return objects, nil
+}
+
+func (r *rcloneStorage) Delete(ctx context.Context, objectName string) error {
+	_, err := r.run(ctx, "deletefile", r.target(objectName))
+	return err
+}
+
+func (r *rcloneStorage) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	var stderr bytes.Buffer
+	cmd := r.command(ctx, "cat", r.target(objectName))
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &rcloneReader{ReadCloser: stdout, cmd: cmd, stderr: &stderr}, nil
+}
+
+// rcloneReader streams `rclone cat` output and reports the command's
+// failure when the stream is closed
+type rcloneReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (r *rcloneReader) Close() error {
+	r.ReadCloser.Close()
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("rclone cat failed: %s: %s", err, strings.TrimSpace(r.stderr.String()))
+	}
+	return nil
+}
+
+func (r *rcloneStorage) Location(objectName string) string {
+	return r.target(objectName)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+type s3Storage struct {
+	client   *minio.Client
+	bucket   string
+	endpoint string
+	// sse is applied to every upload; customerKey is only set for SSE-C,
+	// where reads must present the same key
+	sse         encrypt.ServerSide
+	customerKey encrypt.ServerSide
+}
+
+// serverSideEncryption builds the encryption settings for S3_SSE
+func serverSideEncryption(details StorageDetails) (encrypt.ServerSide, error) {
+	switch strings.ToLower(details.SSE) {
+	case "":
+		return nil, nil
+	case "s3":
+		return encrypt.NewSSE(), nil
+	case "kms":
+		if err := requireSettings([2]string{"S3_SSE_KMS_KEY_ID", details.SSEKMSKeyID}); err != nil {
+			return nil, err
+		}
+		return encrypt.NewSSEKMS(details.SSEKMSKeyID, nil)
+	case "c":
+		if err := requireSettings([2]string{"S3_SSE_C_KEY", details.SSECustomerKey}); err != nil {
+			return nil, err
+		}
+		key, err := base64.StdEncoding.DecodeString(details.SSECustomerKey)
+		if err != nil {
+			return nil, fmt.Errorf("S3_SSE_C_KEY must be base64 encoded: %s", err)
+		}
+		return encrypt.NewSSEC(key)
+	default:
+		return nil, fmt.Errorf("unknown S3_SSE mode %q, expected s3, kms or c", details.SSE)
+	}
+}
+
+func newS3Storage(ctx context.Context, details StorageDetails) (*s3Storage, error) {
+	if err := requireSettings(
+		[2]string{"S3_ENDPOINT", details.ServerURL},
+		[2]string{"S3_REGION", details.Location},
+		[2]string{"S3_BUCKET", details.Container},
+		[2]string{"S3_SECRET_KEY", details.PrivateKey},
+		[2]string{"S3_ACCESS_KEY", details.PublicKey},
+	); err != nil {
+		return nil, err
+	}
+
+	sse, err := serverSideEncryption(details)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server-side encryption settings: %s", err)
+	}
+
+	client, err := minio.New(details.ServerURL, &minio.Options{
+		Creds:  credentials.NewStaticV4(details.PublicKey, details.PrivateKey, ""),
+		Secure: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize MinIO client: %s", err)
+	}
+
+	bucketExists, err := client.BucketExists(ctx, details.Container)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if bucket exists: %s", err)
+	}
+
+	if !bucketExists {
+		if !details.CreateIfMissing {
+			return nil, fmt.Errorf("bucket %s does not exist", details.Container)
+		}
+		if err := client.MakeBucket(ctx, details.Container, minio.MakeBucketOptions{
+			Region:        details.Location,
+			ObjectLocking: details.ObjectLocking,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to create bucket: %s", err)
+		}
+		slog.Info("Bucket was successfully created", slog.String("bucket", details.Container))
+	}
+
+	storage := &s3Storage{client: client, bucket: details.Container, endpoint: details.ServerURL, sse: sse}
+	if strings.EqualFold(details.SSE, "c") {
+		storage.customerKey = sse
+	}
+	return storage, nil
+}
+
+func (s *s3Storage) putOptions(opts PutOptions) minio.PutObjectOptions {
+	putOptions := minio.PutObjectOptions{
+		ContentType:          opts.ContentType,
+		StorageClass:         opts.StorageClass,
+		ServerSideEncryption: s.sse,
+	}
+	if opts.LockMode != "" {
+		putOptions.Mode = minio.RetentionMode(strings.ToUpper(opts.LockMode))
+		putOptions.RetainUntilDate = opts.RetainUntil
+	}
+	return putOptions
+}
+
+func (s *s3Storage) Put(ctx context.Context, objectName, filePath string, opts PutOptions) error {
+	_, err := s.client.FPutObject(ctx, s.bucket, objectName, filePath, s.putOptions(opts))
+	return err
+}
+
+// PutStream uploads the reader as a multipart upload, buffering one part
+// in memory at a time
+func (s *s3Storage) PutStream(ctx context.Context, objectName string, r io.Reader, opts PutOptions) error {
+	putOptions := s.putOptions(opts)
+	putOptions.PartSize = uint64(opts.PartSize)
+	_, err := s.client.PutObject(ctx, s.bucket, objectName, r, -1, putOptions)
+	return err
+}
+
+func (s *s3Storage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	var objects []StoredObject
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, StoredObject{
+			Name:         object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+			StorageClass: object.StorageClass,
+		})
+	}
+	return objects, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, objectName string) error {
+	return s.client.RemoveObject(ctx, s.bucket, objectName, minio.RemoveObjectOptions{})
+}
+
+func (s *s3Storage) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, objectName, minio.GetObjectOptions{ServerSideEncryption: s.customerKey})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy, stat it so a missing object fails here
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, err
+	}
+	return object, nil
+}
+
+// SetStorageClass rewrites the object onto itself with the new class; a
+// compose copy is used as plain copies are limited to 5 GiB
+func (s *s3Storage) SetStorageClass(ctx context.Context, objectName, storageClass string) error {
+	info, err := s.client.StatObject(ctx, s.bucket, objectName, minio.StatObjectOptions{ServerSideEncryption: s.customerKey})
+	if err != nil {
+		return err
+	}
+	// minio sends the storage class as the real header, not as metadata
+	dest := minio.CopyDestOptions{
+		Bucket:          s.bucket,
+		Object:          objectName,
+		Encryption:      s.sse,
+		ReplaceMetadata: true,
+		UserMetadata: map[string]string{
+			"Content-Type":        info.ContentType,
+			"X-Amz-Storage-Class": storageClass,
+		},
+	}
+	// the copy replaces the object, so it must stay locked the same way
+	if mode := info.Metadata.Get("X-Amz-Object-Lock-Mode"); mode != "" {
+		retainUntil, err := time.Parse(time.RFC3339, info.Metadata.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+		if err != nil {
+			return fmt.Errorf("failed to read the object's retention: %s", err)
+		}
+		dest.Mode, dest.RetainUntilDate = minio.RetentionMode(mode), retainUntil
+	}
+	if hold := info.Metadata.Get("X-Amz-Object-Lock-Legal-Hold"); hold != "" {
+		dest.LegalHold = minio.LegalHoldStatus(hold)
+	}
+	_, err = s.client.ComposeObject(ctx, dest, minio.CopySrcOptions{Bucket: s.bucket, Object: objectName, Encryption: s.customerKey})
+	return err
+}
+
+func (s *s3Storage) Location(objectName string) string {
+	return (&url.URL{Scheme: "https", Host: s.endpoint, Path: "/" + s.bucket + "/" + objectName}).String()
+}
+
+func (s *s3Storage) PresignGet(ctx context.Context, objectName string, expiry time.Duration) (string, error) {
+	presigned, err := s.client.PresignedGetObject(ctx, s.bucket, objectName, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return presigned.String(), nil
+}
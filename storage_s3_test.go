@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func TestS3SetStorageClass(t *testing.T) {
+	tests := []struct {
+		name     string
+		stored   map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "unlocked",
+			expected: map[string]string{"X-Amz-Object-Lock-Mode": "", "X-Amz-Object-Lock-Retain-Until-Date": "", "X-Amz-Object-Lock-Legal-Hold": ""},
+		},
+		{
+			name: "retention is kept",
+			stored: map[string]string{
+				"X-Amz-Object-Lock-Mode":              "COMPLIANCE",
+				"X-Amz-Object-Lock-Retain-Until-Date": "2030-01-02T03:04:05.000Z",
+			},
+			expected: map[string]string{
+				"X-Amz-Object-Lock-Mode":              "COMPLIANCE",
+				"X-Amz-Object-Lock-Retain-Until-Date": "2030-01-02T03:04:05Z",
+			},
+		},
+		{
+			name:     "legal hold is kept",
+			stored:   map[string]string{"X-Amz-Object-Lock-Legal-Hold": "ON"},
+			expected: map[string]string{"X-Amz-Object-Lock-Legal-Hold": "ON"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var copied http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodHead && r.URL.Path == "/backups/app.sql":
+					w.Header().Set("Content-Length", "4")
+					w.Header().Set("Content-Type", "application/sql")
+					w.Header().Set("ETag", `"etag"`)
+					w.Header().Set("Last-Modified", "Mon, 04 Mar 2024 02:00:00 GMT")
+					for header, value := range test.stored {
+						w.Header().Set(header, value)
+					}
+				// the object is copied onto itself as a multipart upload of
+				// one part, which takes the new object's headers when it
+				// is started
+				case r.Method == http.MethodPost && r.URL.Query().Has("uploads"):
+					copied = r.Header.Clone()
+					fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>backups</Bucket><Key>app.sql</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`)
+				case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+					fmt.Fprint(w, `<CopyPartResult><LastModified>2024-03-04T02:00:00.000Z</LastModified><ETag>"etag"</ETag></CopyPartResult>`)
+				case r.Method == http.MethodPost && r.URL.Query().Get("uploadId") == "upload":
+					fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>backups</Bucket><Key>app.sql</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+			target, _ := url.Parse(server.URL)
+			client, err := minio.New(target.Host, &minio.Options{Creds: credentials.NewStaticV4("access", "secret", ""), Region: "us-east-1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			storage := &s3Storage{client: client, bucket: "backups"}
+
+			if err := storage.SetStorageClass(context.Background(), "app.sql", "GLACIER"); err != nil {
+				t.Fatal(err)
+			}
+			if copied == nil {
+				t.Fatal("the object wasn't copied")
+			}
+			// a real header, not user metadata
+			if copied.Get("X-Amz-Storage-Class") != "GLACIER" || copied.Get("X-Amz-Meta-X-Amz-Storage-Class") != "" {
+				t.Errorf("storage class sent as %v", copied)
+			}
+			if copied.Get("Content-Type") != "application/sql" {
+				t.Errorf("content type sent as %q", copied.Get("Content-Type"))
+			}
+			for header, value := range test.expected {
+				if got := copied.Get(header); got != value {
+					t.Errorf("%s = %q, want %q", header, got, value)
+				}
+			}
+		})
+	}
+}
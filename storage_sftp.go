@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type sftpStorage struct {
+	addr      string
+	directory string
+	config    *ssh.ClientConfig
+}
+
+func newSFTPStorage(details StorageDetails) (*sftpStorage, error) {
+	knownHostsFile := details.SFTPKnownHostsFile
+	if knownHostsFile == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+	}
+	if err := requireSettings(
+		[2]string{"SFTP_HOST", details.SFTPHost},
+		[2]string{"SFTP_USER", details.SFTPUser},
+		[2]string{"SFTP_PRIVATE_KEY_FILE", details.SFTPPrivateKeyFile},
+		[2]string{"SFTP_KNOWN_HOSTS_FILE", knownHostsFile},
+	); err != nil {
+		return nil, err
+	}
+
+	keyData, err := os.ReadFile(details.SFTPPrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SFTP private key: %s", err)
+	}
+	var signer ssh.Signer
+	if details.SFTPPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(details.SFTPPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(keyData)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SFTP private key: %s", err)
+	}
+
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SFTP known hosts: %s", err)
+	}
+
+	addr := details.SFTPHost
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	return &sftpStorage{
+		addr:      addr,
+		directory: details.SFTPDirectory,
+		config: &ssh.ClientConfig{
+			User:            details.SFTPUser,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+// connect opens a fresh SSH connection and SFTP session; backups run
+// rarely enough that keeping a connection alive between runs isn't worth it
+func (s *sftpStorage) connect() (*ssh.Client, *sftpClient, error) {
+	conn, err := ssh.Dial("tcp", s.addr, s.config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to %s: %s", s.addr, err)
+	}
+	client, err := newSFTPClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, client, nil
+}
+
+func (s *sftpStorage) Put(ctx context.Context, objectName, filePath string, opts PutOptions) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	conn, client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer client.Close()
+
+	// closing the connection unblocks any in-flight request on cancellation
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	remotePath := path.Join(s.directory, objectName)
+	if err := client.MkdirAll(path.Dir(remotePath)); err != nil {
+		return fmt.Errorf("failed to create remote directory: %s", err)
+	}
+	return client.WriteFile(remotePath, file)
+}
+
+func (s *sftpStorage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	conn, client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer client.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// only walk the part of the tree that can contain matching names
+	start := ""
+	if strings.Contains(prefix, "/") {
+		start = path.Dir(prefix)
+	}
+
+	var objects []StoredObject
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		entries, err := client.ReadDir(path.Join(s.directory, rel))
+		if err != nil {
+			var status *sftpStatusError
+			if errors.As(err, &status) && status.Code == sftpStatusNoSuchFile {
+				return nil
+			}
+			return err
+		}
+		for _, entry := range entries {
+			name := path.Join(rel, entry.Name)
+			if entry.IsDir {
+				if err := walk(name); err != nil {
+					return err
+				}
+				continue
+			}
+			if strings.HasPrefix(name, prefix) {
+				objects = append(objects, StoredObject{Name: name, Size: entry.Size, LastModified: entry.ModTime})
+			}
+		}
+		return nil
+	}
+	if err := walk(start); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (s *sftpStorage) Delete(ctx context.Context, objectName string) error {
+	conn, client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer client.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	return client.Remove(path.Join(s.directory, objectName))
+}
+
+func (s *sftpStorage) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	conn, client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := client.OpenReader(path.Join(s.directory, objectName))
+	if err != nil {
+		client.Close()
+		conn.Close()
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return &sftpObject{sftpReader: reader, client: client, conn: conn, stop: stop}, nil
+}
+
+// sftpObject owns the connection backing a download and tears it down on Close
+type sftpObject struct {
+	*sftpReader
+	client *sftpClient
+	conn   *ssh.Client
+	stop   func() bool
+}
+
+func (o *sftpObject) Close() error {
+	o.stop()
+	err := o.sftpReader.Close()
+	o.client.Close()
+	o.conn.Close()
+	return err
+}
+
+func (s *sftpStorage) Location(objectName string) string {
+	return (&url.URL{Scheme: "sftp", User: url.User(s.config.User), Host: s.addr, Path: path.Join("/", s.directory, objectName)}).String()
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStorage keeps objects in memory. failPuts makes that many uploads
+// fail before they succeed again
+type fakeStorage struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modified map[string]time.Time
+	options  map[string]PutOptions
+	failPuts int
+	puts     int
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{objects: map[string][]byte{}, modified: map[string]time.Time{}, options: map[string]PutOptions{}}
+}
+
+func (s *fakeStorage) Put(ctx context.Context, objectName, filePath string, opts PutOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.puts++
+	if s.failPuts > 0 {
+		s.failPuts--
+		return errors.New("storage unavailable")
+	}
+	s.objects[objectName], s.modified[objectName], s.options[objectName] = data, time.Now(), opts
+	return nil
+}
+
+func (s *fakeStorage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var objects []StoredObject
+	for name, data := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, StoredObject{Name: name, Size: int64(len(data)), LastModified: s.modified[name]})
+		}
+	}
+	slices.SortFunc(objects, func(a, b StoredObject) int { return strings.Compare(a.Name, b.Name) })
+	return objects, nil
+}
+
+func (s *fakeStorage) Delete(ctx context.Context, objectName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, objectName)
+	return nil
+}
+
+func (s *fakeStorage) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[objectName]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// names returns the names of the stored objects, sorted
+func (s *fakeStorage) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestUploadFile(t *testing.T) {
+	artifact := filepath.Join(t.TempDir(), "app.sql")
+	os.WriteFile(artifact, []byte("dump"), 0o600)
+	healthy, broken := newFakeStorage(), newFakeStorage()
+	broken.failPuts = 1
+	destinations := []Destination{{Name: "primary", Storage: healthy}, {Name: "offsite", Storage: broken}}
+
+	uploaded := uploadFile(context.Background(), destinations, "app.sql", artifact, PutOptions{StorageClass: "STANDARD_IA"}, discardLogger)
+	if len(uploaded) != 1 || uploaded[0].Name != "primary" {
+		t.Fatalf("got %v, want only primary", uploaded)
+	}
+	if got := string(healthy.objects["app.sql"]); got != "dump" {
+		t.Errorf("stored %q", got)
+	}
+	if got := healthy.options["app.sql"].StorageClass; got != "STANDARD_IA" {
+		t.Errorf("storage class %q was not passed on", got)
+	}
+	// a failing destination doesn't keep the others from being tried
+	if broken.puts != 1 {
+		t.Errorf("offsite got %d uploads, want 1", broken.puts)
+	}
+}
+
+func TestUploadFileCancelled(t *testing.T) {
+	artifact := filepath.Join(t.TempDir(), "app.sql")
+	os.WriteFile(artifact, []byte("dump"), 0o600)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	storage := newFakeStorage()
+	if uploaded := uploadFile(ctx, []Destination{{Name: "primary", Storage: storage}}, "app.sql", artifact, PutOptions{}, discardLogger); len(uploaded) != 0 {
+		t.Fatalf("a cancelled upload reported success")
+	}
+}
+
+func TestPrefixedStorage(t *testing.T) {
+	inner := newFakeStorage()
+	storage := withPrefix(inner, "/team-a/")
+	artifact := filepath.Join(t.TempDir(), "app.sql")
+	os.WriteFile(artifact, []byte("dump"), 0o600)
+	ctx := context.Background()
+
+	if err := storage.Put(ctx, "app.sql", artifact, PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := inner.names(); !slices.Equal(got, []string{"team-a/app.sql"}) {
+		t.Fatalf("inner storage holds %v", got)
+	}
+	objects, _ := storage.List(ctx, "")
+	if len(objects) != 1 || objects[0].Name != "app.sql" {
+		t.Fatalf("listed %v", objects)
+	}
+	if err := storage.Delete(ctx, "app.sql"); err != nil || len(inner.names()) != 0 {
+		t.Fatalf("delete left %v", inner.names())
+	}
+	if withPrefix(inner, "") != Storage(inner) {
+		t.Error("an empty prefix wrapped the storage")
+	}
+}
+
+// testRunTask is a task whose script writes a small artifact
+func testRunTask(t *testing.T) BackupTask {
+	t.Helper()
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("needs sh")
+	}
+	return BackupTask{
+		Name:            "db",
+		Commands:        stringList{"echo dump > ${TEMP_DIR}/app.sql"},
+		TargetFilePaths: stringList{"${TEMP_DIR}/app.sql"},
+	}
+}
+
+func TestRunUploadsToEveryDestination(t *testing.T) {
+	task := testRunTask(t)
+	primary, offsite := newFakeStorage(), newFakeStorage()
+	destinations := []Destination{{Name: "primary", Storage: primary}, {Name: "offsite", Storage: offsite}}
+	report := &RunReport{Task: task.Name, BackupID: "abc12345"}
+
+	if err := task.run(context.Background(), report, destinations, discardLogger); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Objects) != 1 {
+		t.Fatalf("got objects %v", report.Objects)
+	}
+	object := report.Objects[0]
+	if name, id, ok := parseFileName(object); !ok || name != "db" || id != "abc12345" {
+		t.Errorf("object %s isn't named after the run", object)
+	}
+	for _, storage := range []*fakeStorage{primary, offsite} {
+		if got := string(storage.objects[object]); got != "dump\n" {
+			t.Errorf("stored %q", got)
+		}
+		if _, ok := storage.objects[checksumName(object)]; !ok {
+			t.Errorf("no checksum stored next to %s", object)
+		}
+	}
+}
+
+func TestRunFailsOnMissingDestination(t *testing.T) {
+	task := testRunTask(t)
+	broken := newFakeStorage()
+	broken.failPuts = 100
+	destinations := []Destination{{Name: "primary", Storage: newFakeStorage()}, {Name: "offsite", Storage: broken}}
+
+	err := task.run(context.Background(), &RunReport{Task: task.Name, BackupID: "abc12345"}, destinations, discardLogger)
+	if err == nil || !strings.Contains(err.Error(), "missing from 1 of 2 destinations") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestRunWithRetries(t *testing.T) {
+	task := testRunTask(t)
+	task.Retries = 2
+	task.RetryDelay = Duration(time.Millisecond)
+	storage := newFakeStorage()
+	// the first attempt fails on the artifact upload
+	storage.failPuts = 1
+	report := &RunReport{Task: task.Name, BackupID: "abc12345"}
+
+	if err := task.runWithRetries(context.Background(), report, []Destination{{Name: "primary", Storage: storage}}, discardLogger); err != nil {
+		t.Fatal(err)
+	}
+	if report.Attempts != 2 {
+		t.Errorf("got %d attempts, want 2", report.Attempts)
+	}
+	if len(report.Objects) != 1 || storage.objects[report.Objects[0]] == nil {
+		t.Errorf("the retried run's artifact wasn't stored: %v", storage.names())
+	}
+}
+
+func TestRunWithRetriesGivesUp(t *testing.T) {
+	task := testRunTask(t)
+	task.Retries = 1
+	task.RetryDelay = Duration(time.Millisecond)
+	storage := newFakeStorage()
+	storage.failPuts = 100
+	report := &RunReport{Task: task.Name, BackupID: "abc12345"}
+
+	if err := task.runWithRetries(context.Background(), report, []Destination{{Name: "primary", Storage: storage}}, discardLogger); err == nil {
+		t.Fatal("expected the run to fail")
+	}
+	if report.Attempts != 2 {
+		t.Errorf("got %d attempts, want 2", report.Attempts)
+	}
+}
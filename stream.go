@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StreamConfig makes a task upload the standard output of its last script
+// command directly, instead of a file the script wrote. Huge dumps then
+// never touch the local disk
+type StreamConfig struct {
+	// Extension is appended to the object name, e.g. ".sql"
+	Extension string `yaml:"extension"`
+	// PartSize is the multipart chunk size, one part per destination is
+	// buffered in memory; it caps the object size at 10000 parts
+	PartSize ByteSize `yaml:"part_size"`
+}
+
+func (task BackupTask) validateStream() error {
+	if len(task.Commands) == 0 && len(task.Command) == 0 && task.ScriptFile == "" {
+		return fmt.Errorf("streaming needs a command that writes the backup to stdout, or a script whose last command does")
+	}
+	if len(task.TargetFilePaths) > 0 {
+		return fmt.Errorf("filepath_to_upload can't be used together with stream")
+	}
+	if task.Split != nil {
+		return fmt.Errorf("split can't be used together with stream")
+	}
+	if task.Compression != nil && task.Compression.Format == "xz" {
+		return fmt.Errorf("xz compression can't be used together with stream, use gzip or zstd")
+	}
+	if task.Encryption != nil && task.Encryption.enabled() && task.Encryption.Type != "gpg" {
+		return fmt.Errorf("%s encryption can't be used together with stream, use gpg", task.Encryption.Type)
+	}
+	return nil
+}
+
+// streamBackup runs the task's script and uploads the last command's
+// output to every destination as it is produced. It returns the
+// destinations that received the whole stream
+func (task BackupTask) streamBackup(ctx context.Context, report *RunReport, process scriptProcess, destinations []Destination, opts PutOptions, logger *slog.Logger) ([]Destination, error) {
+	for _, destination := range destinations {
+		if _, ok := storageAs[StreamPutter](destination.Storage); !ok {
+			return nil, fmt.Errorf("storage %s does not support streaming uploads", destination.Name)
+		}
+	}
+
+	var script string
+	if commands := task.Commands; len(task.Command) == 0 {
+		if len(commands) > 1 {
+			if err := executeBackup(ctx, commands[:len(commands)-1], process, logger); err != nil {
+				return nil, fmt.Errorf("failed during backup execution: %s", err)
+			}
+		}
+		script = commands[len(commands)-1]
+	}
+
+	objectName := generateFileName(task.Name, report.BackupID, task.Stream.Extension+task.encodingExtension())
+	streamStarted := time.Now()
+
+	opts.ContentType = "application/octet-stream"
+	opts.PartSize = int64(task.Stream.PartSize)
+	if opts.PartSize == 0 {
+		opts.PartSize = 64 << 20
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fanout := &fanoutWriter{}
+	results := make([]error, len(destinations))
+	var wg sync.WaitGroup
+	for i, destination := range destinations {
+		reader, writer := io.Pipe()
+		fanout.writers = append(fanout.writers, writer)
+		wg.Add(1)
+		go func(i int, destination Destination) {
+			defer wg.Done()
+			results[i] = destination.Storage.(StreamPutter).PutStream(ctx, objectName, reader, opts)
+			// unblock the command if the upload gave up early
+			reader.CloseWithError(fmt.Errorf("upload to %s stopped", destination.Name))
+		}(i, destination)
+	}
+
+	// layers are closed outermost first so each flushes into the next
+	var output io.Writer = fanout
+	var layers []io.WriteCloser
+	var err error
+	if task.Encryption != nil && task.Encryption.enabled() {
+		var layer io.WriteCloser
+		if layer, err = task.Encryption.newWriter(ctx, output, strings.TrimSuffix(objectName, task.Encryption.extension())); err == nil {
+			layers, output = append([]io.WriteCloser{layer}, layers...), layer
+		}
+	}
+	if err == nil && task.Compression != nil {
+		var layer io.WriteCloser
+		if layer, err = task.Compression.newWriter(output); err == nil {
+			layers, output = append([]io.WriteCloser{layer}, layers...), layer
+		}
+	}
+
+	if err == nil {
+		var cmd *exec.Cmd
+		if len(task.Command) > 0 {
+			cmd = processCommand(ctx, task.Command, process)
+		} else {
+			cmd = scriptCommand(ctx, script, process)
+		}
+		cmd.Stdout = output
+		cmd.Stderr = process.output(newLogger(logger, true))
+		err = cmd.Run()
+	}
+	for _, layer := range layers {
+		if closeErr := layer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		// abort the uploads so no truncated backup is stored
+		cancel()
+	}
+	for _, writer := range fanout.writers {
+		writer.CloseWithError(err)
+	}
+
+	wg.Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed during backup execution: %s", err)
+	}
+	report.Objects = append(report.Objects, objectName)
+	report.Size = fanout.written
+	report.UploadDuration = time.Since(streamStarted)
+
+	var uploaded []Destination
+	for i, destination := range destinations {
+		destinationLogger := logger.With(slog.String("destination", destination.Name))
+		if results[i] == nil && fanout.failed(i) {
+			results[i] = fanout.errs[i]
+		}
+		if results[i] != nil {
+			destinationLogger.Error("Failed to stream the backup to object storage", slog.String("error", results[i].Error()))
+			continue
+		}
+		destinationLogger.Info("Streamed the backup to object storage", slog.String("object", objectName))
+		uploaded = append(uploaded, destination)
+	}
+	report.URLs = objectLocations(uploaded, objectName)
+	task.emitUploaded(report, uploaded, objectName, logger)
+	return uploaded, nil
+}
+
+// fanoutWriter copies everything written to it to each of its writers,
+// dropping writers that fail so one broken destination doesn't stop the
+// others. It only fails once every writer has
+type fanoutWriter struct {
+	writers []*io.PipeWriter
+	errs    []error
+	written int64
+}
+
+func (f *fanoutWriter) Write(p []byte) (int, error) {
+	if f.errs == nil {
+		f.errs = make([]error, len(f.writers))
+	}
+	active := 0
+	for i, writer := range f.writers {
+		if f.errs[i] != nil {
+			continue
+		}
+		if _, err := writer.Write(p); err != nil {
+			f.errs[i] = err
+			continue
+		}
+		active++
+	}
+	if active == 0 {
+		return 0, errors.Join(f.errs...)
+	}
+	f.written += int64(len(p))
+	return len(p), nil
+}
+
+func (f *fanoutWriter) failed(i int) bool {
+	return f.errs != nil && f.errs[i] != nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxSuggestionDistance is how many edits apart an unknown field and the
+// known one it is taken for may be
+const maxSuggestionDistance = 2
+
+var (
+	unmarshalerType        = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	notificationConfigType = reflect.TypeOf(NotificationConfig{})
+	compressionConfigType  = reflect.TypeOf(CompressionConfig{})
+)
+
+// checkKnownFields reports every key of the parsed configuration that
+// doesn't match a field of the type it is decoded into, such as a typo in
+// schedule, with its line. yaml.v3's own strict mode doesn't reach values
+// decoded by custom unmarshalers, like notification channels
+func checkKnownFields(node *yaml.Node, t reflect.Type) error {
+	var problems []error
+	walkKnownFields(node, t, &problems)
+	return errors.Join(problems...)
+}
+
+func walkKnownFields(node *yaml.Node, t reflect.Type, problems *[]error) {
+	if node.Kind == yaml.DocumentNode {
+		for _, child := range node.Content {
+			walkKnownFields(child, t, problems)
+		}
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == notificationConfigType:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if newNotifier, ok := notifierTypes[node.Content[i].Value]; ok {
+				walkKnownFields(node.Content[i+1], reflect.TypeOf(newNotifier()), problems)
+			}
+		}
+		return
+	case t == compressionConfigType && node.Kind == yaml.MappingNode:
+		// the mapping form decodes as a plain struct
+	case reflect.PointerTo(t).Implements(unmarshalerType):
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields := map[string]reflect.Type{}
+		collectYAMLFields(t, fields)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				continue
+			}
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				problem := fmt.Sprintf("line %d: unknown field %q", key.Line, key.Value)
+				if suggestion := closestField(key.Value, fields); suggestion != "" {
+					problem += fmt.Sprintf(", did you mean %q?", suggestion)
+				}
+				*problems = append(*problems, errors.New(problem))
+				continue
+			}
+			walkKnownFields(value, fieldType, problems)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for _, item := range node.Content {
+			walkKnownFields(item, t.Elem(), problems)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 1; i < len(node.Content); i += 2 {
+			walkKnownFields(node.Content[i], t.Elem(), problems)
+		}
+	}
+}
+
+// collectYAMLFields maps the keys a struct is decoded from to their types,
+// following yaml.v3's rules for tags and inlined structs
+func collectYAMLFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("yaml")
+		name, options, _ := strings.Cut(tag, ",")
+		if tag == "-" {
+			continue
+		}
+		if strings.Contains(options, "inline") {
+			collectYAMLFields(field.Type, fields)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+}
+
+// closestField returns the known field a mistyped key most likely meant,
+// if any is close enough
+func closestField(key string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", maxSuggestionDistance+1
+	for name := range fields {
+		if distance := editDistance(key, name); distance < bestDistance || distance == bestDistance && name < best {
+			best, bestDistance = name, distance
+		}
+	}
+	if bestDistance > maxSuggestionDistance {
+		return ""
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyTemplates expands the jobs of a parsed configuration file that
+// instantiate one of its templates, which are then removed. The template's
+// values may refer to the job's parameters, and its name, as {{ .param }};
+// the job's own settings win over the template's
+func applyTemplates(document *yaml.Node) error {
+	root := document
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	i := mappingIndex(root, "templates")
+	if i < 0 {
+		return nil
+	}
+	templates := root.Content[i+1]
+	root.Content = slices.Delete(root.Content, i, i+2)
+	if templates.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: templates must be a mapping", templates.Line)
+	}
+
+	jobs := mappingValue(root, "jobs")
+	if jobs == nil || jobs.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for _, job := range jobs.Content {
+		if job.Kind != yaml.MappingNode {
+			continue
+		}
+		templateName := mappingValue(job, "template")
+		if templateName == nil {
+			continue
+		}
+		jobTemplate := mappingValue(templates, templateName.Value)
+		if jobTemplate == nil || jobTemplate.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: unknown template %q", templateName.Line, templateName.Value)
+		}
+
+		parameters := map[string]string{}
+		if name := mappingValue(job, "name"); name != nil {
+			parameters["name"] = name.Value
+		}
+		if values := mappingValue(job, "parameters"); values != nil {
+			if values.Kind != yaml.MappingNode {
+				return fmt.Errorf("line %d: parameters must be a mapping", values.Line)
+			}
+			for i := 0; i+1 < len(values.Content); i += 2 {
+				parameters[values.Content[i].Value] = values.Content[i+1].Value
+			}
+		}
+		for _, key := range []string{"template", "parameters"} {
+			if i := mappingIndex(job, key); i >= 0 {
+				job.Content = slices.Delete(job.Content, i, i+2)
+			}
+		}
+
+		expanded, err := instantiate(jobTemplate, parameters)
+		if err != nil {
+			return fmt.Errorf("line %d: template %s: %s", templateName.Line, templateName.Value, err)
+		}
+		mergeMapping(job, expanded)
+	}
+	return nil
+}
+
+// instantiate returns a copy of the template node with the parameters
+// filled into its values
+func instantiate(node *yaml.Node, parameters map[string]string) (*yaml.Node, error) {
+	copied := *node
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "{{") {
+		parsed, err := template.New("").Option("missingkey=error").Parse(node.Value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", node.Line, err)
+		}
+		var value strings.Builder
+		if err := parsed.Execute(&value, parameters); err != nil {
+			return nil, fmt.Errorf("line %d: %s", node.Line, err)
+		}
+		copied.Value = value.String()
+		// a value that is just a placeholder has to be quoted in YAML, but
+		// takes the type of what is filled in, e.g. a number
+		trimmed := strings.TrimSpace(node.Value)
+		if copied.Style == 0 || strings.HasPrefix(trimmed, "{{") && strings.HasSuffix(trimmed, "}}") && strings.Count(trimmed, "{{") == 1 {
+			copied.Style, copied.Tag = 0, ""
+		}
+	}
+	copied.Content = nil
+	for _, child := range node.Content {
+		child, err := instantiate(child, parameters)
+		if err != nil {
+			return nil, err
+		}
+		copied.Content = append(copied.Content, child)
+	}
+	return &copied, nil
+}
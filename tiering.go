@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// TieringPolicy moves a task's backups to a colder storage class once they
+// are older than After, keeping recent restores fast and old ones cheap
+type TieringPolicy struct {
+	After        Duration `yaml:"after"`
+	StorageClass string   `yaml:"storage_class"`
+}
+
+func (policy TieringPolicy) validate() error {
+	if policy.After <= 0 {
+		return fmt.Errorf("tiering after must be positive")
+	}
+	if policy.StorageClass == "" {
+		return fmt.Errorf("tiering storage_class is required")
+	}
+	return nil
+}
+
+// Tierer is implemented by storages that can change the storage class of
+// an object they already hold
+type Tierer interface {
+	SetStorageClass(ctx context.Context, objectName, storageClass string) error
+}
+
+// applyTiering transitions the task's backups older than the policy's age
+// to its storage class
+func (task BackupTask) applyTiering(ctx context.Context, destination Destination, logger *slog.Logger) {
+	if task.Tiering == nil {
+		return
+	}
+	logger = logger.With(slog.String("destination", destination.Name))
+
+	tierer, ok := storageAs[Tierer](destination.Storage)
+	if !ok {
+		logger.Warn("Storage does not support storage classes, skipping tiering")
+		return
+	}
+
+	backups, err := backupsOf(ctx, destination.Storage, task.Name)
+	if err != nil {
+		logger.Error("Failed to list backups for tiering", slog.String("error", err.Error()))
+		return
+	}
+
+	cutoff := time.Now().Add(-time.Duration(task.Tiering.After))
+	for _, backup := range backups {
+		if backup.LastModified.After(cutoff) || strings.EqualFold(backup.StorageClass, task.Tiering.StorageClass) {
+			continue
+		}
+		if err := tierer.SetStorageClass(ctx, backup.Name, task.Tiering.StorageClass); err != nil {
+			logger.Error("Failed to change the storage class of a backup", slog.String("object", backup.Name), slog.String("error", err.Error()))
+			continue
+		}
+		logger.Info("Moved backup to colder storage", slog.String("object", backup.Name), slog.String("storage_class", task.Tiering.StorageClass))
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tomlParser reads a TOML document into the same yaml.Node tree a YAML
+// configuration file is parsed into, so the rest of the loading, from
+// interpolation to strict decoding, doesn't need to know the difference
+type tomlParser struct {
+	data string
+	pos  int
+	line int
+}
+
+var (
+	tomlBareKey  = regexp.MustCompile(`^[A-Za-z0-9_-]+`)
+	tomlInteger  = regexp.MustCompile(`^[+-]?(0x[0-9A-Fa-f_]+|0o[0-7_]+|0b[01_]+|[0-9_]+)$`)
+	tomlFloat    = regexp.MustCompile(`^[+-]?([0-9_]+(\.[0-9_]+)?([eE][+-]?[0-9_]+)?|inf|nan)$`)
+	tomlDateTime = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}([Tt ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?([Zz]|[+-]\d{2}:\d{2})?)?$|^\d{2}:\d{2}:\d{2}(\.\d+)?$`)
+)
+
+// parseTOML returns the TOML document as a YAML document node
+func parseTOML(data []byte) (*yaml.Node, error) {
+	p := &tomlParser{data: string(data), line: 1}
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: 1, Column: 1}
+	current := root
+	for {
+		p.skipBlank()
+		if p.done() {
+			break
+		}
+		line := p.line
+		var err error
+		switch {
+		case strings.HasPrefix(p.rest(), "[["):
+			p.pos += 2
+			var keys []string
+			if keys, err = p.parseKey(); err == nil {
+				if err = p.expect("]]"); err == nil {
+					current, err = tomlArrayTable(root, keys, line)
+				}
+			}
+		case strings.HasPrefix(p.rest(), "["):
+			p.pos++
+			var keys []string
+			if keys, err = p.parseKey(); err == nil {
+				if err = p.expect("]"); err == nil {
+					current, err = tomlTable(root, keys, line)
+				}
+			}
+		default:
+			err = p.parseKeyValue(current)
+		}
+		if err == nil {
+			err = p.endOfLine()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", p.line, err)
+		}
+	}
+	return &yaml.Node{Kind: yaml.DocumentNode, Line: 1, Column: 1, Content: []*yaml.Node{root}}, nil
+}
+
+func (p *tomlParser) done() bool    { return p.pos >= len(p.data) }
+func (p *tomlParser) rest() string  { return p.data[p.pos:] }
+func (p *tomlParser) peek() byte    { return p.data[p.pos] }
+func (p *tomlParser) advance(n int) { p.line += strings.Count(p.data[p.pos:p.pos+n], "\n"); p.pos += n }
+
+func (p *tomlParser) skipSpaces() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func (p *tomlParser) skipComment() {
+	if !p.done() && p.peek() == '#' {
+		for !p.done() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments
+func (p *tomlParser) skipBlank() {
+	for {
+		p.skipSpaces()
+		p.skipComment()
+		if p.done() || (p.peek() != '\n' && p.peek() != '\r') {
+			return
+		}
+		p.advance(1)
+	}
+}
+
+func (p *tomlParser) expect(token string) error {
+	p.skipSpaces()
+	if !strings.HasPrefix(p.rest(), token) {
+		return fmt.Errorf("expected %q", token)
+	}
+	p.pos += len(token)
+	return nil
+}
+
+// endOfLine checks that nothing but a comment follows on the line
+func (p *tomlParser) endOfLine() error {
+	p.skipSpaces()
+	p.skipComment()
+	if p.done() {
+		return nil
+	}
+	if strings.HasPrefix(p.rest(), "\r\n") || p.peek() == '\n' {
+		return nil
+	}
+	return fmt.Errorf("unexpected %q after a value", p.rest()[:1])
+}
+
+// parseKey reads a dotted key, such as jobs.compress or "a b".c
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpaces()
+		if p.done() {
+			return nil, fmt.Errorf("expected a key")
+		}
+		var key string
+		switch p.peek() {
+		case '"', '\'':
+			value, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			key = value
+		default:
+			key = tomlBareKey.FindString(p.rest())
+			if key == "" {
+				return nil, fmt.Errorf("expected a key")
+			}
+			p.pos += len(key)
+		}
+		keys = append(keys, key)
+		p.skipSpaces()
+		if p.done() || p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *tomlParser) parseKeyValue(table *yaml.Node) error {
+	line := p.line
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	return tomlSet(table, keys, value, line)
+}
+
+func (p *tomlParser) parseValue() (*yaml.Node, error) {
+	p.skipSpaces()
+	if p.done() {
+		return nil, fmt.Errorf("expected a value")
+	}
+	line := p.line
+	switch p.peek() {
+	case '"', '\'':
+		value, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Line: line}, nil
+	case '[':
+		p.pos++
+		array := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line}
+		for {
+			p.skipBlank()
+			if p.done() {
+				return nil, fmt.Errorf("unterminated array")
+			}
+			if p.peek() == ']' {
+				p.pos++
+				return array, nil
+			}
+			item, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			array.Content = append(array.Content, item)
+			p.skipBlank()
+			if !p.done() && p.peek() == ',' {
+				p.pos++
+			} else if p.done() || p.peek() != ']' {
+				return nil, fmt.Errorf("expected , or ] in array")
+			}
+		}
+	case '{':
+		p.pos++
+		table := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line}
+		p.skipSpaces()
+		if !p.done() && p.peek() == '}' {
+			p.pos++
+			return table, nil
+		}
+		for {
+			if err := p.parseKeyValue(table); err != nil {
+				return nil, err
+			}
+			p.skipSpaces()
+			if p.done() {
+				return nil, fmt.Errorf("unterminated inline table")
+			}
+			switch p.peek() {
+			case ',':
+				p.pos++
+			case '}':
+				p.pos++
+				return table, nil
+			default:
+				return nil, fmt.Errorf("expected , or } in inline table")
+			}
+		}
+	}
+
+	end := strings.IndexAny(p.rest(), ",]}#\r\n")
+	if end < 0 {
+		end = len(p.rest())
+	}
+	token := strings.TrimSpace(p.rest()[:end])
+	p.pos += end
+	node := &yaml.Node{Kind: yaml.ScalarNode, Line: line}
+	switch plain := strings.ReplaceAll(token, "_", ""); {
+	case token == "true" || token == "false":
+		node.Tag, node.Value = "!!bool", token
+	case tomlInteger.MatchString(token):
+		number, err := strconv.ParseInt(plain, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %s", token)
+		}
+		node.Tag, node.Value = "!!int", strconv.FormatInt(number, 10)
+	case tomlFloat.MatchString(token):
+		node.Tag, node.Value = "!!float", plain
+		if strings.HasSuffix(plain, "inf") || strings.HasSuffix(plain, "nan") {
+			node.Value = strings.TrimPrefix(strings.Replace(plain, "inf", ".inf", 1), "+")
+			node.Value = strings.Replace(node.Value, "nan", ".nan", 1)
+		}
+	case tomlDateTime.MatchString(token):
+		node.Tag, node.Value = "!!str", token
+	default:
+		return nil, fmt.Errorf("invalid value %q", token)
+	}
+	return node, nil
+}
+
+// parseString reads a basic, literal or multi-line string
+func (p *tomlParser) parseString() (string, error) {
+	for _, quotes := range []string{`"""`, `'''`} {
+		if !strings.HasPrefix(p.rest(), quotes) {
+			continue
+		}
+		p.pos += 3
+		end := strings.Index(p.rest(), quotes)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated multi-line string")
+		}
+		// up to two quotes may directly precede the closing ones
+		for end+3 < len(p.rest()) && p.rest()[end+3] == quotes[0] {
+			end++
+		}
+		raw := p.rest()[:end]
+		p.advance(end + 3)
+		raw = strings.TrimPrefix(strings.TrimPrefix(raw, "\r"), "\n")
+		if quotes == `'''` {
+			return raw, nil
+		}
+		return tomlUnescape(raw, true)
+	}
+
+	quote := p.peek()
+	p.pos++
+	for end := p.pos; end < len(p.data); end++ {
+		switch p.data[end] {
+		case '\n':
+			return "", fmt.Errorf("unterminated string")
+		case '\\':
+			if quote == '"' {
+				end++
+			}
+		case quote:
+			raw := p.data[p.pos:end]
+			p.pos = end + 1
+			if quote == '\'' {
+				return raw, nil
+			}
+			return tomlUnescape(raw, false)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// tomlUnescape resolves the escapes of a basic string. In multi-line ones a
+// backslash at the end of a line joins it with the next non-blank text
+func tomlUnescape(raw string, multiline bool) (string, error) {
+	var value strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			value.WriteByte(raw[i])
+			continue
+		}
+		i++
+		if i >= len(raw) {
+			return "", fmt.Errorf("invalid escape at the end of a string")
+		}
+		switch raw[i] {
+		case 'b':
+			value.WriteByte('\b')
+		case 't':
+			value.WriteByte('\t')
+		case 'n':
+			value.WriteByte('\n')
+		case 'f':
+			value.WriteByte('\f')
+		case 'r':
+			value.WriteByte('\r')
+		case '"':
+			value.WriteByte('"')
+		case '\\':
+			value.WriteByte('\\')
+		case 'u', 'U':
+			size := 4
+			if raw[i] == 'U' {
+				size = 8
+			}
+			if i+size >= len(raw) {
+				return "", fmt.Errorf("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(raw[i+1:i+1+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", fmt.Errorf("invalid unicode escape")
+			}
+			value.WriteRune(rune(code))
+			i += size
+		default:
+			rest := strings.TrimLeft(raw[i:], " \t")
+			if !multiline || !(strings.HasPrefix(rest, "\n") || strings.HasPrefix(rest, "\r\n")) {
+				return "", fmt.Errorf("invalid escape \\%c", raw[i])
+			}
+			i = len(raw) - len(strings.TrimLeft(rest, " \t\r\n")) - 1
+		}
+	}
+	return value.String(), nil
+}
+
+// tomlSet adds a value to a table under a dotted key
+func tomlSet(table *yaml.Node, keys []string, value *yaml.Node, line int) error {
+	for _, key := range keys[:len(keys)-1] {
+		next, err := tomlChild(table, key, line)
+		if err != nil {
+			return err
+		}
+		table = next
+	}
+	key := keys[len(keys)-1]
+	if mappingIndex(table, key) >= 0 {
+		return fmt.Errorf("duplicate key %s", key)
+	}
+	table.Content = append(table.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key, Line: line}, value)
+	return nil
+}
+
+// tomlChild returns the table under key, creating it if needed. For an
+// array of tables, that is its latest table
+func tomlChild(table *yaml.Node, key string, line int) (*yaml.Node, error) {
+	child := mappingValue(table, key)
+	switch {
+	case child == nil:
+		child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line}
+		table.Content = append(table.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key, Line: line}, child)
+		return child, nil
+	case child.Kind == yaml.MappingNode:
+		return child, nil
+	case child.Kind == yaml.SequenceNode && len(child.Content) > 0 && child.Content[len(child.Content)-1].Kind == yaml.MappingNode:
+		return child.Content[len(child.Content)-1], nil
+	default:
+		return nil, fmt.Errorf("%s is not a table", key)
+	}
+}
+
+// tomlTable returns the table of a [a.b] header
+func tomlTable(root *yaml.Node, keys []string, line int) (*yaml.Node, error) {
+	table := root
+	for _, key := range keys {
+		next, err := tomlChild(table, key, line)
+		if err != nil {
+			return nil, err
+		}
+		table = next
+	}
+	return table, nil
+}
+
+// tomlArrayTable appends a table to the array of a [[a.b]] header
+func tomlArrayTable(root *yaml.Node, keys []string, line int) (*yaml.Node, error) {
+	parent, err := tomlTable(root, keys[:len(keys)-1], line)
+	if err != nil {
+		return nil, err
+	}
+	key := keys[len(keys)-1]
+	array := mappingValue(parent, key)
+	if array == nil {
+		array = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line}
+		parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key, Line: line}, array)
+	} else if array.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s is not an array of tables", key)
+	}
+	table := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line}
+	array.Content = append(array.Content, table)
+	return table, nil
+}
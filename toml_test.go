@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		yaml string
+	}{
+		{
+			name: "scalars",
+			toml: "a = 1\nb = -0x1f\nc = 1_000\nd = 3.5e2\ne = true\nf = \"text\" # comment\ng = 'C:\\path'\nh = -inf\n",
+			yaml: "{a: 1, b: -31, c: 1000, d: 350.0, e: true, f: text, g: 'C:\\path', h: -.inf}",
+		},
+		{
+			name: "dates stay strings",
+			toml: "at = 1979-05-27T07:32:00Z\nday = 1979-05-27\ntime = 07:32:00\n",
+			yaml: "{at: '1979-05-27T07:32:00Z', day: '1979-05-27', time: '07:32:00'}",
+		},
+		{
+			name: "escapes",
+			toml: `s = "tab\there \"quoted\" \u00e9 \U0001F600"`,
+			yaml: `{s: "tab\there \"quoted\" é 😀"}`,
+		},
+		{
+			name: "multi-line strings",
+			toml: "basic = \"\"\"\nline one\nline \\\n    two\"\"\"\nliteral = '''\nraw \\n text'''\nquotes = \"\"\"say \"hi\"\"\"\"\n",
+			yaml: "{basic: \"line one\\nline two\", literal: 'raw \\n text', quotes: 'say \"hi\"'}",
+		},
+		{
+			name: "arrays and inline tables",
+			toml: "list = [1, 2,\n  3, # trailing comma\n]\nnested = [[\"a\"], []]\npoint = { x = 1, y.z = \"deep\" }\nempty = {}\n",
+			yaml: "{list: [1, 2, 3], nested: [[a], []], point: {x: 1, y: {z: deep}}, empty: {}}",
+		},
+		{
+			name: "tables and dotted keys",
+			toml: "top = 1\n[storage.s3]\nbucket = \"b\"\n\"quoted key\".x = 2\n[storage]\nkind = 's3'\n",
+			yaml: "{top: 1, storage: {s3: {bucket: b, quoted key: {x: 2}}, kind: s3}}",
+		},
+		{
+			name: "arrays of tables",
+			toml: "[[jobs]]\nname = \"db\"\n[jobs.retention]\nkeep_last = 3\n[[jobs]]\nname = \"web\"\nschedule = [\"0 2 * * *\"]\n",
+			yaml: "{jobs: [{name: db, retention: {keep_last: 3}}, {name: web, schedule: ['0 2 * * *']}]}",
+		},
+		{
+			name: "windows line endings",
+			toml: "a = 1\r\n[b]\r\nc = \"d\"\r\n",
+			yaml: "{a: 1, b: {c: d}}",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node, err := parseTOML([]byte(test.toml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want any
+			if err := node.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(test.yaml), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		toml string
+		err  string
+	}{
+		{"a = 1\na = 2\n", "line 2: duplicate key a"},
+		{"a = 1\n[a]\n", "line 2: a is not a table"},
+		{"[a]\n[[a]]\n", "line 2: a is not an array of tables"},
+		{"a = \"open\n", "line 1: unterminated string"},
+		{"a = \"\"\"open\n", "line 1: unterminated multi-line string"},
+		{"a = [1,\n", "line 2: unterminated array"},
+		{"a = [1, 2\n", "line 2: expected , or ] in array"},
+		{"a = {x = 1", "line 1: unterminated inline table"},
+		{"a = {x = 1\n", "line 1: expected , or } in inline table"},
+		{"a = 1 2\n", "line 1: invalid value \"1 2\""},
+		{"a = \"\\q\"\n", "line 1: invalid escape \\q"},
+		{"a = \"\\u12\"\n", "line 1: invalid unicode escape"},
+		{"a = yes\n", "line 1: invalid value \"yes\""},
+		{"= 1\n", "line 1: expected a key"},
+		{"a 1\n", "line 1: expected \"=\""},
+		{"[a\n", "line 1: expected \"]\""},
+		{"\n\n[a] b = 1\n", "line 3: unexpected \"b\" after a value"},
+		{"a = 0x\n", "line 1: invalid value \"0x\""},
+	}
+	for _, test := range tests {
+		_, err := parseTOML([]byte(test.toml))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("parseTOML(%q) = %v, want %q", test.toml, err, test.err)
+		}
+	}
+}
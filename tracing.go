@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracer exports every run as a trace to an OTLP/HTTP collector, using the
+// JSON encoding so no protobuf or SDK dependency is needed
+type tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+}
+
+// runTracer is nil unless an OTLP endpoint is configured
+var runTracer *tracer
+
+// newTracer follows the standard OTEL_EXPORTER_OTLP_* settings: endpoint
+// is the collector base URL that /v1/traces is appended to, headers a
+// comma-separated list of key=value pairs
+func newTracer(endpoint, tracesEndpoint, headers, service string) *tracer {
+	if tracesEndpoint == "" {
+		if endpoint == "" {
+			return nil
+		}
+		tracesEndpoint = strings.TrimRight(endpoint, "/") + "/v1/traces"
+	}
+	if service == "" {
+		service = "poc-gocron"
+	}
+	t := &tracer{endpoint: tracesEndpoint, headers: map[string]string{}, service: service}
+	for _, pair := range strings.Split(headers, ",") {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			t.headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return t
+}
+
+// runTrace collects the spans of one run under a root span
+type runTrace struct {
+	tracer *tracer
+	id     string
+	root   *span
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+type span struct {
+	trace      *runTrace
+	id         string
+	parent     string
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        error
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// newTrace starts the trace of a run of the task
+func (t *tracer) newTrace(task, backupID string) *runTrace {
+	if t == nil {
+		return nil
+	}
+	rt := &runTrace{tracer: t, id: randomID(16)}
+	rt.root = &span{
+		trace: rt,
+		id:    randomID(8),
+		name:  "backup " + task,
+		start: time.Now(),
+		attributes: map[string]string{
+			"backup.task": task,
+			"backup.id":   backupID,
+		},
+	}
+	return rt
+}
+
+// start opens a child span of the run; attributes are key, value pairs.
+// It returns nil when the run isn't traced, which span methods accept
+func (rt *runTrace) start(name string, attributes ...string) *span {
+	if rt == nil {
+		return nil
+	}
+	s := &span{trace: rt, id: randomID(8), parent: rt.root.id, name: name, start: time.Now(), attributes: map[string]string{}}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		s.attributes[attributes[i]] = attributes[i+1]
+	}
+	return s
+}
+
+func (s *span) set(key, value string) {
+	if s != nil {
+		s.attributes[key] = value
+	}
+}
+
+// finish closes the span, marking it failed when err isn't nil
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	s.trace.mu.Lock()
+	s.trace.spans = append(s.trace.spans, s)
+	s.trace.mu.Unlock()
+}
+
+// export closes the root span and sends the trace to the collector
+func (rt *runTrace) export(err error) error {
+	if rt == nil {
+		return nil
+	}
+	rt.root.end, rt.root.err = time.Now(), err
+	rt.mu.Lock()
+	spans := append([]*span{rt.root}, rt.spans...)
+	rt.mu.Unlock()
+
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, s.encode())
+	}
+	payload := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": encodeAttributes(map[string]string{"service.name": rt.tracer.service}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "poc-gocron"},
+				"spans": encoded,
+			}},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return postJSON(ctx, rt.tracer.endpoint, payload, rt.tracer.headers)
+}
+
+// encode renders the span as OTLP JSON, where IDs are hex strings and
+// timestamps decimal strings of nanoseconds
+func (s *span) encode() map[string]any {
+	status := map[string]any{"code": 1}
+	if s.err != nil {
+		status = map[string]any{"code": 2, "message": s.err.Error()}
+	}
+	encoded := map[string]any{
+		"traceId":           s.trace.id,
+		"spanId":            s.id,
+		"name":              s.name,
+		"kind":              1,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        encodeAttributes(s.attributes),
+		"status":            status,
+	}
+	if s.parent != "" {
+		encoded["parentSpanId"] = s.parent
+	}
+	return encoded
+}
+
+func encodeAttributes(attributes map[string]string) []map[string]any {
+	encoded := make([]map[string]any, 0, len(attributes))
+	for key, value := range attributes {
+		encoded = append(encoded, map[string]any{"key": key, "value": map[string]string{"stringValue": value}})
+	}
+	return encoded
+}
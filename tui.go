@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// logTail keeps the latest log lines in memory while the dashboard owns
+// the terminal
+type logTail struct {
+	mu    sync.Mutex
+	size  int
+	lines []string
+}
+
+func (t *logTail) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		t.lines = append(t.lines, line)
+	}
+	if len(t.lines) > t.size {
+		t.lines = t.lines[len(t.lines)-t.size:]
+	}
+	return len(data), nil
+}
+
+// last returns up to n of the latest lines
+func (t *logTail) last(n int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n > len(t.lines) {
+		n = len(t.lines)
+	}
+	return append([]string(nil), t.lines[len(t.lines)-n:]...)
+}
+
+// dashboard draws the jobs and the log tail into the terminal and handles
+// keys to select, trigger and pause jobs
+type dashboard struct {
+	logs     *logTail
+	started  bool
+	selected int
+	rows     int
+	columns  int
+}
+
+// tuiCommand runs the scheduler with a live dashboard instead of log output
+func tuiCommand(args []string) int {
+	flags := flag.NewFlagSet("tui", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	logs := &logTail{size: 500}
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+	d := &dashboard{logs: logs, rows: 24, columns: 80}
+	runScheduler(d.run)
+	if !d.started {
+		// the scheduler failed to start, its logs tell why
+		for _, line := range logs.last(logs.size) {
+			fmt.Fprintln(os.Stderr, line)
+		}
+		return 1
+	}
+	return 0
+}
+
+// stty changes the settings of the terminal on stdin
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+// run shows the dashboard until q is pressed or the process is told to
+// stop
+func (d *dashboard) run() {
+	d.started = true
+	if size, err := stty("size"); err == nil {
+		fmt.Sscan(size, &d.rows, &d.columns)
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to set up the terminal: %s\n", err)
+		return
+	}
+	defer stty("sane")
+	fmt.Print("\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[H\x1b[2J")
+
+	keys := make(chan byte)
+	go func() {
+		buffer := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buffer); err != nil {
+				close(keys)
+				return
+			}
+			keys <- buffer[0]
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	message := ""
+	for {
+		d.render(message)
+		select {
+		case <-signals:
+			return
+		case <-ticker.C:
+		case key, ok := <-keys:
+			if !ok {
+				return
+			}
+			tasks := control.snapshot()
+			switch key {
+			case 'q':
+				return
+			case 'k', 'A':
+				d.selected = max(d.selected-1, 0)
+			case 'j', 'B':
+				d.selected = max(min(d.selected+1, len(tasks)-1), 0)
+			case 'r':
+				if d.selected < len(tasks) {
+					message = "Triggered " + tasks[d.selected].Name
+					if err := control.trigger(tasks[d.selected].Name); err != nil {
+						message = err.Error()
+					}
+				}
+			case 'P':
+				paused := !control.isPausedAll()
+				control.setPausedAll(paused)
+				message = "Resumed all jobs"
+				if paused {
+					message = "Paused all jobs, runs in progress finish"
+				}
+			case 'p':
+				if d.selected < len(tasks) {
+					task := tasks[d.selected]
+					control.setPaused(task.Name, !task.Paused)
+					message = "Paused " + task.Name
+					if task.Paused {
+						message = "Resumed " + task.Name
+					}
+				}
+			}
+		}
+	}
+}
+
+func (d *dashboard) render(message string) {
+	tasks := control.snapshot()
+	var screen bytes.Buffer
+	fmt.Fprintf(&screen, "poc-gocron  %s   ↑/↓ select  r run now  p pause/resume  P pause/resume all  q quit\n", time.Now().Format(time.TimeOnly))
+	fmt.Fprintf(&screen, "%s\n\n", message)
+
+	table := tabwriter.NewWriter(&screen, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "  JOB\tSTATUS\tSCHEDULE\tLAST RUN\tNEXT RUN")
+	for i, task := range tasks {
+		cursor := " "
+		if i == d.selected {
+			cursor = ">"
+		}
+		status := "idle"
+		switch {
+		case task.Running:
+			status = "running"
+		case task.Paused || control.isPausedAll():
+			status = "paused"
+		}
+		lastRun := "-"
+		if len(task.History) > 0 {
+			lastRun = runLine(task.History[len(task.History)-1])
+		}
+		nextRun := "-"
+		if !task.NextRun.IsZero() {
+			nextRun = task.NextRun.Format("Jan 2 15:04:05")
+		}
+		fmt.Fprintf(table, "%s %s\t%s\t%s\t%s\t%s\n", cursor, task.Name, status, task.Schedule, lastRun, nextRun)
+	}
+	table.Flush()
+
+	used := len(tasks) + 4
+	if d.selected < len(tasks) {
+		task := tasks[d.selected]
+		fmt.Fprintf(&screen, "\nRecent runs of %s\n", task.Name)
+		history := task.History[max(len(task.History)-5, 0):]
+		for i := len(history) - 1; i >= 0; i-- {
+			fmt.Fprintf(&screen, "  %s\n", runLine(history[i]))
+		}
+		if len(history) == 0 {
+			fmt.Fprintln(&screen, "  none yet")
+		}
+		used += max(len(history), 1) + 2
+	}
+
+	fmt.Fprintln(&screen, "\nOutput")
+	for _, line := range d.logs.last(max(d.rows-used-3, 1)) {
+		if len(line) > d.columns-2 {
+			line = line[:d.columns-2]
+		}
+		fmt.Fprintf(&screen, "  %s\n", line)
+	}
+
+	fmt.Print("\x1b[H\x1b[2J")
+	os.Stdout.Write(screen.Bytes())
+}
+
+// runLine summarizes a finished run on one line
+func runLine(report RunReport) string {
+	outcome := "ok"
+	if !report.Succeeded {
+		outcome = "failed: " + report.Error
+	}
+	line := fmt.Sprintf("%s %s in %s", report.Started.Format("Jan 2 15:04"), outcome, report.Duration.Round(time.Second))
+	if report.Succeeded && report.Size > 0 {
+		line += ", " + ByteSize(report.Size).String()
+	}
+	return line
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// validate checks the whole configuration up front and returns every
+// problem at once, each prefixed with the job and setting it is about,
+// e.g. "jobs[2] (database): compress: unknown compression format"
+func (specs BackupSpecifications) validate() error {
+	var problems []error
+	if specs.MaxConcurrentJobs < 0 {
+		problems = append(problems, fmt.Errorf("max_concurrent_jobs: must not be negative"))
+	}
+	// top-level settings are checked through the tasks they apply to
+	names := validateTaskNames(specs.Tasks)
+	problems = append(problems, names...)
+	for i, task := range specs.Tasks {
+		if task.Enabled != nil && !*task.Enabled {
+			continue
+		}
+		for _, problem := range errorList(task.validate()) {
+			problems = append(problems, fmt.Errorf("%s: %s", taskPath(i, task), problem))
+		}
+	}
+	// dependencies are only followed between well named tasks
+	if len(names) == 0 {
+		if err := validateDependencies(specs.Tasks); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// validateTaskNames checks that every task has a name of its own, which
+// artifacts, metrics and dependencies are keyed by
+func validateTaskNames(tasks []BackupTask) []error {
+	var problems []error
+	seen := map[string]int{}
+	for i, task := range tasks {
+		if task.Name == "" {
+			problems = append(problems, fmt.Errorf("%s: name is required", taskPath(i, task)))
+			continue
+		}
+		if first, ok := seen[task.Name]; ok {
+			problems = append(problems, fmt.Errorf("%s: duplicate name, also used by jobs[%d]", taskPath(i, task), first))
+			continue
+		}
+		seen[task.Name] = i
+	}
+	return problems
+}
+
+// taskPath names a task in the configuration file for error messages
+func taskPath(index int, task BackupTask) string {
+	if task.Name == "" {
+		return fmt.Sprintf("jobs[%d]", index)
+	}
+	return fmt.Sprintf("jobs[%d] (%s)", index, task.Name)
+}
+
+// errorList flattens errors joined with errors.Join
+func errorList(err error) []error {
+	if err == nil {
+		return nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var list []error
+	for _, err := range joined.Unwrap() {
+		list = append(list, errorList(err)...)
+	}
+	return list
+}
+
+// logProblems logs each of the joined errors on a line of its own
+func logProblems(message string, err error) {
+	for _, problem := range errorList(err) {
+		slog.Error(message, slog.String("error", problem.Error()))
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vault reads credentials from HashiCorp Vault when VAULT_ADDR is set
+var vault *vaultClient
+
+// vaultSecret is a secret read from Vault, with its lease when it has one
+type vaultSecret struct {
+	data          map[string]any
+	leaseID       string
+	leaseDuration time.Duration
+	renewable     bool
+}
+
+// vaultClient talks to Vault's HTTP API with a token. Secrets are read once
+// and cached; their leases, and the token's, are renewed in the background
+type vaultClient struct {
+	address   string
+	token     string
+	namespace string
+	client    *http.Client
+
+	mu      sync.Mutex
+	secrets map[string]*vaultSecret
+}
+
+func newVaultClient(address, token, namespace string) (*vaultClient, error) {
+	if token == "" {
+		return nil, fmt.Errorf("VAULT_ADDR needs VAULT_TOKEN to be set")
+	}
+	return &vaultClient{
+		address:   strings.TrimRight(address, "/"),
+		token:     token,
+		namespace: namespace,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		secrets:   map[string]*vaultSecret{},
+	}, nil
+}
+
+// request calls the Vault API and decodes its response into result
+func (v *vaultClient) request(ctx context.Context, method, path string, body, result any) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.address+"/v1/"+strings.TrimLeft(path, "/"), &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return fmt.Errorf("vault returned %s: %s", resp.Status, strings.Join(failure.Errors, "; "))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// read returns the secret at path, from the cache when it was read before.
+// Version 2 key/value secrets are unwrapped
+func (v *vaultClient) read(ctx context.Context, path string) (*vaultSecret, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if secret, ok := v.secrets[path]; ok {
+		return secret, nil
+	}
+
+	var response struct {
+		LeaseID       string         `json:"lease_id"`
+		LeaseDuration int            `json:"lease_duration"`
+		Renewable     bool           `json:"renewable"`
+		Data          map[string]any `json:"data"`
+	}
+	if err := v.request(ctx, http.MethodGet, path, nil, &response); err != nil {
+		return nil, fmt.Errorf("failed to read vault secret %s: %s", path, err)
+	}
+	data := response.Data
+	if nested, ok := data["data"].(map[string]any); ok && data["metadata"] != nil {
+		data = nested
+	}
+	secret := &vaultSecret{
+		data:          data,
+		leaseID:       response.LeaseID,
+		leaseDuration: time.Duration(response.LeaseDuration) * time.Second,
+		renewable:     response.Renewable,
+	}
+	v.secrets[path] = secret
+	return secret, nil
+}
+
+// field returns one field of a secret, referenced as "path#field"
+func (v *vaultClient) field(ctx context.Context, reference string) (string, error) {
+	path, field, ok := strings.Cut(reference, "#")
+	if !ok {
+		return "", fmt.Errorf("vault secret %q must be given as path#field", reference)
+	}
+	secret, err := v.read(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := secret.data[field]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no field %s", path, field)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// environment returns the variables of the task's vault_secrets, sorted by
+// name, as NAME=value
+func (v *vaultClient) environment(ctx context.Context, secrets map[string]string) ([]string, error) {
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var env []string
+	for _, name := range names {
+		value, err := v.field(ctx, secrets[name])
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, name+"="+value)
+	}
+	return env, nil
+}
+
+// keepRenewed renews the token and the leases of the secrets read so far
+// when half of their duration has passed
+func (v *vaultClient) keepRenewed() {
+	if v == nil {
+		return
+	}
+	go func() {
+		renewed := map[string]time.Time{}
+		tokenRenewed := time.Now()
+		tokenTTL := time.Duration(0)
+		var lookup struct {
+			Data struct {
+				TTL       int  `json:"ttl"`
+				Renewable bool `json:"renewable"`
+			} `json:"data"`
+		}
+		if err := v.request(context.Background(), http.MethodGet, "auth/token/lookup-self", nil, &lookup); err != nil {
+			slog.Warn("Failed to look up the vault token", slog.String("error", err.Error()))
+		} else if lookup.Data.Renewable {
+			tokenTTL = time.Duration(lookup.Data.TTL) * time.Second
+		}
+
+		for range time.Tick(time.Minute) {
+			if tokenTTL > 0 && time.Since(tokenRenewed) > tokenTTL/2 {
+				var response struct {
+					Auth struct {
+						LeaseDuration int `json:"lease_duration"`
+					} `json:"auth"`
+				}
+				if err := v.request(context.Background(), http.MethodPost, "auth/token/renew-self", map[string]any{}, &response); err != nil {
+					slog.Warn("Failed to renew the vault token", slog.String("error", err.Error()))
+				} else {
+					tokenRenewed = time.Now()
+					tokenTTL = time.Duration(response.Auth.LeaseDuration) * time.Second
+				}
+			}
+
+			v.mu.Lock()
+			secrets := maps.Clone(v.secrets)
+			v.mu.Unlock()
+			for path, secret := range secrets {
+				if !secret.renewable || secret.leaseID == "" {
+					continue
+				}
+				if last, ok := renewed[path]; !ok {
+					renewed[path] = time.Now()
+					continue
+				} else if time.Since(last) < secret.leaseDuration/2 {
+					continue
+				}
+				var response struct {
+					LeaseDuration int `json:"lease_duration"`
+				}
+				body := map[string]any{"lease_id": secret.leaseID}
+				if err := v.request(context.Background(), http.MethodPut, "sys/leases/renew", body, &response); err != nil {
+					slog.Warn("Failed to renew a vault lease", slog.String("secret", path), slog.String("error", err.Error()))
+					continue
+				}
+				renewed[path] = time.Now()
+				v.mu.Lock()
+				secret.leaseDuration = time.Duration(response.LeaseDuration) * time.Second
+				v.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// loadVault connects to Vault when VAULT_ADDR is set and takes the storage
+// credentials from VAULT_S3_PATH
+func (settings *Config) loadVault() error {
+	if settings.VaultAddress == "" {
+		return nil
+	}
+	client, err := newVaultClient(settings.VaultAddress, settings.VaultToken, settings.VaultNamespace)
+	if err != nil {
+		return err
+	}
+	vault = client
+	if settings.VaultS3Path == "" {
+		return nil
+	}
+	ctx := context.Background()
+	if settings.StorageConfig.PublicKey, err = vault.field(ctx, settings.VaultS3Path+"#access_key"); err != nil {
+		return err
+	}
+	if settings.StorageConfig.PrivateKey, err = vault.field(ctx, settings.VaultS3Path+"#secret_key"); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// checksumName is the object holding an artifact's SHA-256, in the format
+// of sha256sum so a downloaded artifact can be checked with sha256sum -c
+func checksumName(objectName string) string {
+	return objectName + ".sha256"
+}
+
+// uploadChecksum stores the artifact's SHA-256 next to it in the
+// destinations that received it, for the verify command. Artifacts split
+// into parts are covered by their manifest instead
+func uploadChecksum(ctx context.Context, destinations []Destination, objectName, filePath, workDir string, opts PutOptions, logger *slog.Logger) {
+	if len(destinations) == 0 {
+		return
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		logger.Warn("Failed to compute the backup checksum", slog.String("error", err.Error()))
+		return
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	file.Close()
+	if err != nil {
+		logger.Warn("Failed to compute the backup checksum", slog.String("error", err.Error()))
+		return
+	}
+
+	checksumPath := filepath.Join(workDir, filepath.Base(checksumName(objectName)))
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(hash.Sum(nil)), path.Base(objectName))
+	if err := os.WriteFile(checksumPath, []byte(line), 0o600); err != nil {
+		logger.Warn("Failed to write the backup checksum", slog.String("error", err.Error()))
+		return
+	}
+	defer os.Remove(checksumPath)
+
+	opts.ContentType = "text/plain"
+	uploadFile(ctx, destinations, checksumName(objectName), checksumPath, opts, logger)
+}
+
+// verifyCommand downloads the recent runs of the jobs and checks them
+// against their recorded checksums and split manifests, reporting corrupt
+// and missing objects
+func verifyCommand(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	jobName := flags.String("job", "", "only verify the backups of this job")
+	runs := flags.Int("runs", 3, "how many of the latest runs to verify per job and destination")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	_, storage, backupPlans, err := loadEnvironment()
+	if err != nil {
+		slog.Error("Failed to initialize", slog.String("error", err.Error()))
+		return 1
+	}
+
+	ctx := context.Background()
+	found, failed := false, false
+	for _, task := range backupPlans.Tasks {
+		if *jobName != "" && task.Name != *jobName {
+			continue
+		}
+		found = true
+		destinations, err := task.resolveDestinations(storage)
+		if err != nil {
+			slog.Error("Failed to initialize task storage", slog.String("error", err.Error()), slog.String("backup_task", task.Name))
+			failed = true
+			continue
+		}
+		for _, destination := range destinations {
+			logger := slog.With(slog.String("backup_task", task.Name), slog.String("destination", destination.Name))
+			backups, err := backupsOf(ctx, destination.Storage, task.Name)
+			if err != nil {
+				logger.Error("Failed to list backups", slog.String("error", err.Error()))
+				failed = true
+				continue
+			}
+
+			// backups are sorted newest first
+			var ids []string
+			objects := map[string]map[string]bool{}
+			for _, backup := range backups {
+				_, id, _ := parseFileName(backup.Name)
+				if objects[id] == nil {
+					if len(ids) == *runs {
+						continue
+					}
+					ids = append(ids, id)
+					objects[id] = map[string]bool{}
+				}
+				objects[id][backup.Name] = true
+			}
+			for _, id := range ids {
+				problems := verifyRun(ctx, destination.Storage, objects[id], logger.With(slog.String("id", id)))
+				if problems > 0 {
+					failed = true
+				}
+			}
+		}
+	}
+
+	if *jobName != "" && !found {
+		slog.Error("No such job", slog.String("backup_task", *jobName))
+		return 1
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// verifyRun checks the objects of one run and returns how many problems it
+// found
+func verifyRun(ctx context.Context, storage Storage, objects map[string]bool, logger *slog.Logger) int {
+	problems := 0
+	for name := range objects {
+		objectLogger := logger.With(slog.String("object", name))
+		switch {
+		case partPattern.MatchString(name):
+			// checked through the manifest
+		case isRunLog(name, objects):
+			// script output, not a backup
+		case strings.HasSuffix(name, ".sha256"):
+			if !objects[strings.TrimSuffix(name, ".sha256")] {
+				objectLogger.Error("Backup is missing, only its checksum exists")
+				problems++
+			}
+		case strings.HasSuffix(name, ".manifest.json"):
+			problems += verifyManifest(ctx, storage, name, objects, objectLogger)
+		case objects[checksumName(name)]:
+			expected, err := readChecksum(ctx, storage, checksumName(name))
+			if err != nil {
+				objectLogger.Error("Failed to read the backup checksum", slog.String("error", err.Error()))
+				problems++
+				continue
+			}
+			actual, _, err := objectChecksum(ctx, storage, name)
+			if err != nil {
+				objectLogger.Error("Failed to download the backup", slog.String("error", err.Error()))
+				problems++
+				continue
+			}
+			if actual != expected {
+				objectLogger.Error("Backup is corrupt, its checksum doesn't match", slog.String("expected", expected), slog.String("actual", actual))
+				problems++
+				continue
+			}
+			objectLogger.Info("Backup verified")
+		default:
+			objectLogger.Warn("No checksum recorded for the backup, skipping it")
+		}
+	}
+	return problems
+}
+
+// verifyManifest checks every part listed in a split manifest
+func verifyManifest(ctx context.Context, storage Storage, name string, objects map[string]bool, logger *slog.Logger) int {
+	reader, err := storage.Get(ctx, name)
+	if err != nil {
+		logger.Error("Failed to download the manifest", slog.String("error", err.Error()))
+		return 1
+	}
+	var manifest splitManifest
+	err = json.NewDecoder(reader).Decode(&manifest)
+	reader.Close()
+	if err != nil {
+		logger.Error("Failed to read the manifest", slog.String("error", err.Error()))
+		return 1
+	}
+
+	problems := 0
+	for _, part := range manifest.Parts {
+		partLogger := logger.With(slog.String("part", part.Name))
+		if !objects[part.Name] {
+			partLogger.Error("Backup part is missing")
+			problems++
+			continue
+		}
+		actual, size, err := objectChecksum(ctx, storage, part.Name)
+		if err != nil {
+			partLogger.Error("Failed to download the backup part", slog.String("error", err.Error()))
+			problems++
+			continue
+		}
+		if actual != part.SHA256 || size != part.Size {
+			partLogger.Error("Backup part is corrupt, its checksum doesn't match", slog.String("expected", part.SHA256), slog.String("actual", actual))
+			problems++
+		}
+	}
+	if problems == 0 {
+		logger.Info("Backup verified", slog.Int("parts", len(manifest.Parts)))
+	}
+	return problems
+}
+
+// readChecksum returns the hex digest stored in a checksum object
+func readChecksum(ctx context.Context, storage Storage, name string) (string, error) {
+	reader, err := storage.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	line, err := bufio.NewReader(reader).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("checksum object is empty")
+	}
+	return fields[0], nil
+}
+
+// objectChecksum downloads the object and returns its SHA-256 and size
+func objectChecksum(ctx context.Context, storage Storage, name string) (string, int64, error) {
+	reader, err := storage.Get(ctx, name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer reader.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, reader)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}